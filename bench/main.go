@@ -6,9 +6,9 @@ import (
 	"encoding/hex"
 	"fmt"
 	math "github.com/IBM/mathlib"
-	"github.com/syndtr/goleveldb/leveldb"
 	"math/big"
 	"os"
+	"pol/kv"
 	"pol/pol"
 	"strconv"
 	"time"
@@ -256,12 +256,18 @@ func retryUntilSuccess(iterations int, measurementsByFanout map[uint16]*measurem
 
 func benchmarkFanout(iterations int, measurementsByFanout map[uint16]*measurement, population int, treeType pol.TreeType, genID idFromRandBytes, fanOut uint16) (somethingWentWrong error) {
 	fmt.Println("Benchmarking fanout", fanOut, "...")
-	id2Path, pp := pol.GeneratePublicParams(fanOut, treeType)
+	id2Path, pp, err := pol.GeneratePublicParams(fanOut, treeType)
+	if err != nil {
+		return err
+	}
 
-	/*	db := NewDB()
+	/*	db, err := NewDB()
+		if err != nil {
+			return err
+		}
 		defer db.Destroy()*/
 
-	db := make(MemDB)
+	db := make(kv.MemDB)
 
 	defer func() {
 		/*		if e := recover(); e != nil {
@@ -283,16 +289,18 @@ func benchmarkFanout(iterations int, measurementsByFanout map[uint16]*measuremen
 		}
 		id := genID(buff)
 		idBuffs[iteration] = id
-		ls.Set(id, 666)
+		if err := ls.Set(id, big.NewInt(666)); err != nil {
+			return err
+		}
 	}
 
 	V, W := ls.Root()
 
 	for iteration := 0; iteration < iterations; iteration++ {
 		fmt.Println("iteration", iteration)
-		_, π, elapsedTimes, ok := ls.ProveLiability(idBuffs[iteration])
-		if !ok {
-			panic("liability not found!!")
+		_, π, elapsedTimes, err := ls.ProveLiability(idBuffs[iteration])
+		if err != nil {
+			panic(err)
 		}
 		measurementsByFanout[fanOut].proofTime = append(measurementsByFanout[fanOut].proofTime, elapsedTimes[2])
 		measurementsByFanout[fanOut].equalityTimeProve = append(measurementsByFanout[fanOut].equalityTimeProve, elapsedTimes[1])
@@ -322,7 +330,10 @@ func benchmarkVerifyTot(iterations int, ls *pol.LiabilitySet, pp *pol.PublicPara
 
 	for iteration := 0; iteration < iterations; iteration++ {
 		t1 := time.Now()
-		totProof := ls.ProveTot()
+		totProof, err := ls.ProveTot()
+		if err != nil {
+			panic(err)
+		}
 		elapsed := time.Since(t1)
 		proveTots = append(proveTots, elapsed)
 
@@ -344,19 +355,19 @@ func populateLiabilitySet(population int, ls *pol.LiabilitySet, genID idFromRand
 	fmt.Println("Populating liability set...")
 
 	for i := 0; i < population/1000; i++ {
-		idBuffs := make([]string, 1000)
+		liabilities := make(map[string]*big.Int, 1000)
 		for j := 0; j < 1000; j++ {
 			buff := make([]byte, 32)
 			_, err := rand.Read(buff)
 			if err != nil {
 				panic(err)
 			}
-			idBuffs[j] = genID(buff)
+			liabilities[genID(buff)] = big.NewInt(int64(j))
 		}
 
 		start := time.Now()
-		for j := 0; j < 1000; j++ {
-			ls.Set(idBuffs[j], int64(j))
+		if err := ls.SetBatch(liabilities); err != nil {
+			panic(err)
 		}
 		elapsed := time.Since(start)
 		constructionTime += elapsed
@@ -374,7 +385,10 @@ func measurePPGen(m *measurements) {
 		m.dense[fanOut] = &measurement{}
 		for iteration := 0; iteration < m.iterations; iteration++ {
 			start := time.Now()
-			_, pp := pol.GeneratePublicParams(fanOut, pol.Dense)
+			_, pp, err := pol.GeneratePublicParams(fanOut, pol.Dense)
+			if err != nil {
+				panic(err)
+			}
 			elapsed := time.Since(start)
 			m.dense[fanOut].ppGenTime = append(m.dense[fanOut].ppGenTime, elapsed)
 			m.dense[fanOut].ppSize = append(m.dense[fanOut].ppSize, pp.Size()/1024)
@@ -387,7 +401,10 @@ func measurePPGen(m *measurements) {
 		m.sparse[fanOut] = &measurement{}
 		for iteration := 0; iteration < m.iterations; iteration++ {
 			start := time.Now()
-			_, pp := pol.GeneratePublicParams(fanOut, pol.Sparse)
+			_, pp, err := pol.GeneratePublicParams(fanOut, pol.Sparse)
+			if err != nil {
+				panic(err)
+			}
 			elapsed := time.Since(start)
 			m.sparse[fanOut].ppGenTime = append(m.sparse[fanOut].ppGenTime, elapsed)
 			m.sparse[fanOut].ppSize = append(m.sparse[fanOut].ppSize, pp.Size()/1024)
@@ -411,46 +428,20 @@ func getIterations() int {
 	return int(iterations)
 }
 
+// DB is a LevelDB database in a scratch directory, which is removed once the DB is destroyed
 type DB struct {
-	levelDB *leveldb.DB
+	*kv.LevelDB
 }
 
-func NewDB() *DB {
-	db, err := leveldb.OpenFile("levelDB", nil)
+func NewDB() (*DB, error) {
+	db, err := kv.OpenLevelDB("levelDB")
 	if err != nil {
-		panic(err)
-	}
-	return &DB{levelDB: db}
-}
-
-func (db *DB) Get(key []byte) []byte {
-	data, err := db.levelDB.Get(key, nil)
-	if err != nil && err != leveldb.ErrNotFound {
-		panic(err)
-	}
-	if err == leveldb.ErrNotFound {
-		return nil
-	}
-	return data
-}
-
-func (db *DB) Put(key []byte, val []byte) {
-	if err := db.levelDB.Put(key, val, nil); err != nil {
-		panic(err)
+		return nil, err
 	}
+	return &DB{LevelDB: db}, nil
 }
 
 func (db *DB) Destroy() {
-	db.levelDB.Close()
+	db.Close()
 	os.RemoveAll("levelDB")
 }
-
-type MemDB map[string][]byte
-
-func (m MemDB) Get(key []byte) []byte {
-	return m[string(key)]
-}
-
-func (m MemDB) Put(key []byte, val []byte) {
-	m[string(key)] = val
-}
@@ -0,0 +1,218 @@
+package bp
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/bits"
+	"pol/common"
+	"sync"
+
+	math "github.com/IBM/mathlib"
+)
+
+var curve = math.Curves[1]
+
+// msm is a linear combination of group elements, which is computed in a single multi-scalar multiplication.
+// The scalars of group elements that are added several times, such as generators shared by several proofs, are merged.
+type msm struct {
+	points  common.G1v
+	scalars common.Vec
+	index   map[*math.G1]int
+}
+
+func newMSM() *msm {
+	return &msm{
+		index: make(map[*math.G1]int),
+	}
+}
+
+func (m *msm) add(P *math.G1, x *math.Zr) {
+	if i, exists := m.index[P]; exists {
+		m.scalars[i] = curve.ModAdd(m.scalars[i], x, curve.GroupOrder)
+		return
+	}
+	m.index[P] = len(m.points)
+	m.points = append(m.points, P)
+	m.scalars = append(m.scalars, x)
+}
+
+func (m *msm) addV(Ps common.G1v, xs common.Vec) {
+	for i := range Ps {
+		m.add(Ps[i], xs[i])
+	}
+}
+
+// merge adds the linear combination of the other msm, multiplied by the given weight.
+func (m *msm) merge(other *msm, weight *math.Zr) {
+	for i := range other.points {
+		m.add(other.points[i], other.scalars[i].Mul(weight))
+	}
+}
+
+func (m *msm) isIdentity() bool {
+	if len(m.points) == 0 {
+		return true
+	}
+	return common.MultiExp(m.points, m.scalars).IsInfinity()
+}
+
+// randomWeight returns a random scalar, by which an equation is multiplied before it is added to a batch of equations,
+// so that the batch sums to the identity only if every equation does, except with negligible probability.
+func randomWeight() *math.Zr {
+	return curve.NewRandomZr(rand.Reader)
+}
+
+// foldingCoefficients returns the coefficients s of generators g, such that folding g round by round,
+// where in round r the left half is multiplied by left[r] and the right half by right[r] and then they are added,
+// results in Σ s[i]·g[i]. The first round splits g by the most significant bit of its indices.
+func foldingCoefficients(left, right common.Vec) common.Vec {
+	s := common.Vec{common.IntToZr(1)}
+	for r := range left {
+		next := make(common.Vec, 2*len(s))
+		for i, si := range s {
+			next[2*i] = si.Mul(left[r])
+			next[2*i+1] = si.Mul(right[r])
+		}
+		s = next
+	}
+	return s
+}
+
+// rounds returns the number of rounds it takes to fold n generators into one.
+func rounds(n int) (int, error) {
+	k := bits.Len(uint(n)) - 1
+	if n == 0 || 1<<k != n {
+		return 0, fmt.Errorf("number of generators should be a power of two but is %d", n)
+	}
+	return k, nil
+}
+
+// addInnerProductProof adds the equation of the last round of protocol 2 to the msm:
+// P·Π(L^{x²}·R^{x⁻²}) = Π(g[i]^{a·s[i]})·Π(h[i]^{b·hScale[i]·s[i]⁻¹})·u^{a·b}
+// where pp, P are instance specific, g and h are the generators before the first round,
+// and hScale scales h, or is nil if it should not be scaled.
+func (m *msm) addInnerProductProof(pp *PP, P *math.G1, g, h common.G1v, hScale common.Vec, LRs []*math.G1, a, b *math.Zr, weight *math.Zr) error {
+	k, err := rounds(len(g))
+	if err != nil {
+		return err
+	}
+	if len(h) != len(g) {
+		return fmt.Errorf("g is of length %d but h is of length %d", len(g), len(h))
+	}
+	if len(LRs) != 2*k {
+		return fmt.Errorf("%w: expected %d L and R values but got %d", common.ErrMalformedProof, 2*k, len(LRs))
+	}
+
+	xs := make(common.Vec, k)
+	xInverses := make(common.Vec, k)
+	for r := 0; r < k; r++ {
+		L, R := LRs[2*r], LRs[2*r+1]
+		xs[r] = common.FieldElementFromBytes(randomOracle(L, R, pp.Digest))
+		xInverses[r] = invertZr(xs[r])
+
+		xSquare := xs[r].Mul(xs[r])
+		xSquareInv := xInverses[r].Mul(xInverses[r])
+		m.add(L, xSquare.Mul(weight))
+		m.add(R, xSquareInv.Mul(weight))
+	}
+	m.add(P, weight)
+
+	negWeight := common.NegZr(weight)
+
+	// The left half of g is multiplied by x⁻¹ and its right half by x, and the other way around for h.
+	sG := foldingCoefficients(xInverses, xs).Mul(a.Mul(negWeight))
+	sH := foldingCoefficients(xs, xInverses).Mul(b.Mul(negWeight))
+	if hScale != nil {
+		sH = sH.HadamardProd(hScale)
+	}
+
+	m.addV(g, sG)
+	m.addV(h, sH)
+	m.add(pp.U, a.Mul(b).Mul(negWeight))
+
+	return nil
+}
+
+// addReduction adds the equations of the iterated reduction to the msm, each multiplied by its own weight,
+// and returns the challenges of its rounds.
+func (m *msm) addReduction(pp *PP, prevV *math.G1, Δ [][3]*math.G1, vFinal *math.Zr, weight func() *math.Zr) (common.Vec, error) {
+	k, err := rounds(len(pp.G))
+	if err != nil {
+		return nil, err
+	}
+	if len(Δ) != k {
+		return nil, fmt.Errorf("%w: expected %d rounds but got %d", common.ErrMalformedProof, k, len(Δ))
+	}
+
+	xs := make(common.Vec, k)
+	ones := make(common.Vec, k)
+	xInverses := make(common.Vec, k)
+
+	for r, δ := range Δ {
+		A, B, V := δ[0], δ[1], δ[2]
+		x := common.FieldElementFromBytes(appendVToChallenge(randomOracle(A, B, pp.Digest), prevV))
+		xs[r], ones[r], xInverses[r] = x, common.IntToZr(1), invertZr(x)
+
+		// V = A^x·B^{x⁻¹}·prevV
+		w := weight()
+		negWeight := common.NegZr(w)
+		m.add(V, w)
+		m.add(A, x.Mul(negWeight))
+		m.add(B, xInverses[r].Mul(negWeight))
+		m.add(prevV, negWeight)
+
+		prevV = V
+	}
+
+	// The right half of G is multiplied by x⁻¹ in every round, and the result should open to vFinal.
+	w := weight()
+	m.addV(pp.G, foldingCoefficients(ones, xInverses).Mul(vFinal.Mul(w)))
+	m.add(prevV, common.NegZr(w))
+
+	return xs, nil
+}
+
+// BatchVerifyRange verifies that each of the range proofs is valid for the commitment in the same index.
+// All verification equations are merged into one randomized multi-scalar multiplication,
+// and only if it fails, the proofs are verified one by one to find out which of them is invalid.
+func BatchVerifyRange(pp *RangeProofPublicParams, proofs []*RangeProof, commitments common.G1v) error {
+	if len(proofs) != len(commitments) {
+		return fmt.Errorf("%w: got %d range proofs for %d commitments", common.ErrMalformedProof, len(proofs), len(commitments))
+	}
+
+	equations := make([]*msm, len(proofs))
+	errs := make([]error, len(proofs))
+
+	var wg sync.WaitGroup
+	wg.Add(len(proofs))
+
+	for i := range proofs {
+		go func(i int) {
+			defer wg.Done()
+			equations[i] = newMSM()
+			errs[i] = addRangeProof(equations[i], pp, proofs[i], commitments[i], randomWeight)
+		}(i)
+	}
+
+	wg.Wait()
+
+	batch := newMSM()
+	for i := range equations {
+		if errs[i] != nil {
+			return fmt.Errorf("range proof %d is invalid: %w", i, errs[i])
+		}
+		batch.merge(equations[i], randomWeight())
+	}
+
+	if batch.isIdentity() {
+		return nil
+	}
+
+	for i := range proofs {
+		if err := VerifyRange(pp, proofs[i], commitments[i]); err != nil {
+			return fmt.Errorf("range proof %d is invalid: %w", i, err)
+		}
+	}
+
+	return fmt.Errorf("invalid range proofs")
+}
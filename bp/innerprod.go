@@ -2,6 +2,7 @@ package bp
 
 import (
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"pol/common"
 
@@ -43,6 +44,15 @@ func (pp *PP) setupDigest() {
 	pp.Digest = h.Sum(nil)
 }
 
+// mulU returns x·U.
+// The inner product arguments of all proofs share the same U, and with it its precomputed table.
+func (pp *PP) mulU(x *math.Zr) *math.G1 {
+	if u := common.Generator("u"); pp.U.Equals(u.Point()) {
+		return u.Mul(x)
+	}
+	return pp.U.Mul(x)
+}
+
 func (pp *PP) RecomputeDigest() {
 	pp.setupDigest()
 }
@@ -61,10 +71,75 @@ type InnerProductProof struct {
 	C    *math.Zr
 }
 
+// checkComplete returns an error if any part of the proof the prover sends is missing
+func (ipp *InnerProductProof) checkComplete() error {
+	if ipp == nil || ipp.a == nil || ipp.b == nil || common.G1v(ipp.LRs).HasNil() {
+		return fmt.Errorf("%w: inner product proof is incomplete", common.ErrMalformedProof)
+	}
+	return nil
+}
+
 func (ipp *InnerProductProof) Size() int {
 	return len(common.G1v(ipp.LRs).Bytes()) + len(ipp.a.Bytes()) + len(ipp.b.Bytes()) + len(ipp.P.Bytes()) + len(ipp.C.Bytes())
 }
 
+func (ipp *InnerProductProof) MarshalBinary() ([]byte, error) {
+	e := common.NewEncoder()
+	e.G1v(ipp.LRs)
+	e.Zr(ipp.a)
+	e.Zr(ipp.b)
+	e.G1(ipp.P)
+	e.Zr(ipp.C)
+	return e.Result()
+}
+
+func (ipp *InnerProductProof) UnmarshalBinary(b []byte) error {
+	d := common.NewDecoder(b)
+	ipp.LRs = d.G1v()
+	ipp.a = d.Zr()
+	ipp.b = d.Zr()
+	ipp.P = d.G1()
+	ipp.C = d.Zr()
+	return d.Finish()
+}
+
+type innerProductProofJSON struct {
+	LRs []string `json:"lrs"`
+	A   string   `json:"a"`
+	B   string   `json:"b"`
+	P   string   `json:"p"`
+	C   string   `json:"c"`
+}
+
+func (ipp *InnerProductProof) MarshalJSON() ([]byte, error) {
+	var e common.HexEncoder
+	raw := innerProductProofJSON{
+		LRs: e.G1v(ipp.LRs),
+		A:   e.Zr(ipp.a),
+		B:   e.Zr(ipp.b),
+		P:   e.G1(ipp.P),
+		C:   e.Zr(ipp.C),
+	}
+	if err := e.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+func (ipp *InnerProductProof) UnmarshalJSON(b []byte) error {
+	var raw innerProductProofJSON
+	if err := common.UnmarshalJSONStrict(b, &raw); err != nil {
+		return err
+	}
+	var d common.HexDecoder
+	ipp.LRs = d.G1v(raw.LRs)
+	ipp.a = d.Zr(raw.A)
+	ipp.b = d.Zr(raw.B)
+	ipp.P = d.G1(raw.P)
+	ipp.C = d.Zr(raw.C)
+	return d.Err()
+}
+
 func NewInnerProdArgument(pp *PP, a, b common.Vec) *InnerProdArgument {
 	ipa := &InnerProdArgument{
 		C:  a.InnerProd(b),
@@ -82,18 +157,10 @@ func commit(pp *PP, a, b common.Vec, u *math.G1) *math.G1 {
 		panic(fmt.Sprintf("vector a is of length %d but vector b is of length %d", len(a), len(b)))
 	}
 
-	gAcc := pp.G[0].Mul(a[0])
-	hAcc := pp.H[0].Mul(b[0])
-
-	for i := 1; i < len(a); i++ {
-		gAcc.Add(pp.G[i].Mul(a[i]))
-		hAcc.Add(pp.H[i].Mul(b[i]))
-	}
-
-	gAcc.Add(hAcc)
+	gAcc := common.MultiExp(append(pp.G[:len(a):len(a)], pp.H[:len(b)]...), a.Concat(b))
 
 	if u != nil {
-		gAcc.Add(pp.U.Mul(a.InnerProd(b)))
+		gAcc.Add(pp.mulU(a.InnerProd(b)))
 	}
 
 	return gAcc
@@ -104,15 +171,21 @@ func computeInstanceSpecificParams(pp *PP, P *math.G1, c *math.Zr) (*PP, *math.G
 	newPP = *pp
 	var hashPreImage []byte
 	hashPreImage = append(hashPreImage, P.Bytes()...)
-	hashPreImage = append(pp.Digest)
+	hashPreImage = append(hashPreImage, pp.Digest...)
 
 	x := common.FieldElementFromBytes(common.SHA256Digest(string(hashPreImage)))
 
 	P = P.Copy()
-	P.Add(pp.U.Mul(x.Mul(c)))
+	P.Add(pp.mulU(x.Mul(c)))
+
+	newPP.U = pp.mulU(x)
+
+	// The digest of pp already binds the generators, so only the new U is hashed along with it
+	var digestPreImage []byte
+	digestPreImage = append(digestPreImage, pp.Digest...)
+	digestPreImage = append(digestPreImage, newPP.U.Bytes()...)
+	newPP.Digest = common.SHA256Digest(string(digestPreImage))
 
-	newPP.U = newPP.U.Mul(x)
-	newPP.RecomputeDigest()
 	return &newPP, P
 }
 
@@ -132,34 +205,26 @@ func (ipa *InnerProdArgument) Prove() *InnerProductProof {
 }
 
 func (ipp *InnerProductProof) Verify(pp *PP) error {
+	if err := ipp.checkComplete(); err != nil {
+		return err
+	}
 	pp, P := computeInstanceSpecificParams(pp, ipp.P, ipp.C)
 	return verify(pp, P, pp.G, pp.H, ipp.LRs, ipp.a, ipp.b)
 }
 
 // verify implements the verifier's side in protocol 2.
+// Instead of folding the generators round by round, it computes the coefficients of the generators
+// in the last round in one pass, and checks the last round in a single multi-scalar multiplication.
 func verify(pp *PP, P *math.G1, g, h common.G1v, LRs []*math.G1, a *math.Zr, b *math.Zr) error {
-	if len(g) == 1 {
-		expectedP := pp.U.Mul(a.Mul(b))
-		expectedP.Add(g[0].Mul(a))
-		expectedP.Add(h[0].Mul(b))
-
-		if !expectedP.Equals(P) {
-			return fmt.Errorf("P != g^a*h^b*u^c")
-		}
-		return nil
+	m := newMSM()
+	if err := m.addInnerProductProof(pp, P, g, h, nil, LRs, a, b, common.IntToZr(1)); err != nil {
+		return err
 	}
 
-	L := LRs[0]
-	R := LRs[1]
-	LRs = LRs[2:]
-
-	nextParams := computeNextParams(L, R, pp, g, h, P)
-
-	g = nextParams.g
-	h = nextParams.h
-	P = nextParams.P
-
-	return verify(pp, P, g, h, LRs, a, b)
+	if !m.isIdentity() {
+		return fmt.Errorf("P != g^a*h^b*u^c")
+	}
+	return nil
 }
 
 // prove implements the prover's side in protocol 2.
@@ -1,6 +1,7 @@
 package bp
 
 import (
+	"encoding/json"
 	"pol/common"
 	"testing"
 
@@ -17,4 +18,46 @@ func TestInnerProdArgument(t *testing.T) {
 
 	proof := ipa.Prove()
 	assert.Nil(t, proof.Verify(pp))
+
+	proof.a = proof.a.Plus(common.IntToZr(1))
+	assert.EqualError(t, proof.Verify(pp), "P != g^a*h^b*u^c")
+
+	proof.LRs = proof.LRs[2:]
+	assert.EqualError(t, proof.Verify(pp), "malformed proof: expected 6 L and R values but got 4")
+}
+
+func TestInnerProductProofEncoding(t *testing.T) {
+	n := 8
+	pp := NewPublicParams(n)
+	a := common.RandVec(n)
+	b := common.RandVec(n)
+
+	proof := NewInnerProdArgument(pp, a, b).Prove()
+
+	raw, err := proof.MarshalBinary()
+	assert.NoError(t, err)
+
+	decoded := &InnerProductProof{}
+	assert.NoError(t, decoded.UnmarshalBinary(raw))
+	assert.NoError(t, decoded.Verify(pp))
+
+	raw2, err := decoded.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, raw, raw2)
+
+	assert.Error(t, decoded.UnmarshalBinary(append(raw, 0)))
+	assert.Error(t, decoded.UnmarshalBinary(raw[:len(raw)-1]))
+
+	rawJSON, err := json.Marshal(proof)
+	assert.NoError(t, err)
+
+	decoded = &InnerProductProof{}
+	assert.NoError(t, json.Unmarshal(rawJSON, decoded))
+	assert.NoError(t, decoded.Verify(pp))
+
+	rawJSON2, err := json.Marshal(decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, rawJSON, rawJSON2)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"a":"00"}`), decoded))
 }
@@ -2,9 +2,11 @@ package bp
 
 import (
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"math/bits"
 	"pol/common"
+	"sync"
 
 	math "github.com/IBM/mathlib"
 )
@@ -12,18 +14,74 @@ import (
 type RangeProofPublicParams struct {
 	G, H, F    *math.G1
 	Gs, Hs, Fs common.G1v
-	digest     []byte
+	// Bits is the bit width of the range: every value is proven to be in [0, 2^Bits)
+	Bits   int
+	digest []byte
+	tables *rangeProofTables
+}
+
+const (
+	// DefaultBits is the bit width of the range of NewRangeProofPublicParams
+	DefaultBits = 63
+	// MaxBits is the largest bit width of a range.
+	// Sums of up to 2^16 values of MaxBits bits do not wrap around the group order.
+	MaxBits = 127
+)
+
+// rangeProofTables are the precomputed tables of the generators of the range proof public parameters
+type rangeProofTables struct {
+	G, H, F    *common.FixedBase
+	Gs, Hs, Fs *common.FixedBaseVec
+}
+
+func (t *rangeProofTables) matches(rppp *RangeProofPublicParams) bool {
+	return t.G.Matches(rppp.G) && t.H.Matches(rppp.H) && t.F.Matches(rppp.F) &&
+		t.Gs.Matches(rppp.Gs) && t.Hs.Matches(rppp.Hs) && t.Fs.Matches(rppp.Fs)
+}
+
+// tablesLock guards the lazily built tables of all public parameters
+var tablesLock sync.Mutex
+
+// precomputed returns the tables of the generators, which are built once and shared by the prover and the verifier.
+// If the generators are replaced, the tables are rebuilt.
+func (rppp *RangeProofPublicParams) precomputed() *rangeProofTables {
+	tablesLock.Lock()
+	defer tablesLock.Unlock()
+
+	if rppp.tables == nil || !rppp.tables.matches(rppp) {
+		rppp.tables = &rangeProofTables{
+			G:  common.NewFixedBase(rppp.G),
+			H:  common.NewFixedBase(rppp.H),
+			F:  common.NewFixedBase(rppp.F),
+			Gs: common.NewFixedBaseVec(rppp.Gs),
+			Hs: common.NewFixedBaseVec(rppp.Hs),
+			Fs: common.NewFixedBaseVec(rppp.Fs),
+		}
+	}
+	return rppp.tables
 }
 
 func NewRangeProofPublicParams(n int) *RangeProofPublicParams {
-	m := 63
+	return NewRangeProofPublicParamsWithBits(n, DefaultBits)
+}
+
+// NewRangeProofPublicParamsWithBits is like NewRangeProofPublicParams, but for values in [0, 2^bits).
+// The inner product argument halves the generators until a single one is left,
+// so the bit width must be one less than a power of two, and at most MaxBits.
+func NewRangeProofPublicParamsWithBits(n, bits int) *RangeProofPublicParams {
+	if err := CheckBits(bits); err != nil {
+		panic(err)
+	}
+
+	m := bits
 	rppp := &RangeProofPublicParams{
-		G:  common.RandGenVec(1, "range proof G")[0],
-		H:  common.RandGenVec(1, "range proof H")[0],
-		F:  common.RandGenVec(1, "range proof F")[0],
-		Fs: common.RandGenVec(n*(m+1), "range proof Fs"),
-		Hs: common.RandGenVec(n*(m+1), "range proof Hs"),
-		Gs: common.RandGenVec(n, "range proof Gs"),
+		Bits: bits,
+		G:    common.RandGenVec(1, "range proof G")[0],
+		H:    common.RandGenVec(1, "range proof H")[0],
+		F:    common.RandGenVec(1, "range proof F")[0],
+		Fs:   common.RandGenVec(n*(m+1), "range proof Fs"),
+		Hs:   common.RandGenVec(n*(m+1), "range proof Hs"),
+		Gs:   common.RandGenVec(n, "range proof Gs"),
 	}
 
 	rppp.Digest()
@@ -31,6 +89,14 @@ func NewRangeProofPublicParams(n int) *RangeProofPublicParams {
 	return rppp
 }
 
+// CheckBits returns an error if range proofs cannot be of the given bit width
+func CheckBits(bits int) error {
+	if bits < 1 || bits > MaxBits || !common.IsPowerOfTwo(uint16(bits+1)) {
+		return fmt.Errorf("bit width %d is not one less than a power of two in [1, %d]", bits, MaxBits)
+	}
+	return nil
+}
+
 func (rppp *RangeProofPublicParams) Size() int {
 	return len(rppp.Gs.Bytes()) + len(rppp.Hs.Bytes()) + len(rppp.Fs.Bytes()) + len(rppp.G.Bytes()) + len(rppp.H.Bytes()) + len(rppp.F.Bytes())
 }
@@ -52,6 +118,55 @@ func (rppp *RangeProofPublicParams) Digest() []byte {
 	return digest
 }
 
+// RecomputeDigest recomputes the digest after the generators have been replaced
+func (rppp *RangeProofPublicParams) RecomputeDigest() {
+	rppp.digest = nil
+	rppp.Digest()
+}
+
+func (rppp *RangeProofPublicParams) MarshalBinary() ([]byte, error) {
+	e := common.NewEncoder()
+	e.G1(rppp.G)
+	e.G1(rppp.H)
+	e.G1(rppp.F)
+	e.G1v(rppp.Gs)
+	e.G1v(rppp.Hs)
+	e.G1v(rppp.Fs)
+	return e.Result()
+}
+
+func (rppp *RangeProofPublicParams) UnmarshalBinary(b []byte) error {
+	d := common.NewDecoder(b)
+	loaded := &RangeProofPublicParams{
+		G:  d.G1(),
+		H:  d.G1(),
+		F:  d.G1(),
+		Gs: d.G1v(),
+		Hs: d.G1v(),
+		Fs: d.G1v(),
+	}
+	if err := d.Finish(); err != nil {
+		return err
+	}
+
+	// The bit width is not encoded, as it is determined by the number of generators per value
+	n := len(loaded.Gs)
+	if n == 0 || len(loaded.Hs)%n != 0 {
+		return fmt.Errorf("expected Hs of a size that is a multiple of %d but got %d", n, len(loaded.Hs))
+	}
+	loaded.Bits = len(loaded.Hs)/n - 1
+	if err := CheckBits(loaded.Bits); err != nil {
+		return err
+	}
+	if len(loaded.Fs) != len(loaded.Hs) {
+		return fmt.Errorf("expected Hs and Fs of size %d but got %d and %d", len(loaded.Hs), len(loaded.Hs), len(loaded.Fs))
+	}
+
+	loaded.Digest()
+	*rppp = *loaded
+	return nil
+}
+
 type RangeProof struct {
 	Δ            [][3]*math.G1
 	u            *math.Zr // Γ = (Δ, u)
@@ -63,6 +178,20 @@ type RangeProof struct {
 	τ, ρ         *math.Zr
 }
 
+// checkComplete returns an error if any part of the proof is missing
+func (rp *RangeProof) checkComplete() error {
+	if rp == nil || rp.u == nil || rp.W == nil || rp.γ == nil || rp.c == nil || rp.τ == nil || rp.ρ == nil ||
+		rp.Q == nil || rp.R == nil || rp.C1 == nil || rp.C2 == nil {
+		return fmt.Errorf("%w: range proof is incomplete", common.ErrMalformedProof)
+	}
+	for _, δ := range rp.Δ {
+		if δ[0] == nil || δ[1] == nil || δ[2] == nil {
+			return fmt.Errorf("%w: range proof is incomplete", common.ErrMalformedProof)
+		}
+	}
+	return rp.Π.checkComplete()
+}
+
 func (rp *RangeProof) Size() int {
 	size := len(rp.τ.Bytes()) + len(rp.ρ.Bytes()) + len(rp.Q.Bytes()) + len(rp.R.Bytes()) + len(rp.C1.Bytes()) + len(rp.C2.Bytes())
 	size += len(rp.c.Bytes())
@@ -78,15 +207,146 @@ func (rp *RangeProof) Size() int {
 	return size
 }
 
+func (rp *RangeProof) MarshalBinary() ([]byte, error) {
+	e := common.NewEncoder()
+	e.Uint32(uint32(len(rp.Δ)))
+	for _, δ := range rp.Δ {
+		e.G1(δ[0])
+		e.G1(δ[1])
+		e.G1(δ[2])
+	}
+	e.Zr(rp.u)
+	e.G1(rp.W)
+	e.Zr(rp.γ)
+	e.Marshaler(rp.Π)
+	e.Zr(rp.c)
+	e.G1(rp.Q)
+	e.G1(rp.R)
+	e.G1(rp.C1)
+	e.G1(rp.C2)
+	e.Zr(rp.τ)
+	e.Zr(rp.ρ)
+	return e.Result()
+}
+
+func (rp *RangeProof) UnmarshalBinary(b []byte) error {
+	d := common.NewDecoder(b)
+	rp.Δ = make([][3]*math.G1, d.Len(3*common.G1Size))
+	for i := range rp.Δ {
+		rp.Δ[i] = [3]*math.G1{d.G1(), d.G1(), d.G1()}
+	}
+	rp.u = d.Zr()
+	rp.W = d.G1()
+	rp.γ = d.Zr()
+	rp.Π = &InnerProductProof{}
+	d.Unmarshaler(rp.Π)
+	rp.c = d.Zr()
+	rp.Q = d.G1()
+	rp.R = d.G1()
+	rp.C1 = d.G1()
+	rp.C2 = d.G1()
+	rp.τ = d.Zr()
+	rp.ρ = d.Zr()
+	return d.Finish()
+}
+
+type rangeProofJSON struct {
+	Delta             [][3]string     `json:"delta"`
+	U                 string          `json:"u"`
+	W                 string          `json:"w"`
+	Gamma             string          `json:"gamma"`
+	InnerProductProof json.RawMessage `json:"innerProductProof"`
+	C                 string          `json:"c"`
+	Q                 string          `json:"q"`
+	R                 string          `json:"r"`
+	C1                string          `json:"c1"`
+	C2                string          `json:"c2"`
+	Tau               string          `json:"tau"`
+	Rho               string          `json:"rho"`
+}
+
+func (rp *RangeProof) MarshalJSON() ([]byte, error) {
+	var e common.HexEncoder
+	raw := rangeProofJSON{
+		Delta: make([][3]string, len(rp.Δ)),
+		U:     e.Zr(rp.u),
+		W:     e.G1(rp.W),
+		Gamma: e.Zr(rp.γ),
+		C:     e.Zr(rp.c),
+		Q:     e.G1(rp.Q),
+		R:     e.G1(rp.R),
+		C1:    e.G1(rp.C1),
+		C2:    e.G1(rp.C2),
+		Tau:   e.Zr(rp.τ),
+		Rho:   e.Zr(rp.ρ),
+	}
+	for i, δ := range rp.Δ {
+		raw.Delta[i] = [3]string{e.G1(δ[0]), e.G1(δ[1]), e.G1(δ[2])}
+	}
+	raw.InnerProductProof = e.JSON(rp.Π)
+	if err := e.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+func (rp *RangeProof) UnmarshalJSON(b []byte) error {
+	var raw rangeProofJSON
+	if err := common.UnmarshalJSONStrict(b, &raw); err != nil {
+		return err
+	}
+	var d common.HexDecoder
+	rp.Δ = make([][3]*math.G1, len(raw.Delta))
+	for i, δ := range raw.Delta {
+		rp.Δ[i] = [3]*math.G1{d.G1(δ[0]), d.G1(δ[1]), d.G1(δ[2])}
+	}
+	rp.u = d.Zr(raw.U)
+	rp.W = d.G1(raw.W)
+	rp.γ = d.Zr(raw.Gamma)
+	rp.Π = &InnerProductProof{}
+	d.JSON(raw.InnerProductProof, rp.Π)
+	rp.c = d.Zr(raw.C)
+	rp.Q = d.G1(raw.Q)
+	rp.R = d.G1(raw.R)
+	rp.C1 = d.G1(raw.C1)
+	rp.C2 = d.G1(raw.C2)
+	rp.τ = d.Zr(raw.Tau)
+	rp.ρ = d.Zr(raw.Rho)
+	return d.Err()
+}
+
 func VerifyRange(pp *RangeProofPublicParams, rp *RangeProof, V *math.G1) error {
-	// We assume all liabilities and their sums to be less than 2^{63}
-	m := 63
+	m := newMSM()
+	if err := addRangeProof(m, pp, rp, V, randomWeight); err != nil {
+		return err
+	}
+
+	if !m.isIdentity() {
+		return fmt.Errorf("invalid range proof")
+	}
+
+	return nil
+}
+
+// addRangeProof adds the verification equations of the range proof to eq, each multiplied by its own weight.
+func addRangeProof(eq *msm, pp *RangeProofPublicParams, rp *RangeProof, V *math.G1, weight func() *math.Zr) error {
+	if err := rp.checkComplete(); err != nil {
+		return err
+	}
+	if V == nil {
+		return fmt.Errorf("%w: commitment is missing", common.ErrMalformedProof)
+	}
+
+	m := pp.Bits
 
 	n := len(pp.Gs)
+	if len(pp.Fs) != n*(m+1) || len(pp.Hs) != n*(m+1) {
+		return fmt.Errorf("expected Hs and Fs of size %d but got %d and %d", n*(m+1), len(pp.Hs), len(pp.Fs))
+	}
 
 	x := rangeProofRO1(pp, V, rp.W, rp.Q)
 
-	U := pp.F.Mul(rp.γ)
+	U := pp.precomputed().F.Mul(rp.γ)
 	U.Add(V)
 	U.Add(rp.W.Mul(x))
 
@@ -96,11 +356,12 @@ func VerifyRange(pp *RangeProofPublicParams, rp *RangeProof, V *math.G1) error {
 	}
 	ppRdx.RecomputeDigest()
 
-	xs, u, err := IterativeVerify(ppRdx, U, rp.Δ, rp.u)
+	xs, err := eq.addReduction(ppRdx, U, rp.Δ, rp.u, weight)
 	if err != nil {
-		return fmt.Errorf("iterated reduction proof invalid: %v", err)
+		return fmt.Errorf("iterated reduction proof invalid: %w", err)
 	}
 	xs = xs.Reverse()
+	u := rp.u
 
 	f := make(common.Vec, n)
 	for i := uint16(0); i < uint16(n); i++ {
@@ -121,22 +382,15 @@ func VerifyRange(pp *RangeProofPublicParams, rp *RangeProof, V *math.G1) error {
 	y0v, y1v := common.PowerSeries(n*m, y0), expand(common.IntToZr(1), n*m).Mul(y1)
 	z := computeZ(pp, rp.C1, rp.C2, rp.Q, rp.R, y0.Bytes(), y1.Bytes())
 
-	y0Inverse := invertZr(y0)
-	Fprime := pp.Fs.MulV(common.PowerSeries(len(pp.Fs), y0Inverse))
-
-	ipaPP := &PP{
-		U: common.RandGenVec(1, "u")[0],
-		G: pp.Hs,
-		H: Fprime,
-	}
-	ipaPP.G = pp.Hs
-	ipaPP.H = Fprime
-	ipaPP.RecomputeDigest()
+	y0InversePowers := common.PowerSeries(len(pp.Fs), invertZr(y0))
 
-	rp.Π.C = rp.c
-	rp.Π.P = computeP(pp, rp.ρ, rp.Q, rp.R, z, y1v, n, m, Fprime, d, y1)
-	if err := rp.Π.Verify(ipaPP); err != nil {
-		return fmt.Errorf("inner product proof invalid: %v", err)
+	// The inner product proof is over Fs scaled by y0InversePowers, but its generators are expressed by Fs,
+	// so that they are shared with other range proofs in a batch, and the scaled generators are never computed.
+	ipaPP := rangeProofIPAParams(pp, y0, nil)
+	P := computeP(pp, rp.ρ, rp.Q, rp.R, z, y1v, n, m, y0InversePowers, d, y1)
+	ipaPP, P = computeInstanceSpecificParams(ipaPP, P, rp.c)
+	if err := eq.addInnerProductProof(ipaPP, P, pp.Hs, pp.Fs, y0InversePowers, rp.Π.LRs, rp.Π.a, rp.Π.b, weight()); err != nil {
+		return fmt.Errorf("inner product proof invalid: %w", err)
 	}
 
 	β1 := expand(common.IntToZr(1), n*m).InnerProd(y0v)
@@ -145,30 +399,34 @@ func VerifyRange(pp *RangeProofPublicParams, rp *RangeProof, V *math.G1) error {
 
 	c0 := β3.Mul(y1.Mul(y1).Mul(y1)).Plus(y1.Mul(y1).Mul(u.Plus(β2))).Plus(β1.Mul(y1))
 
-	left := rp.C1.Mul(z)
-	left.Add(rp.C2.Mul(z.Mul(z)))
-	left.Add(pp.G.Mul(c0))
-
-	right := pp.G.Mul(rp.c)
-	right.Add(pp.H.Mul(rp.τ))
-
-	if !left.Equals(right) {
-		return fmt.Errorf("invalid range proof")
-	}
+	// C1^z·C2^{z²}·G^{c0} = G^c·H^τ
+	w := weight()
+	eq.add(rp.C1, z.Mul(w))
+	eq.add(rp.C2, z.Mul(z).Mul(w))
+	eq.add(pp.G, curve.ModSub(c0, rp.c, curve.GroupOrder).Mul(w))
+	eq.add(pp.H, common.NegZr(rp.τ.Mul(w)))
 
 	return nil
 }
 
-func ProveRange(pp *RangeProofPublicParams, V *math.G1, v common.Vec, r *math.Zr) *RangeProof {
+// ProveRange proves that every value of v, which V commits to with randomness r, is in [0, 2^Bits).
+// It fails with an error wrapping common.ErrInvalidLiability if any of the values is out of range.
+func ProveRange(pp *RangeProofPublicParams, V *math.G1, v common.Vec, r *math.Zr) (*RangeProof, error) {
 	n := len(pp.Gs)
+	m := pp.Bits
 
-	// We assume all liabilities and their sums to be less than 2^{63}
-	m := 63
+	for i := range v {
+		if common.ZrToBig(v[i]).BitLen() > m {
+			return nil, fmt.Errorf("%w: value %d is not in [0, 2^%d)", common.ErrInvalidLiability, i, m)
+		}
+	}
 
 	w, rPrime := common.RandVec(n), common.RandVec(1)[0]
 
-	W := pp.F.Mul(rPrime)
-	W.Add(pp.Gs.MulV(w).Sum())
+	generators := pp.precomputed()
+
+	W := generators.F.Mul(rPrime)
+	W.Add(generators.Gs.MultiExp(0, w))
 
 	vBits := common.IntsToZr(v.Bits(m))
 	vBits = append(vBits, w...)
@@ -177,15 +435,15 @@ func ProveRange(pp *RangeProofPublicParams, V *math.G1, v common.Vec, r *math.Zr
 
 	ν, η := common.RandVec(1)[0], common.RandVec(1)[0]
 
-	Q := pp.F.Mul(ν)
-	Q.Add(pp.Hs.MulV(vBits).Sum())
-	Q.Add(pp.Fs[:n*m].MulV(wCaret).Sum())
+	Q := generators.F.Mul(ν)
+	Q.Add(generators.Hs.MultiExp(0, vBits))
+	Q.Add(generators.Fs.MultiExp(0, wCaret))
 
 	x := rangeProofRO1(pp, V, W, Q)
 
 	γ := common.NegZr(r.Plus(x.Mul(rPrime)))
 
-	U := pp.F.Mul(γ)
+	U := generators.F.Mul(γ)
 	U.Add(V)
 	U.Add(W.Mul(x))
 
@@ -209,9 +467,9 @@ func ProveRange(pp *RangeProofPublicParams, V *math.G1, v common.Vec, r *math.Zr
 
 	s, t := common.RandVec(n*m+n), common.RandVec(n*m)
 
-	R := pp.F.Mul(η)
-	R.Add(pp.Hs.MulV(s).Sum())
-	R.Add(pp.Fs[:n*m].MulV(t).Sum())
+	R := generators.F.Mul(η)
+	R.Add(generators.Hs.MultiExp(0, s))
+	R.Add(generators.Fs.MultiExp(0, t))
 
 	y0Digest := []byte{0}
 	y1Digest := []byte{1}
@@ -230,35 +488,30 @@ func ProveRange(pp *RangeProofPublicParams, V *math.G1, v common.Vec, r *math.Zr
 	c1 = c1.Plus(s.InnerProd(bPrime))
 	c2 := s[:n*m].InnerProd(y0v.HadamardProd(t))
 	τ1, τ2 := common.RandVec(1)[0], common.RandVec(1)[0]
-	C1, C2 := pp.G.Mul(c1), pp.G.Mul(c2)
-	C1.Add(pp.H.Mul(τ1))
-	C2.Add(pp.H.Mul(τ2))
+	C1, C2 := generators.G.Mul(c1), generators.G.Mul(c2)
+	C1.Add(generators.H.Mul(τ1))
+	C2.Add(generators.H.Mul(τ2))
 
 	z := computeZ(pp, C1, C2, Q, R, y0.Bytes(), y1.Bytes())
 
 	ρ := common.NegZr(ν.Plus(η.Mul(z)))
 	τ := τ1.Mul(z).Plus(τ2.Mul(z.Mul(z)))
 
-	y0Inverse := invertZr(y0)
-	Fprime := pp.Fs.MulV(common.PowerSeries(len(pp.Fs), y0Inverse))
+	y0InversePowers := common.PowerSeries(len(pp.Fs), invertZr(y0))
+	Fprime := pp.Fs.MulV(y0InversePowers)
 
 	a, b := aPrime.Add(s.Mul(z)), bPrime.Add(y0v.HadamardProd(t).Concat(zeros).Mul(z))
 
 	c := a.InnerProd(b)
 
-	ipaPP := &PP{
-		U: common.RandGenVec(1, "u")[0],
-		G: pp.Hs,
-		H: Fprime,
-	}
-	ipaPP.RecomputeDigest()
+	ipaPP := rangeProofIPAParams(pp, y0, Fprime)
 
 	ipa := NewInnerProdArgument(ipaPP, a, b)
 
 	ipp := ipa.Prove()
 
 	if err := ipp.Verify(ipaPP); err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	return &RangeProof{
@@ -274,16 +527,39 @@ func ProveRange(pp *RangeProofPublicParams, V *math.G1, v common.Vec, r *math.Zr
 		R:  R,
 		W:  W,
 		Π:  ipp,
+	}, nil
+}
+
+// rangeProofIPAParams returns the public parameters of the inner product argument of a range proof,
+// whose generators are Hs and Fprime, which is Fs scaled by the powers of y0⁻¹.
+// Instead of hashing Fprime, the digest binds Hs and Fs through the digest of pp, and y0, which determine Fprime,
+// so the verifier does not need to compute it and passes nil.
+func rangeProofIPAParams(pp *RangeProofPublicParams, y0 *math.Zr, Fprime common.G1v) *PP {
+	ipaPP := &PP{
+		U: common.RandGenVec(1, "u")[0],
+		G: pp.Hs,
+		H: Fprime,
 	}
+
+	h := sha256.New()
+	h.Write(ipaPP.U.Bytes())
+	h.Write(pp.Digest())
+	h.Write(y0.Bytes())
+	ipaPP.Digest = h.Sum(nil)
+
+	return ipaPP
 }
 
-func computeP(pp *RangeProofPublicParams, ρ *math.Zr, Q *math.G1, R *math.G1, z *math.Zr, y1v common.Vec, n int, m int, Fprime common.G1v, d common.Vec, y1 *math.Zr) *math.G1 {
-	P := pp.F.Mul(ρ)
+// computeP computes the commitment of the inner product argument, where the generators Fs are scaled by y0InversePowers
+func computeP(pp *RangeProofPublicParams, ρ *math.Zr, Q *math.G1, R *math.G1, z *math.Zr, y1v common.Vec, n int, m int, y0InversePowers common.Vec, d common.Vec, y1 *math.Zr) *math.G1 {
+	generators := pp.precomputed()
+
+	P := generators.F.Mul(ρ)
 	P.Add(Q)
 	P.Add(R.Mul(z))
-	P.Add(pp.Hs[:n*m].MulV(y1v).Sum())
-	P.Add(Fprime.MulV(d.Mul(y1.Mul(y1))).Sum())
-	P.Add(pp.Fs[:n*m].MulV(y1v).Sum())
+	P.Add(generators.Hs.MultiExp(0, y1v))
+	P.Add(generators.Fs.MultiExp(0, d.Mul(y1.Mul(y1)).HadamardProd(y0InversePowers)))
+	P.Add(generators.Fs.MultiExp(0, y1v))
 
 	return P
 }
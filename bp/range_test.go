@@ -1,9 +1,13 @@
 package bp
 
 import (
+	"encoding/json"
+	"errors"
+	"math/big"
 	"pol/common"
 	"testing"
 
+	math "github.com/IBM/mathlib"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,7 +21,158 @@ func TestRangeProof(t *testing.T) {
 	V := pp.F.Mul(r)
 	V.Add(pp.Gs.MulV(v).Sum())
 
-	rp := ProveRange(pp, V, v, r)
-	err := VerifyRange(pp, rp, V)
+	rp, err := ProveRange(pp, V, v, r)
 	assert.NoError(t, err)
+	err = VerifyRange(pp, rp, V)
+	assert.NoError(t, err)
+}
+
+func TestRangeProofEncoding(t *testing.T) {
+	pp := NewRangeProofPublicParams(4)
+
+	v := common.Vec{common.IntToZr(1), common.IntToZr(2), common.IntToZr(3), common.IntToZr(6)}
+	r := common.RandVec(1)[0]
+
+	V := pp.F.Mul(r)
+	V.Add(pp.Gs.MulV(v).Sum())
+
+	rp, err := ProveRange(pp, V, v, r)
+	assert.NoError(t, err)
+	raw, err := rp.MarshalBinary()
+	assert.NoError(t, err)
+
+	decoded := &RangeProof{}
+	assert.NoError(t, decoded.UnmarshalBinary(raw))
+	assert.NoError(t, VerifyRange(pp, decoded, V))
+
+	raw2, err := decoded.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, raw, raw2)
+
+	assert.Error(t, decoded.UnmarshalBinary(append(raw, 0)))
+
+	_, err = (&RangeProof{}).MarshalBinary()
+	assert.Error(t, err)
+
+	rawJSON, err := json.Marshal(rp)
+	assert.NoError(t, err)
+
+	decoded = &RangeProof{}
+	assert.NoError(t, json.Unmarshal(rawJSON, decoded))
+	assert.NoError(t, VerifyRange(pp, decoded, V))
+
+	rawJSON2, err := json.Marshal(decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, rawJSON, rawJSON2)
+
+	_, err = json.Marshal(&RangeProof{})
+	assert.Error(t, err)
+}
+
+func TestRangeProofPublicParamsEncoding(t *testing.T) {
+	pp := NewRangeProofPublicParams(2)
+
+	raw, err := pp.MarshalBinary()
+	assert.NoError(t, err)
+
+	var decoded RangeProofPublicParams
+	assert.NoError(t, decoded.UnmarshalBinary(raw))
+	assert.Equal(t, pp.Digest(), decoded.Digest())
+
+	v := common.Vec{common.IntToZr(5), common.IntToZr(7)}
+	r := common.RandVec(1)[0]
+
+	V := pp.F.Mul(r)
+	V.Add(pp.Gs.MulV(v).Sum())
+
+	rp, err := ProveRange(pp, V, v, r)
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyRange(&decoded, rp, V))
+
+	decoded.Fs = decoded.Fs[1:]
+	raw, err = decoded.MarshalBinary()
+	assert.NoError(t, err)
+	assert.EqualError(t, decoded.UnmarshalBinary(raw), "expected Hs and Fs of size 128 but got 128 and 127")
+}
+
+func TestRangeProofBitWidth(t *testing.T) {
+	prove := func(pp *RangeProofPublicParams, v common.Vec) (*RangeProof, *math.G1) {
+		r := common.RandVec(1)[0]
+		V := pp.F.Mul(r)
+		V.Add(pp.Gs.MulV(v).Sum())
+		rp, err := ProveRange(pp, V, v, r)
+		assert.NoError(t, err)
+		return rp, V
+	}
+
+	// 10^30 overflows 63 bits but fits in 127 bits
+	large := common.BigToZr(new(big.Int).Exp(big.NewInt(10), big.NewInt(30), nil))
+	wide := NewRangeProofPublicParamsWithBits(2, 127)
+	assert.Equal(t, 127, wide.Bits)
+	assert.Len(t, wide.Hs, 2*128)
+	rp, V := prove(wide, common.Vec{large, common.IntToZr(1)})
+	assert.NoError(t, VerifyRange(wide, rp, V))
+
+	narrow := NewRangeProofPublicParamsWithBits(2, 15)
+	assert.Len(t, narrow.Hs, 2*16)
+	rp, V = prove(narrow, common.Vec{common.IntToZr(1<<15 - 1), common.IntToZr(0)})
+	assert.NoError(t, VerifyRange(narrow, rp, V))
+	assert.Error(t, VerifyRange(wide, rp, V))
+
+	v := common.Vec{common.IntToZr(1 << 15), common.IntToZr(0)}
+	_, err := ProveRange(narrow, narrow.Gs.MulV(v).Sum(), v, common.IntToZr(0))
+	assert.ErrorIs(t, err, common.ErrInvalidLiability)
+	assert.EqualError(t, err, "invalid liability: value 0 is not in [0, 2^15)")
+
+	// The bit width is recovered from the number of generators
+	raw, err := narrow.MarshalBinary()
+	assert.NoError(t, err)
+	var decoded RangeProofPublicParams
+	assert.NoError(t, decoded.UnmarshalBinary(raw))
+	assert.Equal(t, 15, decoded.Bits)
+
+	for _, bits := range []int{0, 14, 255} {
+		assert.Panics(t, func() {
+			NewRangeProofPublicParamsWithBits(2, bits)
+		}, "%d bits", bits)
+	}
+}
+
+func TestBatchVerifyRange(t *testing.T) {
+	pp := NewRangeProofPublicParams(4)
+
+	var proofs []*RangeProof
+	var commitments common.G1v
+	for i := 0; i < 3; i++ {
+		v := common.Vec{common.IntToZr(i), common.IntToZr(2), common.IntToZr(3), common.IntToZr(i + 5)}
+		r := common.RandVec(1)[0]
+
+		V := pp.F.Mul(r)
+		V.Add(pp.Gs.MulV(v).Sum())
+
+		rp, err := ProveRange(pp, V, v, r)
+		assert.NoError(t, err)
+		proofs = append(proofs, rp)
+		commitments = append(commitments, V)
+	}
+
+	assert.NoError(t, BatchVerifyRange(pp, proofs, commitments))
+	assert.NoError(t, BatchVerifyRange(pp, nil, nil))
+	assert.EqualError(t, BatchVerifyRange(pp, proofs[:2], commitments), "malformed proof: got 2 range proofs for 3 commitments")
+
+	// The proof that does not match its commitment is pointed out
+	commitments[0], commitments[2] = commitments[2], commitments[0]
+	assert.EqualError(t, BatchVerifyRange(pp, proofs, commitments), "range proof 0 is invalid: invalid range proof")
+
+	commitments[0], commitments[2] = commitments[2], commitments[0]
+	proofs[1].τ = proofs[1].τ.Plus(common.IntToZr(1))
+	assert.EqualError(t, BatchVerifyRange(pp, proofs, commitments), "range proof 1 is invalid: invalid range proof")
+
+	proofs[2].Δ = proofs[2].Δ[1:]
+	err := BatchVerifyRange(pp, proofs, commitments)
+	assert.EqualError(t, err, "range proof 2 is invalid: iterated reduction proof invalid: malformed proof: expected 2 rounds but got 1")
+	assert.True(t, errors.Is(err, common.ErrMalformedProof))
+
+	proofs[2] = &RangeProof{}
+	assert.True(t, errors.Is(BatchVerifyRange(pp, proofs, commitments), common.ErrMalformedProof))
 }
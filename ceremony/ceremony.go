@@ -0,0 +1,254 @@
+package ceremony
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"pol/common"
+	"pol/pp"
+
+	math "github.com/IBM/mathlib"
+)
+
+var c = math.Curves[1]
+
+// Contribution is the result of a single participant re-randomizing the public parameters
+// with a secret β, that is discarded right after the contribution is made.
+type Contribution struct {
+	// PP are the public parameters after the contribution
+	PP *pp.PP
+	// B1 = g1^β and B2 = g2^β
+	B1 *math.G1
+	B2 *math.G2
+	// R and S are a Schnorr proof of knowledge of β
+	R *math.G1
+	S *math.Zr
+}
+
+// Initial returns the public parameters the ceremony starts from, which correspond to α = 1.
+func Initial(N int) *pp.PP {
+	initial := &pp.PP{N: N}
+
+	for i := 0; i < 2*N; i++ {
+		initial.G1s = append(initial.G1s, c.GenG1.Copy())
+	}
+
+	for i := 0; i < N; i++ {
+		initial.G2s = append(initial.G2s, c.GenG2.Copy())
+	}
+
+	initial.Gt = common.G1v{c.GenG1}.InnerProd(common.G2v{c.GenG2})
+	initial.SetupDigest()
+
+	return initial
+}
+
+// Contribute re-randomizes prev with a fresh secret β sampled from rand, so that if α was the trapdoor
+// of prev, then α·β is the trapdoor of the returned public parameters.
+func Contribute(prev *pp.PP, rand io.Reader) (*Contribution, error) {
+	β := c.NewRandomZr(rand)
+	β.Mod(c.GroupOrder)
+	if β.Equals(c.NewZrFromInt(0)) {
+		return nil, fmt.Errorf("sampled a zero contribution")
+	}
+
+	N := prev.N
+	powers := common.PowerSeries(2*N+1, β)
+
+	next := &pp.PP{N: N}
+
+	for i := 0; i < 2*N; i++ {
+		// The generator in index N stays in place
+		if i == N {
+			next.G1s = append(next.G1s, prev.G1s[i].Copy())
+			continue
+		}
+		next.G1s = append(next.G1s, prev.G1s[i].Mul(powers[i+1]))
+	}
+
+	for i := 0; i < N; i++ {
+		next.G2s = append(next.G2s, prev.G2s[i].Mul(powers[i+1]))
+	}
+
+	next.Gt = prev.Gt.Exp(powers[N+1])
+	next.SetupDigest()
+
+	contribution := &Contribution{
+		PP: next,
+		B1: c.GenG1.Mul(β),
+		B2: c.GenG2.Mul(β),
+	}
+
+	k := c.NewRandomZr(rand)
+	contribution.R = c.GenG1.Mul(k)
+	e := contribution.challenge(prev)
+	contribution.S = c.ModAdd(k, c.ModMul(e, β, c.GroupOrder), c.GroupOrder)
+
+	return contribution, nil
+}
+
+// Verify checks that the contribution re-randomizes prev with the β its proof of knowledge is for.
+func (contribution *Contribution) Verify(prev *pp.PP) error {
+	next := contribution.PP
+	if next.N != prev.N {
+		return fmt.Errorf("contribution is for vector commitments of size %d but should be of size %d", next.N, prev.N)
+	}
+
+	if contribution.B1.IsInfinity() {
+		return fmt.Errorf("contribution is zero")
+	}
+
+	// g1^s = R·B1^e
+	e := contribution.challenge(prev)
+	left := c.GenG1.Mul(contribution.S)
+	right := contribution.B1.Mul(e)
+	right.Add(contribution.R)
+	if !left.Equals(right) {
+		return fmt.Errorf("invalid proof of knowledge")
+	}
+
+	// e(B1, g2) = e(g1, B2)
+	if !samePairing(contribution.B1, c.GenG2, c.GenG1, contribution.B2) {
+		return fmt.Errorf("contribution in G1 and G2 do not match")
+	}
+
+	// e(G1s'[0], g2) = e(G1s[0], B2)
+	if !samePairing(next.G1s[0], c.GenG2, prev.G1s[0], contribution.B2) {
+		return fmt.Errorf("public parameters are not re-randomized by the contribution")
+	}
+
+	return next.Validate()
+}
+
+func (contribution *Contribution) challenge(prev *pp.PP) *math.Zr {
+	h := sha256.New()
+	h.Write(prev.Digest)
+	h.Write(contribution.PP.Digest)
+	h.Write(contribution.B1.Bytes())
+	h.Write(contribution.B2.Bytes())
+	h.Write(contribution.R.Bytes())
+	return common.FieldElementFromBytes(h.Sum(nil))
+}
+
+func (contribution *Contribution) MarshalBinary() ([]byte, error) {
+	e := common.NewEncoder()
+	e.Marshaler(contribution.PP)
+	e.G1(contribution.B1)
+	e.G2(contribution.B2)
+	e.G1(contribution.R)
+	e.Zr(contribution.S)
+	return e.Result()
+}
+
+func (contribution *Contribution) UnmarshalBinary(b []byte) error {
+	d := common.NewDecoder(b)
+	contribution.PP = &pp.PP{}
+	d.Unmarshaler(contribution.PP)
+	contribution.B1 = d.G1()
+	contribution.B2 = d.G2()
+	contribution.R = d.G1()
+	contribution.S = d.Zr()
+	return d.Finish()
+}
+
+// Transcript is the sequence of contributions of all participants of the ceremony.
+// The resulting public parameters are secure as long as at least one participant discarded its β.
+type Transcript struct {
+	N             int
+	Contributions []*Contribution
+}
+
+func NewTranscript(N int) *Transcript {
+	return &Transcript{N: N}
+}
+
+// Current returns the public parameters after the last contribution.
+func (t *Transcript) Current() *pp.PP {
+	if len(t.Contributions) == 0 {
+		return Initial(t.N)
+	}
+	return t.Contributions[len(t.Contributions)-1].PP
+}
+
+// Add verifies the contribution against the current public parameters and appends it to the transcript.
+func (t *Transcript) Add(contribution *Contribution) error {
+	if err := contribution.Verify(t.Current()); err != nil {
+		return fmt.Errorf("contribution %d is invalid: %v", len(t.Contributions), err)
+	}
+	t.Contributions = append(t.Contributions, contribution)
+	return nil
+}
+
+// Verify checks the entire transcript starting from the initial public parameters.
+func (t *Transcript) Verify() error {
+	if len(t.Contributions) == 0 {
+		return fmt.Errorf("transcript has no contributions")
+	}
+
+	if err := t.checkSizes(); err != nil {
+		return err
+	}
+
+	prev := Initial(t.N)
+	for i, contribution := range t.Contributions {
+		if err := contribution.Verify(prev); err != nil {
+			return fmt.Errorf("contribution %d is invalid: %v", i, err)
+		}
+		prev = contribution.PP
+	}
+
+	return nil
+}
+
+// Result verifies the transcript and returns the public parameters it produced.
+func (t *Transcript) Result() (*pp.PP, error) {
+	if err := t.Verify(); err != nil {
+		return nil, err
+	}
+	return t.Current(), nil
+}
+
+func (t *Transcript) MarshalBinary() ([]byte, error) {
+	e := common.NewEncoder()
+	e.Uint32(uint32(t.N))
+	e.Uint32(uint32(len(t.Contributions)))
+	for _, contribution := range t.Contributions {
+		e.Marshaler(contribution)
+	}
+	return e.Result()
+}
+
+func (t *Transcript) UnmarshalBinary(b []byte) error {
+	d := common.NewDecoder(b)
+	t.N = int(d.Uint32())
+	t.Contributions = make([]*Contribution, d.Len(4))
+	for i := range t.Contributions {
+		t.Contributions[i] = &Contribution{}
+		d.Unmarshaler(t.Contributions[i])
+	}
+	if err := d.Finish(); err != nil {
+		return err
+	}
+
+	// A transcript without contributions carries nothing to check N against
+	if len(t.Contributions) == 0 {
+		return fmt.Errorf("transcript has no contributions")
+	}
+	return t.checkSizes()
+}
+
+// checkSizes checks that the public parameters of every contribution are for vector commitments of size N.
+// N may come from untrusted bytes, so it is checked before any public parameters of size N are built from it.
+func (t *Transcript) checkSizes() error {
+	for i, contribution := range t.Contributions {
+		if contribution.PP.N != t.N || len(contribution.PP.G2s) != t.N {
+			return fmt.Errorf("contribution %d is for vector commitments of size %d but the transcript is of size %d", i, len(contribution.PP.G2s), t.N)
+		}
+	}
+	return nil
+}
+
+// samePairing returns whether e(a, b) = e(x, y)
+func samePairing(a *math.G1, b *math.G2, x *math.G1, y *math.G2) bool {
+	return common.G1v{a, common.G1v{x}.Neg()[0]}.InnerProd(common.G2v{b, y}).IsUnity()
+}
@@ -0,0 +1,126 @@
+package ceremony
+
+import (
+	"crypto/rand"
+	"pol/common"
+	"pol/pp"
+	"testing"
+
+	math "github.com/IBM/mathlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCeremony(t *testing.T) {
+	N := 8
+	transcript := NewTranscript(N)
+
+	for i := 0; i < 3; i++ {
+		contribution, err := Contribute(transcript.Current(), rand.Reader)
+		assert.NoError(t, err)
+		assert.NoError(t, transcript.Add(contribution))
+	}
+
+	publicParams, err := transcript.Result()
+	assert.NoError(t, err)
+
+	m := common.RandVec(N)
+	C := pp.Commit(publicParams, m)
+	for i := 0; i < N; i++ {
+		mi, π := pp.Open(publicParams, i, m)
+		assert.NoError(t, pp.Verify(publicParams, mi, π, C, i))
+	}
+
+	raw, err := transcript.MarshalBinary()
+	assert.NoError(t, err)
+
+	var decoded Transcript
+	assert.NoError(t, decoded.UnmarshalBinary(raw))
+	decodedPP, err := decoded.Result()
+	assert.NoError(t, err)
+	assert.Equal(t, publicParams.Digest, decodedPP.Digest)
+}
+
+func TestCeremonyRejectsInvalidContributions(t *testing.T) {
+	N := 4
+	transcript := NewTranscript(N)
+
+	first, err := Contribute(transcript.Current(), rand.Reader)
+	assert.NoError(t, err)
+	assert.NoError(t, transcript.Add(first))
+
+	t.Run("replayed", func(t *testing.T) {
+		assert.EqualError(t, transcript.Add(first), "contribution 1 is invalid: invalid proof of knowledge")
+	})
+
+	t.Run("proof of knowledge", func(t *testing.T) {
+		contribution, err := Contribute(transcript.Current(), rand.Reader)
+		assert.NoError(t, err)
+		contribution.S = contribution.S.Plus(common.IntToZr(1))
+		assert.EqualError(t, transcript.Add(contribution), "contribution 1 is invalid: invalid proof of knowledge")
+	})
+
+	t.Run("not re-randomized", func(t *testing.T) {
+		// A participant that publishes public parameters of its own choosing
+		// cannot prove they are derived from the current ones.
+		own, err := Contribute(Initial(N), rand.Reader)
+		assert.NoError(t, err)
+
+		contribution := &Contribution{PP: own.PP}
+		proveKnowledge(contribution, transcript.Current(), common.RandVec(1)[0])
+
+		assert.EqualError(t, transcript.Add(contribution), "contribution 1 is invalid: public parameters are not re-randomized by the contribution")
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		prev := transcript.Current()
+		β := common.RandVec(1)[0]
+		contribution := &Contribution{PP: &pp.PP{N: N, Gt: prev.Gt.Exp(common.PowerSeries(N+2, β)[N+1])}}
+		for i := 0; i < N; i++ {
+			contribution.PP.G2s = append(contribution.PP.G2s, prev.G2s[i].Mul(common.PowerSeries(i+2, β)[i+1]))
+		}
+		// Only re-randomize the first element in G1
+		contribution.PP.G1s = append(common.G1v{prev.G1s[0].Mul(β)}, prev.G1s[1:]...)
+		contribution.PP.SetupDigest()
+		proveKnowledge(contribution, prev, β)
+
+		assert.EqualError(t, transcript.Add(contribution), "contribution 1 is invalid: G1 elements are not consecutive powers of α")
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		_, err := NewTranscript(N).Result()
+		assert.EqualError(t, err, "transcript has no contributions")
+	})
+}
+
+func TestTranscriptRejectsWrongSize(t *testing.T) {
+	N := 4
+	transcript := NewTranscript(N)
+	contribution, err := Contribute(transcript.Current(), rand.Reader)
+	assert.NoError(t, err)
+	assert.NoError(t, transcript.Add(contribution))
+
+	transcript.N = 1<<32 - 1
+	assert.EqualError(t, transcript.Verify(), "contribution 0 is for vector commitments of size 4 but the transcript is of size 4294967295")
+
+	raw, err := transcript.MarshalBinary()
+	assert.NoError(t, err)
+	var decoded Transcript
+	assert.EqualError(t, decoded.UnmarshalBinary(raw), "contribution 0 is for vector commitments of size 4 but the transcript is of size 4294967295")
+
+	// N = 2^32-1 followed by zero contributions
+	e := common.NewEncoder()
+	e.Uint32(1<<32 - 1)
+	e.Uint32(0)
+	raw, err = e.Result()
+	assert.NoError(t, err)
+	assert.EqualError(t, decoded.UnmarshalBinary(raw), "transcript has no contributions")
+}
+
+func proveKnowledge(contribution *Contribution, prev *pp.PP, β *math.Zr) {
+	k := common.RandVec(1)[0]
+	contribution.B1 = c.GenG1.Mul(β)
+	contribution.B2 = c.GenG2.Mul(β)
+	contribution.R = c.GenG1.Mul(k)
+	e := contribution.challenge(prev)
+	contribution.S = c.ModAdd(k, c.ModMul(e, β, c.GroupOrder), c.GroupOrder)
+}
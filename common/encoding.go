@@ -0,0 +1,422 @@
+package common
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"reflect"
+
+	math "github.com/IBM/mathlib"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+)
+
+// EncodingVersion is the version of the binary encoding of proofs.
+// It is written as the first byte of every encoded proof, and decoding
+// fails if it does not match.
+// It is bumped whenever proofs of the previous version no longer verify:
+// version 2 changed the Fiat-Shamir transcript of the range proofs.
+const EncodingVersion byte = 2
+
+const (
+	// ZrSize is the size in bytes of an encoded scalar
+	ZrSize = 32
+	// G1Size is the size in bytes of an encoded (compressed) G1 point
+	G1Size = bn254.SizeOfG1AffineCompressed
+	// G2Size is the size in bytes of an encoded (compressed) G2 point
+	G2Size = bn254.SizeOfG2AffineCompressed
+	// GtSize is the size in bytes of an encoded Gt element
+	GtSize = bn254.SizeOfGT
+)
+
+// ZrToBytes returns the canonical big-endian encoding of x reduced modulo the group order.
+func ZrToBytes(x *math.Zr) []byte {
+	return reduce(x).Bytes()
+}
+
+// ZrFromBytes decodes a scalar and rejects any encoding that is not the canonical
+// one produced by ZrToBytes.
+func ZrFromBytes(b []byte) (*math.Zr, error) {
+	if len(b) != ZrSize {
+		return nil, fmt.Errorf("scalar should be of size %d but is of size %d", ZrSize, len(b))
+	}
+	n := new(big.Int).SetBytes(b)
+	order := new(big.Int).SetBytes(GroupOrder.Bytes())
+	if n.Cmp(order) >= 0 {
+		return nil, fmt.Errorf("scalar is not reduced modulo the group order")
+	}
+	return c.NewZrFromBytes(b), nil
+}
+
+// G1ToBytes returns the compressed encoding of g.
+func G1ToBytes(g *math.G1) []byte {
+	var p bn254.G1Affine
+	if _, err := p.SetBytes(g.Bytes()); err != nil {
+		panic(fmt.Sprintf("failed converting G1 point: %v", err))
+	}
+	compressed := p.Bytes()
+	return compressed[:]
+}
+
+// G1FromBytes decodes a compressed G1 point and rejects any encoding that is not
+// the canonical one produced by G1ToBytes.
+func G1FromBytes(b []byte) (*math.G1, error) {
+	if len(b) != G1Size {
+		return nil, fmt.Errorf("G1 point should be of size %d but is of size %d", G1Size, len(b))
+	}
+	var p bn254.G1Affine
+	if _, err := p.SetBytes(b); err != nil {
+		return nil, fmt.Errorf("invalid G1 point: %v", err)
+	}
+	if compressed := p.Bytes(); !bytes.Equal(compressed[:], b) {
+		return nil, fmt.Errorf("G1 point is not canonically encoded")
+	}
+	raw := p.RawBytes()
+	return c.NewG1FromBytes(raw[:])
+}
+
+// G2ToBytes returns the compressed encoding of g.
+func G2ToBytes(g *math.G2) []byte {
+	var p bn254.G2Affine
+	if _, err := p.SetBytes(g.Bytes()); err != nil {
+		panic(fmt.Sprintf("failed converting G2 point: %v", err))
+	}
+	compressed := p.Bytes()
+	return compressed[:]
+}
+
+// G2FromBytes decodes a compressed G2 point and rejects any encoding that is not
+// the canonical one produced by G2ToBytes.
+func G2FromBytes(b []byte) (*math.G2, error) {
+	if len(b) != G2Size {
+		return nil, fmt.Errorf("G2 point should be of size %d but is of size %d", G2Size, len(b))
+	}
+	var p bn254.G2Affine
+	if _, err := p.SetBytes(b); err != nil {
+		return nil, fmt.Errorf("invalid G2 point: %v", err)
+	}
+	if compressed := p.Bytes(); !bytes.Equal(compressed[:], b) {
+		return nil, fmt.Errorf("G2 point is not canonically encoded")
+	}
+	raw := p.RawBytes()
+	return c.NewG2FromBytes(raw[:])
+}
+
+// GtFromBytes decodes a Gt element and rejects any encoding that is not
+// the canonical one produced by its Bytes method.
+func GtFromBytes(b []byte) (*math.Gt, error) {
+	if len(b) != GtSize {
+		return nil, fmt.Errorf("Gt element should be of size %d but is of size %d", GtSize, len(b))
+	}
+	var e bn254.GT
+	if err := e.SetBytes(b); err != nil {
+		return nil, fmt.Errorf("invalid Gt element: %v", err)
+	}
+	if canonical := e.Bytes(); !bytes.Equal(canonical[:], b) {
+		return nil, fmt.Errorf("Gt element is not canonically encoded")
+	}
+	return c.NewGtFromBytes(b)
+}
+
+func reduce(x *math.Zr) *math.Zr {
+	y := x.Copy()
+	y.Mod(GroupOrder)
+	return y
+}
+
+// Encoder writes the binary encoding of proofs.
+// All variable length fields are prefixed with their length.
+// The first error encountered is retained and returned by Result.
+type Encoder struct {
+	buff bytes.Buffer
+	err  error
+}
+
+// NewEncoder returns an Encoder that has already written the encoding version.
+func NewEncoder() *Encoder {
+	e := &Encoder{}
+	e.buff.WriteByte(EncodingVersion)
+	return e
+}
+
+func (e *Encoder) Uint32(n uint32) {
+	buff := make([]byte, 4)
+	binary.BigEndian.PutUint32(buff, n)
+	e.buff.Write(buff)
+}
+
+func (e *Encoder) Uint64(n uint64) {
+	buff := make([]byte, 8)
+	binary.BigEndian.PutUint64(buff, n)
+	e.buff.Write(buff)
+}
+
+func (e *Encoder) Bytes(b []byte) {
+	e.Uint32(uint32(len(b)))
+	e.buff.Write(b)
+}
+
+func (e *Encoder) Zr(x *math.Zr) {
+	if x == nil {
+		e.fail("cannot encode a nil scalar")
+		return
+	}
+	e.buff.Write(ZrToBytes(x))
+}
+
+func (e *Encoder) G1(g *math.G1) {
+	if g == nil {
+		e.fail("cannot encode a nil G1 point")
+		return
+	}
+	e.buff.Write(G1ToBytes(g))
+}
+
+func (e *Encoder) G2(g *math.G2) {
+	if g == nil {
+		e.fail("cannot encode a nil G2 point")
+		return
+	}
+	e.buff.Write(G2ToBytes(g))
+}
+
+func (e *Encoder) Gt(g *math.Gt) {
+	if g == nil {
+		e.fail("cannot encode a nil Gt element")
+		return
+	}
+	e.buff.Write(g.Bytes())
+}
+
+func (e *Encoder) Vec(v Vec) {
+	e.Uint32(uint32(len(v)))
+	for _, x := range v {
+		e.Zr(x)
+	}
+}
+
+func (e *Encoder) G1v(v G1v) {
+	e.Uint32(uint32(len(v)))
+	for _, g := range v {
+		e.G1(g)
+	}
+}
+
+func (e *Encoder) G2v(v G2v) {
+	e.Uint32(uint32(len(v)))
+	for _, g := range v {
+		e.G2(g)
+	}
+}
+
+// Marshaler writes the length prefixed encoding of m.
+func (e *Encoder) Marshaler(m encoding.BinaryMarshaler) {
+	if v := reflect.ValueOf(m); m == nil || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		e.fail("cannot encode a nil %T", m)
+		return
+	}
+	b, err := m.MarshalBinary()
+	if err != nil {
+		if e.err == nil {
+			e.err = err
+		}
+		return
+	}
+	e.Bytes(b)
+}
+
+// Result returns the encoding, or the first error encountered while encoding.
+func (e *Encoder) Result() ([]byte, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.buff.Bytes(), nil
+}
+
+func (e *Encoder) fail(format string, args ...interface{}) {
+	if e.err == nil {
+		e.err = fmt.Errorf(format, args...)
+	}
+}
+
+// Decoder reads the binary encoding written by an Encoder.
+// The first error encountered is retained, after which all reads return zero values.
+type Decoder struct {
+	buff []byte
+	err  error
+}
+
+// NewDecoder returns a Decoder over b that has already verified the encoding version.
+func NewDecoder(b []byte) *Decoder {
+	d := &Decoder{buff: b}
+	version := d.next(1)
+	if d.err == nil && version[0] != EncodingVersion {
+		d.fail("unsupported encoding version %d", version[0])
+	}
+	return d
+}
+
+func (d *Decoder) Uint32() uint32 {
+	b := d.next(4)
+	if d.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *Decoder) Uint64() uint64 {
+	b := d.next(8)
+	if d.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (d *Decoder) Bytes() []byte {
+	n := d.Uint32()
+	return d.next(int(n))
+}
+
+func (d *Decoder) Zr() *math.Zr {
+	b := d.next(ZrSize)
+	if d.err != nil {
+		return nil
+	}
+	x, err := ZrFromBytes(b)
+	if err != nil {
+		d.fail("%v", err)
+		return nil
+	}
+	return x
+}
+
+func (d *Decoder) G1() *math.G1 {
+	b := d.next(G1Size)
+	if d.err != nil {
+		return nil
+	}
+	g, err := G1FromBytes(b)
+	if err != nil {
+		d.fail("%v", err)
+		return nil
+	}
+	return g
+}
+
+func (d *Decoder) G2() *math.G2 {
+	b := d.next(G2Size)
+	if d.err != nil {
+		return nil
+	}
+	g, err := G2FromBytes(b)
+	if err != nil {
+		d.fail("%v", err)
+		return nil
+	}
+	return g
+}
+
+func (d *Decoder) Gt() *math.Gt {
+	b := d.next(GtSize)
+	if d.err != nil {
+		return nil
+	}
+	g, err := GtFromBytes(b)
+	if err != nil {
+		d.fail("%v", err)
+		return nil
+	}
+	return g
+}
+
+func (d *Decoder) Vec() Vec {
+	n := d.Len(ZrSize)
+	if d.err != nil {
+		return nil
+	}
+	v := make(Vec, n)
+	for i := 0; i < n; i++ {
+		v[i] = d.Zr()
+	}
+	return v
+}
+
+func (d *Decoder) G1v() G1v {
+	n := d.Len(G1Size)
+	if d.err != nil {
+		return nil
+	}
+	v := make(G1v, n)
+	for i := 0; i < n; i++ {
+		v[i] = d.G1()
+	}
+	return v
+}
+
+func (d *Decoder) G2v() G2v {
+	n := d.Len(G2Size)
+	if d.err != nil {
+		return nil
+	}
+	v := make(G2v, n)
+	for i := 0; i < n; i++ {
+		v[i] = d.G2()
+	}
+	return v
+}
+
+// Len reads the number of elements of a vector whose elements are encoded in at least
+// elementSize bytes, and makes sure the remaining input is large enough to hold them.
+func (d *Decoder) Len(elementSize int) int {
+	n := d.Uint32()
+	if d.err != nil {
+		return 0
+	}
+	if uint64(n)*uint64(elementSize) > uint64(len(d.buff)) {
+		d.fail("vector of %d elements exceeds the remaining %d bytes", n, len(d.buff))
+		return 0
+	}
+	return int(n)
+}
+
+// Unmarshaler decodes the next length prefixed field into u.
+func (d *Decoder) Unmarshaler(u encoding.BinaryUnmarshaler) {
+	b := d.Bytes()
+	if d.err != nil {
+		return
+	}
+	if err := u.UnmarshalBinary(b); err != nil {
+		d.fail("%v", err)
+	}
+}
+
+// Finish returns the first error encountered, or an error if there are bytes left over.
+func (d *Decoder) Finish() error {
+	if d.err != nil {
+		return d.err
+	}
+	if len(d.buff) != 0 {
+		return fmt.Errorf("%d trailing bytes", len(d.buff))
+	}
+	return nil
+}
+
+func (d *Decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.buff) {
+		d.fail("expected %d bytes but only %d remain", n, len(d.buff))
+		return nil
+	}
+	b := d.buff[:n]
+	d.buff = d.buff[n:]
+	return b
+}
+
+func (d *Decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf(format, args...)
+	}
+}
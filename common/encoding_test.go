@@ -0,0 +1,80 @@
+package common
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncoding(t *testing.T) {
+	x := RandVec(1)[0]
+	g := c.GenG1.Mul(x)
+	gt := e(g, c.GenG2)
+
+	e := NewEncoder()
+	e.Zr(x)
+	e.G1(g)
+	e.Vec(Vec{x, IntToZr(-1)})
+	e.G1v(G1v{g, zeroG1})
+	e.Uint64(42)
+	e.G2v(G2v{c.GenG2.Mul(x)})
+	e.Gt(gt)
+	b, err := e.Result()
+	assert.NoError(t, err)
+
+	d := NewDecoder(b)
+	assert.True(t, x.Equals(d.Zr()))
+	assert.True(t, g.Equals(d.G1()))
+	v := d.Vec()
+	assert.Len(t, v, 2)
+	assert.True(t, x.Equals(v[0]))
+	assert.Equal(t, ZrToBytes(IntToZr(-1)), ZrToBytes(v[1]))
+	gv := d.G1v()
+	assert.Len(t, gv, 2)
+	assert.True(t, gv[1].IsInfinity())
+	assert.Equal(t, uint64(42), d.Uint64())
+	g2v := d.G2v()
+	assert.Len(t, g2v, 1)
+	assert.True(t, c.GenG2.Mul(x).Equals(g2v[0]))
+	assert.True(t, gt.Equals(d.Gt()))
+	assert.NoError(t, d.Finish())
+
+	d = NewDecoder(append(b, 0))
+	d.Zr()
+	d.G1()
+	d.Vec()
+	d.G1v()
+	d.Uint64()
+	d.G2v()
+	d.Gt()
+	assert.EqualError(t, d.Finish(), "1 trailing bytes")
+
+	b[0] = EncodingVersion + 1
+	assert.EqualError(t, NewDecoder(b).Finish(), fmt.Sprintf("unsupported encoding version %d", EncodingVersion+1))
+}
+
+func TestEncodingRejectsNonCanonical(t *testing.T) {
+	order := new(big.Int).SetBytes(GroupOrder.Bytes())
+	_, err := ZrFromBytes(order.FillBytes(make([]byte, ZrSize)))
+	assert.EqualError(t, err, "scalar is not reduced modulo the group order")
+
+	// The generator of G1 is (1, 2), so adding the field modulus to its x coordinate
+	// yields a different encoding of the same point.
+	gen := G1ToBytes(c.GenG1)
+	p, _ := new(big.Int).SetString("21888242871839275222246405745257275088696311157297823662689037894645226208583", 10)
+	x := new(big.Int).Add(big.NewInt(1), p).FillBytes(make([]byte, G1Size))
+	x[0] |= gen[0] & 0xC0
+	_, err = G1FromBytes(x)
+	assert.EqualError(t, err, "G1 point is not canonically encoded")
+
+	_, err = G1FromBytes(gen[1:])
+	assert.Error(t, err)
+
+	assert.EqualError(t, NewDecoder(nil).Finish(), "expected 1 bytes but only 0 remain")
+
+	d := NewDecoder([]byte{EncodingVersion, 0xff, 0xff, 0xff, 0xff})
+	assert.Nil(t, d.G1v())
+	assert.EqualError(t, d.Finish(), "vector of 4294967295 elements exceeds the remaining 0 bytes")
+}
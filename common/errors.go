@@ -0,0 +1,22 @@
+package common
+
+import "errors"
+
+// The errors returned on malformed input, to be told apart with errors.Is.
+// They are wrapped with the details of the failure.
+var (
+	// ErrInvalidID is returned for an id that cannot be mapped to a path in a tree
+	ErrInvalidID = errors.New("invalid id")
+	// ErrNotFound is returned for an id that is not in a tree
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidLiability is returned for a liability that is negative, or does not fit in the range proofs
+	ErrInvalidLiability = errors.New("invalid liability")
+	// ErrUnsupportedFanout is returned for a fanout that no mapping of ids to paths supports
+	ErrUnsupportedFanout = errors.New("unsupported fanout")
+	// ErrMalformedProof is returned for a proof that is not structured like the proofs of the public parameters
+	ErrMalformedProof = errors.New("malformed proof")
+	// ErrInvalidProof is returned for a well-formed proof that does not verify
+	ErrInvalidProof = errors.New("invalid proof")
+	// ErrMalformedVertex is returned for a vertex that cannot be decoded
+	ErrMalformedVertex = errors.New("malformed vertex")
+)
@@ -0,0 +1,160 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	math "github.com/IBM/mathlib"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+)
+
+const (
+	// fixedBaseWindow is the number of bits of the scalar that every lookup in a fixed-base table covers
+	fixedBaseWindow = 4
+	// fixedBaseWindows is the number of windows of a scalar, which is at most 32 bytes long
+	fixedBaseWindows = 256 / fixedBaseWindow
+	// fixedBaseDigits is the number of non-zero digits in a window
+	fixedBaseDigits = 1<<fixedBaseWindow - 1
+	// fixedBaseThreshold is the number of multiplications after which a table is built.
+	// Building a table costs about as much as 10 multiplications, so generators that are rarely multiplied do without one.
+	fixedBaseThreshold = 16
+)
+
+// FixedBase is a generator that is multiplied by many scalars.
+// The multiples d·2^{4w}·g for every window w and digit d are computed once, when the generator turns out
+// to be multiplied often, and from then on every multiplication is made of a single addition per window of the scalar, without doublings.
+type FixedBase struct {
+	// uses is the number of multiplications so far, and is first so that it is aligned for atomic operations
+	uses  int64
+	g     *math.G1
+	once  sync.Once
+	table []bn254.G1Affine
+}
+
+func NewFixedBase(g *math.G1) *FixedBase {
+	return &FixedBase{g: g}
+}
+
+// Point returns the generator.
+func (fb *FixedBase) Point() *math.G1 {
+	return fb.g
+}
+
+// Matches returns whether the table is of the given generator.
+func (fb *FixedBase) Matches(g *math.G1) bool {
+	return fb.g == g
+}
+
+// Mul returns x·g.
+func (fb *FixedBase) Mul(x *math.Zr) *math.G1 {
+	if atomic.AddInt64(&fb.uses, 1) <= fixedBaseThreshold {
+		return fb.g.Mul(x)
+	}
+
+	fb.once.Do(fb.precompute)
+
+	scalar := x.Copy()
+	scalar.Mod(GroupOrder)
+	raw := scalar.Bytes()
+	if len(raw) != 32 {
+		panic(fmt.Sprintf("scalar is of %d bytes, not 32", len(raw)))
+	}
+
+	var acc bn254.G1Jac
+	for w := 0; w < fixedBaseWindows; w++ {
+		// Windows go from the least significant bits to the most significant ones
+		b := raw[len(raw)-1-w/2]
+		digit := b & fixedBaseDigits
+		if w%2 == 1 {
+			digit = b >> fixedBaseWindow
+		}
+		if digit == 0 {
+			continue
+		}
+		acc.AddMixed(&fb.table[w*fixedBaseDigits+int(digit)-1])
+	}
+
+	var result bn254.G1Affine
+	result.FromJacobian(&acc)
+	return fromAffine(&result)
+}
+
+func (fb *FixedBase) precompute() {
+	var g bn254.G1Affine
+	toAffine(&g, fb.g)
+
+	var base bn254.G1Jac
+	base.FromAffine(&g)
+
+	multiples := make([]bn254.G1Jac, fixedBaseWindows*fixedBaseDigits)
+	for w := 0; w < fixedBaseWindows; w++ {
+		window := multiples[w*fixedBaseDigits : (w+1)*fixedBaseDigits]
+		window[0] = base
+		for d := 1; d < fixedBaseDigits; d++ {
+			window[d] = window[d-1]
+			window[d].AddAssign(&base)
+		}
+		// The base of the next window is 2^4 times the base of this window
+		base = window[fixedBaseDigits-1]
+		base.AddAssign(&window[0])
+	}
+
+	fb.table = make([]bn254.G1Affine, len(multiples))
+	bn254.BatchJacobianToAffineG1(multiples, fb.table)
+}
+
+// FixedBaseVec is a vector of generators that are used in many multi-scalar multiplications.
+// The generators are converted once to the representation the multi-scalar multiplication works with,
+// and every generator that is multiplied on its own gets a FixedBase table of its own.
+type FixedBaseVec struct {
+	points G1v
+	bases  []*FixedBase
+	once   sync.Once
+	affine []bn254.G1Affine
+}
+
+func NewFixedBaseVec(points G1v) *FixedBaseVec {
+	bases := make([]*FixedBase, len(points))
+	for i := range points {
+		bases[i] = NewFixedBase(points[i])
+	}
+	return &FixedBaseVec{
+		points: points,
+		bases:  bases,
+	}
+}
+
+// Matches returns whether the tables are of the given generators.
+func (fbv *FixedBaseVec) Matches(points G1v) bool {
+	if len(fbv.points) != len(points) {
+		return false
+	}
+	for i := range points {
+		if fbv.points[i] != points[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Mul returns x·points[i].
+func (fbv *FixedBaseVec) Mul(i int, x *math.Zr) *math.G1 {
+	return fbv.bases[i].Mul(x)
+}
+
+// MultiExp returns Σ scalars[j]·points[from+j].
+func (fbv *FixedBaseVec) MultiExp(from int, scalars Vec) *math.G1 {
+	if from < 0 || from+len(scalars) > len(fbv.points) {
+		panic(fmt.Sprintf("cannot multiply %d scalars from generator %d out of %d", len(scalars), from, len(fbv.points)))
+	}
+
+	fbv.once.Do(func() {
+		fbv.affine = make([]bn254.G1Affine, len(fbv.points))
+		for i := range fbv.points {
+			toAffine(&fbv.affine[i], fbv.points[i])
+		}
+	})
+
+	return multiExp(fbv.affine[from:from+len(scalars)], scalars)
+}
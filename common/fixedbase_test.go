@@ -0,0 +1,78 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixedBase(t *testing.T) {
+	g := RandGenVec(1, "fixed base test")[0]
+	fb := NewFixedBase(g)
+
+	minusOne := NegZr(IntToZr(1))
+	scalars := Vec{
+		IntToZr(0),
+		IntToZr(1),
+		IntToZr(15),
+		IntToZr(16),
+		minusOne,
+		// Not reduced modulo the group order
+		minusOne.Plus(IntToZr(2)),
+	}
+	scalars = append(scalars, RandVec(10)...)
+
+	// The first multiplications are done without the table, and the rest with it
+	for round := 0; round*len(scalars) <= fixedBaseThreshold; round++ {
+		for i, x := range scalars {
+			assert.True(t, g.Mul(x).Equals(fb.Mul(x)), "scalar %d", i)
+		}
+	}
+	assert.NotEmpty(t, fb.table)
+
+	assert.True(t, fb.Matches(g))
+	assert.False(t, fb.Matches(g.Copy()))
+
+	// The point at infinity has a table as well
+	infinity := NewFixedBase(zeroG1)
+	for i := 0; i <= fixedBaseThreshold; i++ {
+		assert.True(t, zeroG1.Equals(infinity.Mul(RandVec(1)[0])))
+	}
+}
+
+func TestFixedBaseVec(t *testing.T) {
+	points := RandGenVec(8, "fixed base vector test")
+	fbv := NewFixedBaseVec(points)
+
+	scalars := RandVec(len(points))
+	assert.True(t, MultiExp(points, scalars).Equals(fbv.MultiExp(0, scalars)))
+	assert.True(t, MultiExp(points[2:5], scalars[:3]).Equals(fbv.MultiExp(2, scalars[:3])))
+	assert.True(t, zeroG1.Equals(fbv.MultiExp(len(points), nil)))
+
+	for i := range points {
+		assert.True(t, points[i].Mul(scalars[i]).Equals(fbv.Mul(i, scalars[i])))
+	}
+
+	assert.Panics(t, func() {
+		fbv.MultiExp(6, scalars[:3])
+	})
+
+	assert.True(t, fbv.Matches(points))
+	assert.False(t, fbv.Matches(points[1:]))
+	assert.False(t, fbv.Matches(RandGenVec(8, "fixed base vector test")))
+}
+
+func TestRandGenVecCache(t *testing.T) {
+	short := RandGenVec(2, "cache test")
+	long := RandGenVec(4, "cache test")
+
+	assert.Len(t, long, 4)
+	for i := range short {
+		assert.True(t, short[i].Equals(long[i]))
+		// Every call gets its own copies of the generators
+		assert.NotSame(t, short[i], long[i])
+	}
+
+	assert.Same(t, Generator("cache test"), Generator("cache test"))
+	assert.True(t, long[0].Equals(Generator("cache test").Point()))
+}
@@ -0,0 +1,182 @@
+package common
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	math "github.com/IBM/mathlib"
+)
+
+// HexEncoder encodes group elements and scalars as lowercase hexadecimal strings
+// for the JSON representation of proofs.
+// Points are compressed and scalars are reduced, exactly as in the binary encoding.
+// The first error encountered is retained and returned by Err.
+type HexEncoder struct {
+	err error
+}
+
+func (e *HexEncoder) Zr(x *math.Zr) string {
+	if x == nil {
+		e.fail("cannot encode a nil scalar")
+		return ""
+	}
+	return hex.EncodeToString(ZrToBytes(x))
+}
+
+func (e *HexEncoder) G1(g *math.G1) string {
+	if g == nil {
+		e.fail("cannot encode a nil G1 point")
+		return ""
+	}
+	return hex.EncodeToString(G1ToBytes(g))
+}
+
+func (e *HexEncoder) Vec(v Vec) []string {
+	res := make([]string, len(v))
+	for i, x := range v {
+		res[i] = e.Zr(x)
+	}
+	return res
+}
+
+func (e *HexEncoder) G1v(v G1v) []string {
+	res := make([]string, len(v))
+	for i, g := range v {
+		res[i] = e.G1(g)
+	}
+	return res
+}
+
+// JSON returns the JSON encoding of m.
+func (e *HexEncoder) JSON(m json.Marshaler) json.RawMessage {
+	if v := reflect.ValueOf(m); m == nil || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		e.fail("cannot encode a nil %T", m)
+		return nil
+	}
+	raw, err := m.MarshalJSON()
+	if err != nil {
+		e.fail("%v", err)
+		return nil
+	}
+	return raw
+}
+
+func (e *HexEncoder) Err() error {
+	return e.err
+}
+
+func (e *HexEncoder) fail(format string, args ...interface{}) {
+	if e.err == nil {
+		e.err = fmt.Errorf(format, args...)
+	}
+}
+
+// HexDecoder decodes the strings written by a HexEncoder and rejects any
+// string that is not the canonical encoding of its element.
+// The first error encountered is retained and returned by Err.
+type HexDecoder struct {
+	err error
+}
+
+func (d *HexDecoder) Zr(s string) *math.Zr {
+	b := d.decode(s)
+	if d.err != nil {
+		return nil
+	}
+	x, err := ZrFromBytes(b)
+	if err != nil {
+		d.fail("%v", err)
+		return nil
+	}
+	return x
+}
+
+func (d *HexDecoder) G1(s string) *math.G1 {
+	b := d.decode(s)
+	if d.err != nil {
+		return nil
+	}
+	g, err := G1FromBytes(b)
+	if err != nil {
+		d.fail("%v", err)
+		return nil
+	}
+	return g
+}
+
+func (d *HexDecoder) Vec(ss []string) Vec {
+	res := make(Vec, len(ss))
+	for i, s := range ss {
+		res[i] = d.Zr(s)
+	}
+	return res
+}
+
+func (d *HexDecoder) G1v(ss []string) G1v {
+	res := make(G1v, len(ss))
+	for i, s := range ss {
+		res[i] = d.G1(s)
+	}
+	return res
+}
+
+// Check records err, if it is the first error encountered.
+func (d *HexDecoder) Check(err error) {
+	if err != nil && d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *HexDecoder) Err() error {
+	return d.err
+}
+
+func (d *HexDecoder) decode(s string) []byte {
+	if d.err != nil {
+		return nil
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		d.fail("invalid hexadecimal string %q: %v", s, err)
+		return nil
+	}
+	if hex.EncodeToString(b) != s {
+		d.fail("hexadecimal string %q is not in lowercase", s)
+		return nil
+	}
+	return b
+}
+
+func (d *HexDecoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf(format, args...)
+	}
+}
+
+// JSON strictly decodes raw into v, rejecting unknown fields and missing values.
+func (d *HexDecoder) JSON(raw json.RawMessage, v interface{}) {
+	if d.err != nil {
+		return
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		d.fail("missing %T", v)
+		return
+	}
+	d.Check(UnmarshalJSONStrict(raw, v))
+}
+
+// UnmarshalJSONStrict decodes b into v and fails if b contains fields that v does not have.
+func UnmarshalJSONStrict(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("trailing data after JSON value")
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"math/big"
 	"math/bits"
+	"sync"
 
 	math "github.com/IBM/mathlib"
 	common2 "github.com/IBM/mathlib/driver/common"
@@ -39,6 +40,19 @@ func IntToZr(n int) *math.Zr {
 	return c.NewZrFromInt(int64(n))
 }
 
+// BigToZr returns the given non-negative integer, which must be less than the group order, as a scalar.
+func BigToZr(n *big.Int) *math.Zr {
+	if n.Sign() < 0 {
+		panic(fmt.Sprintf("%s is negative", n))
+	}
+	return c.NewZrFromBytes(n.FillBytes(make([]byte, ZrSize)))
+}
+
+// ZrToBig returns the scalar, reduced modulo the group order, as an integer.
+func ZrToBig(x *math.Zr) *big.Int {
+	return new(big.Int).SetBytes(reduce(x).Bytes())
+}
+
 func IntsToZr(ns []uint8) Vec {
 	res := make(Vec, len(ns))
 	for i := 0; i < len(ns); i++ {
@@ -86,18 +100,53 @@ func Bits(n *math.Zr, bitLen int) []uint8 {
 	return res
 }
 
+// generators caches the generators derived by RandGenVec, so that every generator is hashed to the curve only once
+var generators = struct {
+	sync.Mutex
+	derived map[string]G1v
+	bases   map[string]*FixedBase
+}{
+	derived: make(map[string]G1v),
+	bases:   make(map[string]*FixedBase),
+}
+
 func RandGenVec(n int, context string) []*math.G1 {
-	v := make([]*math.G1, n)
+	generators.Lock()
+	defer generators.Unlock()
 
-	for i := 0; i < n; i++ {
+	derived := generators.derived[context]
+	for i := len(derived); i < n; i++ {
 		randBytes := SHA256Digest(fmt.Sprintf("PoL %s %d", context, i))
 		randBytes = append(randBytes, SHA256Digest(string(randBytes))...)
-		v[i] = HashToG1(randBytes)
+		derived = append(derived, HashToG1(randBytes))
+	}
+	generators.derived[context] = derived
+
+	// The caller gets its own copies, so it cannot tamper with the cached generators
+	v := make([]*math.G1, n)
+	for i := 0; i < n; i++ {
+		v[i] = derived[i].Copy()
 	}
 
 	return v
 }
 
+// Generator returns the first generator RandGenVec derives for the given context,
+// along with its precomputed table which is shared by all callers.
+func Generator(context string) *FixedBase {
+	g := RandGenVec(1, context)[0]
+
+	generators.Lock()
+	defer generators.Unlock()
+
+	if fb, exists := generators.bases[context]; exists {
+		return fb
+	}
+	fb := NewFixedBase(g)
+	generators.bases[context] = fb
+	return fb
+}
+
 func RandVec(n int) Vec {
 	r, err := c.Rand()
 	if err != nil {
@@ -127,6 +176,16 @@ func (v Vec) Size() int {
 	return c
 }
 
+// HasNil returns whether any of the elements is missing
+func (v Vec) HasNil() bool {
+	for _, x := range v {
+		if x == nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (v Vec) Concat(v2 Vec) Vec {
 	res := make(Vec, len(v)+len(v2))
 	copy(res, v)
@@ -245,11 +304,7 @@ func (v Vec) Exp(g []*math.G1) *math.G1 {
 	if len(v) != len(g) {
 		panic(fmt.Sprintf("scalar vector is of length %d but group vector is of length %d", len(v), len(g)))
 	}
-	result := g[0].Mul(v[0])
-	for i := 1; i < len(g); i++ {
-		result.Add(g[i].Mul(v[i]))
-	}
-	return result
+	return MultiExp(g, v)
 }
 
 func (v Vec) InnerProd(v2 Vec) *math.Zr {
@@ -350,6 +405,16 @@ func (g1v G1v) Sum() *math.G1 {
 	return sum
 }
 
+// HasNil returns whether any of the elements is missing
+func (g1v G1v) HasNil() bool {
+	for _, g := range g1v {
+		if g == nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (g1v G1v) Bytes() []byte {
 	bb := bytes.Buffer{}
 	for _, g := range g1v {
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"fmt"
+
+	math "github.com/IBM/mathlib"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+// MultiExp returns Σ scalars[i]·points[i].
+// Instead of computing each scalar multiplication on its own and adding the results,
+// it uses the native bucket method (Pippenger) multi-scalar multiplication of BN254.
+func MultiExp(points G1v, scalars Vec) *math.G1 {
+	if len(points) != len(scalars) {
+		panic(fmt.Sprintf("|G vector|=%d but |scalar vector|=%d", len(points), len(scalars)))
+	}
+
+	affinePoints := make([]bn254.G1Affine, len(points))
+	for i := range points {
+		toAffine(&affinePoints[i], points[i])
+	}
+
+	return multiExp(affinePoints, scalars)
+}
+
+func multiExp(points []bn254.G1Affine, scalars Vec) *math.G1 {
+	if len(points) == 0 {
+		return zeroG1.Copy()
+	}
+
+	frScalars := make([]fr.Element, len(scalars))
+	for i := range scalars {
+		// Scalars are not necessarily reduced, and may not even fit in 32 bytes
+		scalar := scalars[i].Copy()
+		scalar.Mod(GroupOrder)
+		frScalars[i].SetBytes(scalar.Bytes())
+	}
+
+	var result bn254.G1Affine
+	// The scalars are in Montgomery form, as SetBytes puts them
+	if _, err := result.MultiExp(points, frScalars, ecc.MultiExpConfig{ScalarsMont: true}); err != nil {
+		panic(err)
+	}
+
+	return fromAffine(&result)
+}
+
+func toAffine(dst *bn254.G1Affine, g *math.G1) {
+	if _, err := dst.SetBytes(g.Bytes()); err != nil {
+		panic(fmt.Sprintf("point is not in G1: %v", err))
+	}
+}
+
+func fromAffine(p *bn254.G1Affine) *math.G1 {
+	raw := p.RawBytes()
+	g, err := c.NewG1FromBytes(raw[:])
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
@@ -0,0 +1,68 @@
+package common
+
+import (
+	"math/rand"
+	"testing"
+
+	math "github.com/IBM/mathlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiExp(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	generators := RandGenVec(16, "multi exponentiation test")
+	minusOne := NegZr(IntToZr(1))
+
+	// Scalars and points that are edge cases for the bucket method are picked often
+	randomScalar := func() *math.Zr {
+		switch r.Intn(6) {
+		case 0:
+			return IntToZr(0)
+		case 1:
+			return minusOne
+		case 2:
+			return IntToZr(r.Intn(1 << 16))
+		case 3:
+			// Not reduced modulo the group order
+			return minusOne.Plus(RandVec(1)[0])
+		case 4:
+			// Does not even fit in 32 bytes
+			return c.NewZrFromBytes(append([]byte{1}, RandVec(1)[0].Bytes()...))
+		default:
+			return RandVec(1)[0]
+		}
+	}
+
+	randomPoint := func() *math.G1 {
+		switch r.Intn(4) {
+		case 0:
+			return zeroG1
+		default:
+			// Points repeat, so they fall into the same buckets
+			return generators[r.Intn(len(generators))]
+		}
+	}
+
+	for _, n := range []int{0, 1, 2, 3, 7, 16, 33, 100, 257} {
+		for trial := 0; trial < 5; trial++ {
+			points := make(G1v, n)
+			scalars := make(Vec, n)
+			for i := 0; i < n; i++ {
+				points[i] = randomPoint()
+				scalars[i] = randomScalar()
+			}
+
+			expected := zeroG1.Copy()
+			if n > 0 {
+				expected = points.MulV(scalars).Sum()
+			}
+
+			assert.True(t, expected.Equals(MultiExp(points, scalars)), "%d points, trial %d", n, trial)
+		}
+	}
+
+	assert.Panics(t, func() {
+		MultiExp(generators, RandVec(1))
+	})
+}
@@ -0,0 +1,24 @@
+// Package kv defines the key-value stores that liability sets persist their vertices to,
+// along with adapters of an in-memory map and of LevelDB.
+package kv
+
+// DB is a key-value store. Keys that are not in the DB have nil values.
+type DB interface {
+	// Get returns the value of the key, or nil if the key is not in the DB.
+	Get(key []byte) ([]byte, error)
+	Put(key []byte, val []byte) error
+	Delete(key []byte) error
+	// NewBatch returns a batch of writes that are applied to the DB atomically when the batch is committed.
+	NewBatch() Batch
+	// Iterate passes every key that starts with the given prefix and its value to f, in ascending order of the keys,
+	// and stops at the first error returned by f. The DB must not be written to by f.
+	Iterate(prefix []byte, f func(key, val []byte) error) error
+}
+
+// Batch is a sequence of writes to a DB, which are applied in order when the batch is committed.
+// Either all of the writes are applied or none of them is, and a batch cannot be committed twice.
+type Batch interface {
+	Put(key []byte, val []byte)
+	Delete(key []byte)
+	Commit() error
+}
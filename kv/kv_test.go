@@ -0,0 +1,89 @@
+package kv
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemDB(t *testing.T) {
+	testDB(t, make(MemDB))
+}
+
+func TestLevelDB(t *testing.T) {
+	db, err := OpenLevelDB(t.TempDir())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	testDB(t, db)
+}
+
+func testDB(t *testing.T, db DB) {
+	val, err := db.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+
+	assert.NoError(t, db.Put([]byte("a"), []byte{1}))
+	assert.NoError(t, db.Put([]byte("b.1"), []byte{2}))
+	assert.NoError(t, db.Put([]byte("b.0"), []byte{3}))
+	assert.NoError(t, db.Put([]byte("c"), []byte{4}))
+
+	val, err = db.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1}, val)
+
+	assert.NoError(t, db.Delete([]byte("a")))
+	assert.NoError(t, db.Delete([]byte("not there")))
+	val, err = db.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+
+	// Writes of a batch are not applied before it is committed, and are applied in order
+	batch := db.NewBatch()
+	batch.Put([]byte("b.2"), []byte{5})
+	batch.Put([]byte("b.1"), []byte{6})
+	batch.Delete([]byte("c"))
+	batch.Put([]byte("d"), []byte{7})
+	batch.Delete([]byte("d"))
+
+	val, err = db.Get([]byte("b.2"))
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+
+	assert.NoError(t, batch.Commit())
+
+	for key, expected := range map[string][]byte{"b.0": {3}, "b.1": {6}, "b.2": {5}, "c": nil, "d": nil} {
+		val, err = db.Get([]byte(key))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, val, key)
+	}
+
+	var keys []string
+	var vals [][]byte
+	err = db.Iterate([]byte("b."), func(key, val []byte) error {
+		keys = append(keys, string(key))
+		vals = append(vals, val)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b.0", "b.1", "b.2"}, keys)
+	assert.Equal(t, [][]byte{{3}, {6}, {5}}, vals)
+
+	keys = nil
+	assert.NoError(t, db.Iterate(nil, func(key, _ []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	}))
+	assert.Equal(t, []string{"b.0", "b.1", "b.2"}, keys)
+
+	// Iteration stops at the first error
+	stop := errors.New("stop")
+	keys = nil
+	err = db.Iterate([]byte("b"), func(key, _ []byte) error {
+		keys = append(keys, string(key))
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, []string{"b.0"}, keys)
+}
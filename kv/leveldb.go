@@ -0,0 +1,79 @@
+package kv
+
+import (
+	"errors"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// LevelDB is a DB persisted to a LevelDB database in a directory.
+type LevelDB struct {
+	db *leveldb.DB
+}
+
+// OpenLevelDB opens the LevelDB database in the given directory, and creates it if it does not exist.
+func OpenLevelDB(path string) (*LevelDB, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelDB{db: db}, nil
+}
+
+func (l *LevelDB) Get(key []byte) ([]byte, error) {
+	val, err := l.db.Get(key, nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, nil
+	}
+	return val, err
+}
+
+func (l *LevelDB) Put(key []byte, val []byte) error {
+	return l.db.Put(key, val, nil)
+}
+
+func (l *LevelDB) Delete(key []byte) error {
+	return l.db.Delete(key, nil)
+}
+
+func (l *LevelDB) NewBatch() Batch {
+	return &levelBatch{db: l.db, batch: new(leveldb.Batch)}
+}
+
+func (l *LevelDB) Iterate(prefix []byte, f func(key, val []byte) error) error {
+	it := l.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer it.Release()
+
+	for it.Next() {
+		// The iterator reuses the buffers of the keys and values it returns
+		key := append([]byte(nil), it.Key()...)
+		val := append([]byte(nil), it.Value()...)
+		if err := f(key, val); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+// Close closes the database, after which it can no longer be used.
+func (l *LevelDB) Close() error {
+	return l.db.Close()
+}
+
+type levelBatch struct {
+	db    *leveldb.DB
+	batch *leveldb.Batch
+}
+
+func (b *levelBatch) Put(key []byte, val []byte) {
+	b.batch.Put(key, val)
+}
+
+func (b *levelBatch) Delete(key []byte) {
+	b.batch.Delete(key)
+}
+
+func (b *levelBatch) Commit() error {
+	return b.db.Write(b.batch, nil)
+}
@@ -0,0 +1,75 @@
+package kv
+
+import (
+	"bytes"
+	"sort"
+)
+
+// MemDB is a DB that holds its keys in a map. It is not safe for concurrent use.
+type MemDB map[string][]byte
+
+func (m MemDB) Get(key []byte) ([]byte, error) {
+	return m[string(key)], nil
+}
+
+func (m MemDB) Put(key []byte, val []byte) error {
+	m[string(key)] = val
+	return nil
+}
+
+func (m MemDB) Delete(key []byte) error {
+	delete(m, string(key))
+	return nil
+}
+
+func (m MemDB) NewBatch() Batch {
+	return &memBatch{db: m}
+}
+
+func (m MemDB) Iterate(prefix []byte, f func(key, val []byte) error) error {
+	var keys []string
+	for key := range m {
+		if bytes.HasPrefix([]byte(key), prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := f([]byte(key), m[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write is a write of a batch, which deletes the key if the value is nil
+type write struct {
+	key, val []byte
+}
+
+type memBatch struct {
+	db     MemDB
+	writes []write
+}
+
+func (b *memBatch) Put(key []byte, val []byte) {
+	b.writes = append(b.writes, write{key: key, val: val})
+}
+
+func (b *memBatch) Delete(key []byte) {
+	b.writes = append(b.writes, write{key: key})
+}
+
+// Commit applies the writes of the batch. Writes to a map cannot fail, so they are always applied.
+func (b *memBatch) Commit() error {
+	for _, w := range b.writes {
+		if w.val == nil {
+			delete(b.db, string(w.key))
+			continue
+		}
+		b.db[string(w.key)] = w.val
+	}
+	b.writes = nil
+	return nil
+}
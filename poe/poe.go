@@ -5,10 +5,12 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"pol/bp"
 	"pol/common"
 	"pol/pp"
+	"sync"
 
 	math "github.com/IBM/mathlib"
 )
@@ -23,6 +25,32 @@ type PP struct {
 	G, H   common.G1v
 	F      *math.G1
 	PP     *pp.PP
+	tables *tables
+}
+
+// tables are the precomputed tables of the generators G, H and F
+type tables struct {
+	G, H *common.FixedBaseVec
+	F    *common.FixedBase
+}
+
+// tablesLock guards the lazily built tables of all public parameters
+var tablesLock sync.Mutex
+
+// precomputed returns the tables of the generators, which are built once and shared by the prover and the verifier.
+// If the generators are replaced, the tables are rebuilt.
+func (pp *PP) precomputed() *tables {
+	tablesLock.Lock()
+	defer tablesLock.Unlock()
+
+	if t := pp.tables; t == nil || !t.G.Matches(pp.G) || !t.H.Matches(pp.H) || !t.F.Matches(pp.F) {
+		pp.tables = &tables{
+			G: common.NewFixedBaseVec(pp.G),
+			H: common.NewFixedBaseVec(pp.H),
+			F: common.NewFixedBase(pp.F),
+		}
+	}
+	return pp.tables
 }
 
 func (pp *PP) Size() int {
@@ -30,8 +58,14 @@ func (pp *PP) Size() int {
 }
 
 func NewPublicParams(n, m int) *PP {
+	return NewPublicParamsFromPP(pp.NewPublicParams(n), m)
+}
+
+// NewPublicParamsFromPP creates public parameters on top of existing PointProofs public parameters,
+// such as the ones produced by a trusted setup ceremony.
+func NewPublicParamsFromPP(pointProofsPP *pp.PP, m int) *PP {
 	pp := &PP{
-		PP: pp.NewPublicParams(n),
+		PP: pointProofsPP,
 		G:  common.RandGenVec(m, "POE G"),
 		H:  common.RandGenVec(m, "POE H"),
 		F:  common.RandGenVec(1, "POE F")[0],
@@ -50,6 +84,36 @@ func (pp *PP) SetupDigest() {
 	pp.Digest = h.Sum(nil)
 }
 
+func (pp *PP) MarshalBinary() ([]byte, error) {
+	e := common.NewEncoder()
+	e.G1v(pp.G)
+	e.G1v(pp.H)
+	e.G1(pp.F)
+	e.Marshaler(pp.PP)
+	return e.Result()
+}
+
+func (poePP *PP) UnmarshalBinary(b []byte) error {
+	d := common.NewDecoder(b)
+	loaded := &PP{
+		G:  d.G1v(),
+		H:  d.G1v(),
+		F:  d.G1(),
+		PP: &pp.PP{},
+	}
+	d.Unmarshaler(loaded.PP)
+	if err := d.Finish(); err != nil {
+		return err
+	}
+	if len(loaded.G) == 0 || len(loaded.G) != len(loaded.H) {
+		return fmt.Errorf("expected G and H of the same non-zero size but got %d and %d", len(loaded.G), len(loaded.H))
+	}
+
+	loaded.SetupDigest()
+	*poePP = *loaded
+	return nil
+}
+
 type Equalities struct {
 	RO   func(gs common.G1v, integers []int, ppDigest []byte, n int) common.Vec
 	PP   *PP
@@ -68,7 +132,85 @@ func (ap *AggregatedProof) Size() int {
 	return ap.IPP.Size() + len(ap.c.Bytes()) + len(ap.ρ.Bytes()) + len(ap.U.Bytes()) + len(ap.V.Bytes()) + len(ap.Ω.Bytes()) + len(ap.Waggr.Bytes()) + len(ap.Vaggr.Bytes())
 }
 
+func (ap *AggregatedProof) MarshalBinary() ([]byte, error) {
+	e := common.NewEncoder()
+	e.Marshaler(ap.IPP)
+	e.Zr(ap.c)
+	e.Zr(ap.ρ)
+	e.G1(ap.U)
+	e.G1(ap.V)
+	e.G1(ap.Ω)
+	e.G1v(ap.Waggr)
+	e.G1v(ap.Vaggr)
+	return e.Result()
+}
+
+func (ap *AggregatedProof) UnmarshalBinary(b []byte) error {
+	d := common.NewDecoder(b)
+	ap.IPP = &bp.InnerProductProof{}
+	d.Unmarshaler(ap.IPP)
+	ap.c = d.Zr()
+	ap.ρ = d.Zr()
+	ap.U = d.G1()
+	ap.V = d.G1()
+	ap.Ω = d.G1()
+	ap.Waggr = d.G1v()
+	ap.Vaggr = d.G1v()
+	return d.Finish()
+}
+
+type aggregatedProofJSON struct {
+	InnerProductProof json.RawMessage `json:"innerProductProof"`
+	C                 string          `json:"c"`
+	Rho               string          `json:"rho"`
+	U                 string          `json:"u"`
+	V                 string          `json:"v"`
+	Omega             string          `json:"omega"`
+	Waggr             []string        `json:"waggr"`
+	Vaggr             []string        `json:"vaggr"`
+}
+
+func (ap *AggregatedProof) MarshalJSON() ([]byte, error) {
+	var e common.HexEncoder
+	raw := aggregatedProofJSON{
+		C:     e.Zr(ap.c),
+		Rho:   e.Zr(ap.ρ),
+		U:     e.G1(ap.U),
+		V:     e.G1(ap.V),
+		Omega: e.G1(ap.Ω),
+		Waggr: e.G1v(ap.Waggr),
+		Vaggr: e.G1v(ap.Vaggr),
+	}
+	raw.InnerProductProof = e.JSON(ap.IPP)
+	if err := e.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+func (ap *AggregatedProof) UnmarshalJSON(b []byte) error {
+	var raw aggregatedProofJSON
+	if err := common.UnmarshalJSONStrict(b, &raw); err != nil {
+		return err
+	}
+	var d common.HexDecoder
+	ap.IPP = &bp.InnerProductProof{}
+	d.JSON(raw.InnerProductProof, ap.IPP)
+	ap.c = d.Zr(raw.C)
+	ap.ρ = d.Zr(raw.Rho)
+	ap.U = d.G1(raw.U)
+	ap.V = d.G1(raw.V)
+	ap.Ω = d.G1(raw.Omega)
+	ap.Waggr = d.G1v(raw.Waggr)
+	ap.Vaggr = d.G1v(raw.Vaggr)
+	return d.Err()
+}
+
 func (e *Equalities) Verify(proof *AggregatedProof) error {
+	if err := e.checkProofStructure(proof); err != nil {
+		return err
+	}
+
 	var groupElements common.G1v
 	groupElements = append(groupElements, proof.Vaggr...)
 	groupElements = append(groupElements, proof.Waggr...)
@@ -99,17 +241,18 @@ func (e *Equalities) Verify(proof *AggregatedProof) error {
 	numerator.Mul(e.W.Add(proof.Waggr.Mul(x)).MulV(ts.Odds()).InnerProd(g2sW))
 
 	denominator := common.G1v{proof.Ω}.InnerProd(common.G2v{c.GenG2.Copy()})
-	denominator.Mul(common.G1v{e.PP.PP.G1s[0].Mul(proof.c)}.InnerProd(common.G2v{e.PP.PP.G2s[len(e.PP.PP.G2s)-1]}))
+	denominator.Mul(common.G1v{e.PP.PP.Tables().Mul(0, proof.c)}.InnerProd(common.G2v{e.PP.PP.G2s[len(e.PP.PP.G2s)-1]}))
 
 	if !numerator.Equals(denominator) {
 		return fmt.Errorf("PoE invalid: aggregation condition not satisfied")
 	}
 
 	b := ts.Evens().Add(ts.Odds())
-	P := e.PP.F.Mul(proof.ρ)
+	generators := e.PP.precomputed()
+	P := generators.F.Mul(proof.ρ)
 	P.Add(proof.U.Mul(x))
 	P.Add(proof.V)
-	P.Add(e.PP.H.MulV(b).Sum())
+	P.Add(generators.H.MultiExp(0, b))
 
 	bpPP := &bp.PP{
 		U: common.RandGenVec(1, "u")[0],
@@ -126,12 +269,52 @@ func (e *Equalities) Verify(proof *AggregatedProof) error {
 
 }
 
+// checkProofStructure returns an error if the proof is not of the size of the equalities,
+// or if the equalities refer to indices outside of the vector commitments.
+func (e *Equalities) checkProofStructure(proof *AggregatedProof) error {
+	m := len(e.V)
+	if len(e.W) != m || len(e.I) != m || len(e.J) != m {
+		return fmt.Errorf("%w: V is of size %d but W, I and J are of sizes %d, %d and %d", common.ErrMalformedProof, m, len(e.W), len(e.I), len(e.J))
+	}
+	if m != len(e.PP.G) {
+		return fmt.Errorf("%w: expected %d equalities but got %d", common.ErrMalformedProof, len(e.PP.G), m)
+	}
+	if proof == nil || proof.IPP == nil || proof.c == nil || proof.ρ == nil || proof.U == nil || proof.V == nil || proof.Ω == nil {
+		return fmt.Errorf("%w: equality proof is incomplete", common.ErrMalformedProof)
+	}
+	if len(proof.Vaggr) != m || len(proof.Waggr) != m {
+		return fmt.Errorf("%w: expected %d aggregated commitments but got %d and %d", common.ErrMalformedProof, m, len(proof.Vaggr), len(proof.Waggr))
+	}
+	if e.V.HasNil() || e.W.HasNil() || proof.Vaggr.HasNil() || proof.Waggr.HasNil() {
+		return fmt.Errorf("%w: missing commitments", common.ErrMalformedProof)
+	}
+	n := e.PP.PP.N
+	for k := 0; k < m; k++ {
+		if e.I[k] < 0 || e.I[k] >= n || e.J[k] < 0 || e.J[k] >= n {
+			return fmt.Errorf("%w: indices %d and %d are not in [0,%d]", common.ErrMalformedProof, e.I[k], e.J[k], n-1)
+		}
+	}
+	return nil
+}
+
 // Prove proves equality of 'v[i]' and 'w[j]' for all indices in I,J.
 // The last elements in every 'v' and 'w' should be blinding factors.
-func (e *Equalities) Prove(vs, ws []common.Vec) *AggregatedProof {
+func (e *Equalities) Prove(vs, ws []common.Vec) (*AggregatedProof, error) {
+	return e.ProveWithOpenings(vs, ws, func(k int) (*math.G1, *math.G1) {
+		_, ΩVppk := pp.Open(e.PP.PP, e.I[k], vs[k])
+		_, ΩWppk := pp.Open(e.PP.PP, e.J[k], ws[k])
+		return ΩVppk, ΩWppk
+	})
+}
+
+// ProveWithOpenings is like Prove, but obtains the PointProofs openings of 'vs[k]' in index I[k] and of 'ws[k]' in index J[k]
+// from the given function, so that openings of vectors that appear in several proofs can be computed once.
+func (e *Equalities) ProveWithOpenings(vs, ws []common.Vec, open func(k int) (*math.G1, *math.G1)) (*AggregatedProof, error) {
 	m := len(e.V)
 	// Sanity checks for lengths
-	e.validateInputLength(vs, ws, m)
+	if err := e.validateInputLength(vs, ws, m); err != nil {
+		return nil, err
+	}
 
 	n := len(vs[0])
 
@@ -145,26 +328,27 @@ func (e *Equalities) Prove(vs, ws []common.Vec) *AggregatedProof {
 
 	u := make(common.Vec, m)
 
+	g1s := e.PP.PP.Tables()
+
 	for k := 0; k < m; k++ {
 		uk, ηk, νk := c.NewRandomZr(rand.Reader), c.NewRandomZr(rand.Reader), c.NewRandomZr(rand.Reader)
 
 		u[k] = uk
 
-		Vk := e.PP.PP.G1s[e.I[k]].Mul(uk)
-		Vk.Add(e.PP.PP.G1s[n-1].Mul(νk))
+		Vk := g1s.Mul(e.I[k], uk)
+		Vk.Add(g1s.Mul(n-1, νk))
 		Vaggr[k] = Vk
 
-		ΩVk := e.PP.PP.G1s[len(e.PP.PP.G1s)-1-e.I[k]].Mul(νk)
+		ΩVk := g1s.Mul(len(e.PP.PP.G1s)-1-e.I[k], νk)
 		Ωv[k] = ΩVk
 
-		Wk := e.PP.PP.G1s[e.J[k]].Mul(uk)
-		Wk.Add(e.PP.PP.G1s[n-1].Mul(ηk))
+		Wk := g1s.Mul(e.J[k], uk)
+		Wk.Add(g1s.Mul(n-1, ηk))
 		Waggr[k] = Wk
-		ΩWk := e.PP.PP.G1s[len(e.PP.PP.G1s)-1-e.J[k]].Mul(ηk)
+		ΩWk := g1s.Mul(len(e.PP.PP.G1s)-1-e.J[k], ηk)
 		Ωw[k] = ΩWk
 
-		_, ΩVppk := pp.Open(e.PP.PP, e.I[k], vs[k])
-		_, ΩWppk := pp.Open(e.PP.PP, e.J[k], ws[k])
+		ΩVppk, ΩWppk := open(k)
 
 		ΩvPP[k] = ΩVppk
 		ΩwPP[k] = ΩWppk
@@ -185,19 +369,20 @@ func (e *Equalities) Prove(vs, ws []common.Vec) *AggregatedProof {
 		v[i] = vs[i][e.I[i]]
 	}
 
-	U := e.PP.F.Mul(r1)
-	U.Add(e.PP.G.MulV(u).Sum())
+	generators := e.PP.precomputed()
+	U := generators.F.Mul(r1)
+	U.Add(generators.G.MultiExp(0, u))
 
-	V := e.PP.F.Mul(r2)
-	V.Add(e.PP.G.MulV(v).Sum())
+	V := generators.F.Mul(r2)
+	V.Add(generators.G.MultiExp(0, v))
 
 	groupElements = append(groupElements, U)
 	groupElements = append(groupElements, V)
 
 	ts := e.RO(groupElements, nil, e.PP.Digest, 2*m)
 
-	Ω := ΩvPP.Add(Ωv.Mul(x)).MulV(ts.Evens()).Sum()
-	Ω.Add(ΩwPP.Add(Ωw.Mul(x)).MulV(ts.Odds()).Sum())
+	Ω := common.MultiExp(ΩvPP.Add(Ωv.Mul(x)), ts.Evens())
+	Ω.Add(common.MultiExp(ΩwPP.Add(Ωw.Mul(x)), ts.Odds()))
 
 	a := u.Mul(x).Add(v)
 	b := ts.Evens().Add(ts.Odds())
@@ -205,10 +390,10 @@ func (e *Equalities) Prove(vs, ws []common.Vec) *AggregatedProof {
 	ρ := r1.Mul(x).Plus(r2)
 	ρ = negZr(ρ)
 
-	P := e.PP.F.Mul(ρ)
+	P := generators.F.Mul(ρ)
 	P.Add(U.Mul(x))
 	P.Add(V)
-	P.Add(e.PP.H.MulV(b).Sum())
+	P.Add(generators.H.MultiExp(0, b))
 
 	bpPP := &bp.PP{
 		U: common.RandGenVec(1, "u")[0],
@@ -231,20 +416,32 @@ func (e *Equalities) Prove(vs, ws []common.Vec) *AggregatedProof {
 		Vaggr: Vaggr,
 		Waggr: Waggr,
 		c:     ipp.C,
-	}
+	}, nil
 
 }
 
-func (e *Equalities) validateInputLength(v []common.Vec, w []common.Vec, m int) {
-	if len(e.W) != m {
-		panic(fmt.Sprintf("V is of size %d but W is of size %d", m, len(e.W)))
+func (e *Equalities) validateInputLength(v []common.Vec, w []common.Vec, m int) error {
+	if m != len(e.PP.G) {
+		return fmt.Errorf("expected %d equalities but got %d", len(e.PP.G), m)
+	}
+	if len(e.W) != m || len(e.I) != m || len(e.J) != m {
+		return fmt.Errorf("V is of size %d but W, I and J are of sizes %d, %d and %d", m, len(e.W), len(e.I), len(e.J))
 	}
 	if len(v) != m {
-		panic(fmt.Sprintf("V is of size %d but v is of size %d", m, len(v)))
+		return fmt.Errorf("V is of size %d but v is of size %d", m, len(v))
 	}
 	if len(w) != m {
-		panic(fmt.Sprintf("V is of size %d but w is of size %d", m, len(w)))
+		return fmt.Errorf("V is of size %d but w is of size %d", m, len(w))
 	}
+	for k := 0; k < m; k++ {
+		if len(v[k]) != e.PP.PP.N || len(w[k]) != e.PP.PP.N {
+			return fmt.Errorf("vectors %d are of sizes %d and %d but should be of size %d", k, len(v[k]), len(w[k]), e.PP.PP.N)
+		}
+		if e.I[k] < 0 || e.I[k] >= e.PP.PP.N || e.J[k] < 0 || e.J[k] >= e.PP.PP.N {
+			return fmt.Errorf("indices %d and %d are not in [0,%d]", e.I[k], e.J[k], e.PP.PP.N-1)
+		}
+	}
+	return nil
 }
 
 type Equality struct {
@@ -306,6 +503,17 @@ type Proof struct {
 }
 
 func (e *Equality) Verify(Υ *Proof) error {
+	if Υ == nil || Υ.C == nil || Υ.V == nil || Υ.W == nil || Υ.Ω == nil {
+		return fmt.Errorf("%w: equality proof is incomplete", common.ErrMalformedProof)
+	}
+	if e.V == nil || e.W == nil {
+		return fmt.Errorf("%w: missing commitments", common.ErrMalformedProof)
+	}
+	n := len(e.PP.PP.G2s)
+	if e.I < 0 || e.I >= n || e.J < 0 || e.J >= n {
+		return fmt.Errorf("%w: indices %d and %d are not in [0,%d]", common.ErrMalformedProof, e.I, e.J, n-1)
+	}
+
 	x := e.RO(common.G1v{e.V, e.W, Υ.V, Υ.W}, nil, e.PP.Digest, 1)[0]
 	g := c.GenG1.Copy()
 
@@ -342,27 +550,33 @@ func (e *Equality) Verify(Υ *Proof) error {
 
 // Prove proves equality of 'v[i]' and 'w[j]'.
 // The last elements in 'v' and 'w' should be blinding factors.
-func (e *Equality) Prove(v, w common.Vec) *Proof {
-	// Sanity test, in case we're trying to prove something that is incorrect
-	if !v[e.I].Equals(w[e.J]) {
-		panic(fmt.Sprintf("v[%d] != w[%d]", e.I, e.J))
+func (e *Equality) Prove(v, w common.Vec) (*Proof, error) {
+	if len(v) != len(w) {
+		return nil, fmt.Errorf("|v| != |w|")
 	}
 
-	if len(v) != len(w) {
-		panic("|v| != |w|")
+	if e.I < 0 || e.I >= len(v) || e.J < 0 || e.J >= len(w) {
+		return nil, fmt.Errorf("indices %d and %d are not in [0,%d]", e.I, e.J, len(v)-1)
+	}
+
+	// Sanity test, in case we're trying to prove something that is incorrect
+	if !v[e.I].Equals(w[e.J]) {
+		return nil, fmt.Errorf("v[%d] != w[%d]", e.I, e.J)
 	}
 
 	n := len(v)
 
 	u, η, ν := c.NewRandomZr(rand.Reader), c.NewRandomZr(rand.Reader), c.NewRandomZr(rand.Reader)
 
-	V := e.PP.PP.G1s[e.I].Mul(u)
-	V.Add(e.PP.PP.G1s[n-1].Mul(ν))
-	ΩV := e.PP.PP.G1s[len(e.PP.PP.G1s)-1-e.I].Mul(ν)
+	g1s := e.PP.PP.Tables()
 
-	W := e.PP.PP.G1s[e.J].Mul(u)
-	W.Add(e.PP.PP.G1s[n-1].Mul(η))
-	ΩW := e.PP.PP.G1s[len(e.PP.PP.G1s)-1-e.J].Mul(η)
+	V := g1s.Mul(e.I, u)
+	V.Add(g1s.Mul(n-1, ν))
+	ΩV := g1s.Mul(len(e.PP.PP.G1s)-1-e.I, ν)
+
+	W := g1s.Mul(e.J, u)
+	W.Add(g1s.Mul(n-1, η))
+	ΩW := g1s.Mul(len(e.PP.PP.G1s)-1-e.J, η)
 
 	x := e.RO(common.G1v{e.V, e.W, V, W}, nil, e.PP.Digest, 1)[0]
 
@@ -393,6 +607,6 @@ func (e *Equality) Prove(v, w common.Vec) *Proof {
 		V: V,
 		W: W,
 		C: c,
-	}
+	}, nil
 
 }
@@ -3,6 +3,8 @@ package poe
 import (
 	"crypto/rand"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"pol/common"
 	"pol/pp"
 	"testing"
@@ -57,9 +59,13 @@ func TestProofOfEqualities(t *testing.T) {
 		J:  J,
 	}
 
-	proof := eq.Prove(vs, ws)
-	err := eq.Verify(proof)
+	proof, err := eq.Prove(vs, ws)
 	assert.NoError(t, err)
+	err = eq.Verify(proof)
+	assert.NoError(t, err)
+
+	_, err = eq.Prove(vs[1:], ws)
+	assert.Error(t, err)
 }
 
 func TestProofOfEquality(t *testing.T) {
@@ -88,8 +94,9 @@ func TestProofOfEquality(t *testing.T) {
 			J:  j,
 		}
 
-		proof := eq.Prove(v, w)
-		err := eq.Verify(proof)
+		proof, err := eq.Prove(v, w)
+		assert.NoError(t, err)
+		err = eq.Verify(proof)
 		assert.NoErrorf(t, err, "i: %d, j: %d\n", i, j)
 	}
 }
@@ -101,3 +108,83 @@ func randIndex(t *testing.T, n int) int {
 
 	return int(binary.BigEndian.Uint16(buff) % uint16(n))
 }
+
+func TestAggregatedProofEncoding(t *testing.T) {
+	n := 8
+	m := 2
+
+	publicParams := NewPublicParams(n, m)
+
+	eq := &Equalities{
+		RO: RO,
+		PP: publicParams,
+		W:  make(common.G1v, m),
+		V:  make(common.G1v, m),
+		I:  []int{1, 2},
+		J:  []int{3, 4},
+	}
+
+	vs := make([]common.Vec, m)
+	ws := make([]common.Vec, m)
+	for k := 0; k < m; k++ {
+		vs[k] = common.RandVec(n)
+		ws[k] = common.RandVec(n)
+		vs[k][eq.I[k]] = ws[k][eq.J[k]]
+		eq.V[k] = pp.Commit(publicParams.PP, vs[k])
+		eq.W[k] = pp.Commit(publicParams.PP, ws[k])
+	}
+
+	proof, err := eq.Prove(vs, ws)
+	assert.NoError(t, err)
+
+	raw, err := proof.MarshalBinary()
+	assert.NoError(t, err)
+
+	decoded := &AggregatedProof{}
+	assert.NoError(t, decoded.UnmarshalBinary(raw))
+	assert.NoError(t, eq.Verify(decoded))
+
+	raw2, err := decoded.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, raw, raw2)
+
+	assert.Error(t, decoded.UnmarshalBinary(append(raw, 0)))
+
+	rawJSON, err := json.Marshal(proof)
+	assert.NoError(t, err)
+
+	decoded = &AggregatedProof{}
+	assert.NoError(t, json.Unmarshal(rawJSON, decoded))
+	assert.NoError(t, eq.Verify(decoded))
+
+	rawJSON2, err := json.Marshal(decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, rawJSON, rawJSON2)
+
+	// Proofs and equalities of the wrong structure are rejected without verifying them
+	truncated := *decoded
+	truncated.Vaggr = truncated.Vaggr[:1]
+	assert.True(t, errors.Is(eq.Verify(&truncated), common.ErrMalformedProof))
+	assert.True(t, errors.Is(eq.Verify(&AggregatedProof{}), common.ErrMalformedProof))
+
+	eq.I = []int{1, n}
+	assert.True(t, errors.Is(eq.Verify(decoded), common.ErrMalformedProof))
+}
+
+func TestPublicParamsEncoding(t *testing.T) {
+	publicParams := NewPublicParams(4, 2)
+
+	raw, err := publicParams.MarshalBinary()
+	assert.NoError(t, err)
+
+	var decoded PP
+	assert.NoError(t, decoded.UnmarshalBinary(raw))
+	assert.Equal(t, publicParams.Digest, decoded.Digest)
+	assert.Equal(t, publicParams.PP.Digest, decoded.PP.Digest)
+
+	raw2, err := decoded.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, raw, raw2)
+
+	assert.Error(t, decoded.UnmarshalBinary(append(raw, 0)))
+}
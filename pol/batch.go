@@ -0,0 +1,209 @@
+package pol
+
+import (
+	"math/big"
+	"runtime"
+	"sync"
+
+	"pol/bp"
+	"pol/common"
+	"pol/pp"
+	"pol/verkle"
+
+	math "github.com/IBM/mathlib"
+)
+
+const (
+	valueOpening = iota
+	digestOpening
+	rangeProof
+)
+
+type cacheKey struct {
+	V     string
+	index int
+	kind  int
+}
+
+type cacheEntry struct {
+	once  sync.Once
+	value *math.Zr
+	π     *math.G1
+	rp    *bp.RangeProof
+	err   error
+}
+
+// proofCache computes the range proofs and openings of vertices once,
+// and shares them among all proofs of paths that go through the same vertices.
+type proofCache struct {
+	ls      *LiabilitySet
+	lock    sync.Mutex
+	entries map[cacheKey]*cacheEntry
+}
+
+func newProofCache(ls *LiabilitySet) *proofCache {
+	return &proofCache{
+		ls:      ls,
+		entries: make(map[cacheKey]*cacheEntry),
+	}
+}
+
+func (c *proofCache) entry(v *verkle.Vertex, index int, kind int) *cacheEntry {
+	key := cacheKey{V: string(v.V.Bytes()), index: index, kind: kind}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, exists := c.entries[key]
+	if !exists {
+		e = &cacheEntry{}
+		c.entries[key] = e
+	}
+	return e
+}
+
+// openValue opens the vector of the vertex, including its blinding factor, in the given index.
+func (c *proofCache) openValue(v *verkle.Vertex, index int) (*math.Zr, *math.G1) {
+	e := c.entry(v, index, valueOpening)
+	e.once.Do(func() {
+		e.value, e.π = c.ls.openForClient(v, index)
+	})
+	return e.value, e.π
+}
+
+// openDigest opens the digests the vertex commits to in the given index.
+func (c *proofCache) openDigest(v *verkle.Vertex, index int) (*math.Zr, *math.G1) {
+	e := c.entry(v, index, digestOpening)
+	e.once.Do(func() {
+		e.value, e.π = pp.Open(c.ls.pp.PPPP, index, c.ls.digestVector(v))
+	})
+	return e.value, e.π
+}
+
+func (c *proofCache) rangeProof(v *verkle.Vertex) (*bp.RangeProof, error) {
+	e := c.entry(v, 0, rangeProof)
+	e.once.Do(func() {
+		e.rp, e.err = bp.ProveRange(c.ls.pp.RPPP, v.V, v.Values(c.ls.pp.PPPP.N-1), v.BlindingFactor)
+	})
+	return e.rp, e.err
+}
+
+type liabilityJob struct {
+	id       string
+	path     []uint16
+	vertices verkle.Vertices
+	err      error
+}
+
+// ProveLiabilities proves the liabilities of all given ids, and passes every proof to the callback once it is ready,
+// or the error of the id, such as ErrNotFound if it is not in the liability set. The callback is never invoked concurrently,
+// and proofs are not necessarily passed to it in the order of the ids.
+//
+// The proofs are computed by the given number of workers, or by one worker per CPU if it is not positive.
+// Range proofs and openings of vertices shared by the paths of several ids, such as the root and its children,
+// are computed only once, and are retained until all proofs are done. To bound the memory this takes,
+// a large number of ids can be proven in several calls, each of ids that are close to each other in the tree.
+//
+// All the proofs of a call are of the same root, as the vertices along the paths of all the ids are loaded before any proof is computed.
+func (ls *LiabilitySet) ProveLiabilities(ids []string, workers int, callback func(id string, liability *big.Int, proof LiabilityProof, err error)) {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	if !ParallelismEnabled {
+		workers = 1
+	}
+
+	cache := newProofCache(ls)
+
+	var callbackLock sync.Mutex
+	report := func(id string, liability *big.Int, proof LiabilityProof, err error) {
+		callbackLock.Lock()
+		defer callbackLock.Unlock()
+		callback(id, liability, proof, err)
+	}
+
+	// The tree is traversed by a single goroutine, and the workers only do the cryptography
+	pending := ls.liabilityJobs(ids)
+
+	jobs := make(chan liabilityJob, workers)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				balances, proof, err := ls.proveWithCache(job.path, job.vertices, cache)
+				if err != nil {
+					report(job.id, nil, LiabilityProof{}, err)
+					continue
+				}
+				report(job.id, balances[0], proof, nil)
+			}
+		}()
+	}
+
+	for _, job := range pending {
+		if job.err != nil {
+			report(job.id, nil, LiabilityProof{}, job.err)
+			continue
+		}
+		jobs <- job
+	}
+
+	close(jobs)
+	wg.Wait()
+}
+
+// liabilityJobs loads the vertices along the paths of the given ids, with the lock held for reading
+func (ls *LiabilitySet) liabilityJobs(ids []string) []liabilityJob {
+	ls.lock.RLock()
+	defer ls.lock.RUnlock()
+
+	jobs := make([]liabilityJob, len(ids))
+	for i, id := range ids {
+		jobs[i].id = id
+		jobs[i].path, jobs[i].err = ls.tree.Tree.ID2Path(id)
+		if jobs[i].err != nil {
+			continue
+		}
+		_, jobs[i].vertices, jobs[i].err = ls.tree.Get(id)
+	}
+	return jobs
+}
+
+// proveWithCache is like ProveBalances, but computes the range proofs in the calling goroutine,
+// and takes them and the openings from the cache.
+func (ls *LiabilitySet) proveWithCache(path []uint16, vertices verkle.Vertices, cache *proofCache) ([]*big.Int, LiabilityProof, error) {
+	proof, digestProofs, balances := ls.provePath(path, vertices, cache)
+
+	proof.PointProofΣ, proof.PointProofπ = ls.aggregateDigestProofs(proof, digestProofs)
+	proof.SumArgumentProof = vertices.SumArgument(ls.pp.SAPP)
+	// The last entry in the path points is the liabilities and not to other layers in the tree
+	var err error
+	proof.EqualityProof, err = ls.proveEqualities(path[:len(path)-1], vertices[:len(path)-1], vertices[1:], cache)
+	if err != nil {
+		return nil, LiabilityProof{}, err
+	}
+
+	proof.RangeProofs = make([]*bp.RangeProof, len(vertices))
+	for i, v := range vertices {
+		proof.RangeProofs[i], err = cache.rangeProof(v)
+		if err != nil {
+			return nil, LiabilityProof{}, err
+		}
+	}
+
+	return balances, proof, nil
+}
+
+// aggregateDigestProofs aggregates the openings of the digests along the path into a single PointProof.
+func (ls *LiabilitySet) aggregateDigestProofs(proof LiabilityProof, digestProofs common.G1v) (*math.Zr, *math.G1) {
+	tPP := make(common.Vec, len(proof.W))
+	for i := range proof.W {
+		tPP[i] = pp.RO(ls.pp.PPPP, proof.W, i)
+	}
+
+	return proof.Digests[:len(tPP)].InnerProd(tPP), pp.Aggregate(ls.pp.PPPP, proof.W, digestProofs, pp.RO)
+}
@@ -0,0 +1,46 @@
+package pol
+
+import (
+	"errors"
+	"math/big"
+	"pol/kv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProveLiabilities(t *testing.T) {
+	fanout := uint16(7)
+	id2Path, pp := generatePublicParams(t, fanout, Dense)
+
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+
+	// The first two ids share all vertices along their paths, and the third shares only the root with them
+	expected := map[string]int64{"282475250": 100, "564950499": 200, "987654321": 300}
+	for id, liability := range expected {
+		assert.NoError(t, ls.Set(id, big.NewInt(liability)))
+	}
+
+	V, W := ls.Root()
+
+	proven := make(map[string]LiabilityProof)
+	ls.ProveLiabilities([]string{"282475250", "123456789", "564950499", "987654321", "not an id"}, 2, func(id string, liability *big.Int, proof LiabilityProof, err error) {
+		if _, exists := expected[id]; !exists {
+			assert.True(t, errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidID), id)
+			return
+		}
+		assert.NoError(t, err, id)
+		assert.Equal(t, expected[id], liability.Int64(), id)
+		proven[id] = proof
+	})
+
+	assert.Len(t, proven, len(expected))
+	for id, proof := range proven {
+		_, err := proof.Verify(pp, id, V, W, id2Path)
+		assert.NoError(t, err, id)
+	}
+
+	// Range proofs of shared vertices are computed once
+	assert.Equal(t, proven["282475250"].RangeProofs, proven["564950499"].RangeProofs)
+	assert.Same(t, proven["282475250"].RangeProofs[0], proven["987654321"].RangeProofs[0])
+}
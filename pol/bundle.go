@@ -0,0 +1,325 @@
+package pol
+
+import (
+	"errors"
+	"fmt"
+	"pol/bp"
+	"pol/common"
+	"pol/poe"
+	"pol/pp"
+	"pol/sum"
+	"pol/verkle"
+
+	math "github.com/IBM/mathlib"
+)
+
+// EpochProof is the part of a LiabilityProof that is specific to a single epoch of a LiabilityBundle.
+type EpochProof struct {
+	V              common.G1v
+	W              common.G1v
+	Digests        common.Vec
+	LiabilityProof TotalProof
+	AssetProofs    []TotalProof
+	// PointProofπ aggregates the openings of the digests of the epoch
+	PointProofπ *math.G1
+}
+
+func (ep EpochProof) liabilityProof() LiabilityProof {
+	return LiabilityProof{
+		V:              ep.V,
+		W:              ep.W,
+		Digests:        ep.Digests,
+		LiabilityProof: ep.LiabilityProof,
+		AssetProofs:    ep.AssetProofs,
+	}
+}
+
+func (ep EpochProof) MarshalBinary() ([]byte, error) {
+	e := common.NewEncoder()
+	e.G1v(ep.V)
+	e.G1v(ep.W)
+	e.Vec(ep.Digests)
+	e.Marshaler(ep.LiabilityProof)
+	e.Uint32(uint32(len(ep.AssetProofs)))
+	for _, ap := range ep.AssetProofs {
+		e.Marshaler(ap)
+	}
+	e.G1(ep.PointProofπ)
+	return e.Result()
+}
+
+func (ep *EpochProof) UnmarshalBinary(b []byte) error {
+	d := common.NewDecoder(b)
+	ep.V = d.G1v()
+	ep.W = d.G1v()
+	ep.Digests = d.Vec()
+	d.Unmarshaler(&ep.LiabilityProof)
+	ep.AssetProofs = make([]TotalProof, d.Len(4))
+	for i := range ep.AssetProofs {
+		d.Unmarshaler(&ep.AssetProofs[i])
+	}
+	if len(ep.AssetProofs) == 0 {
+		ep.AssetProofs = nil
+	}
+	ep.PointProofπ = d.G1()
+	return d.Finish()
+}
+
+// LiabilityBundle proves the balances of a client in the liability sets of several epochs.
+// Vertices that did not change between epochs appear in the paths of several epochs,
+// but their range proofs, sum argument and equalities are proven only once.
+// The openings of the digests of every epoch are aggregated into a PointProof of the epoch,
+// as the PointProofs of all epochs together could exceed the number of commitments that can be aggregated.
+type LiabilityBundle struct {
+	Epochs []EpochProof
+	// RangeProofs are the range proofs of the distinct vertices, in the order of their first appearance
+	RangeProofs      []*bp.RangeProof
+	SumArgumentProof *sum.Proof
+	EqualityProof    *poe.AggregatedProof
+}
+
+func (b LiabilityBundle) Size() int {
+	size := b.SumArgumentProof.Size() + b.EqualityProof.Size()
+	for _, ep := range b.Epochs {
+		size += len(ep.V.Bytes()) + len(ep.W.Bytes()) + ep.Digests.Size() + len(ep.PointProofπ.Bytes())
+	}
+	for _, rp := range b.RangeProofs {
+		size += rp.Size()
+	}
+	return size
+}
+
+func (b LiabilityBundle) MarshalBinary() ([]byte, error) {
+	e := common.NewEncoder()
+	e.Uint32(uint32(len(b.Epochs)))
+	for _, ep := range b.Epochs {
+		e.Marshaler(ep)
+	}
+	e.Uint32(uint32(len(b.RangeProofs)))
+	for _, rp := range b.RangeProofs {
+		e.Marshaler(rp)
+	}
+	e.Marshaler(b.SumArgumentProof)
+	e.Marshaler(b.EqualityProof)
+	return e.Result()
+}
+
+func (b *LiabilityBundle) UnmarshalBinary(raw []byte) error {
+	d := common.NewDecoder(raw)
+	// Every epoch proof and range proof is prefixed by its length, so it takes up at least 4 bytes
+	b.Epochs = make([]EpochProof, d.Len(4))
+	for i := range b.Epochs {
+		d.Unmarshaler(&b.Epochs[i])
+	}
+	b.RangeProofs = make([]*bp.RangeProof, d.Len(4))
+	for i := range b.RangeProofs {
+		b.RangeProofs[i] = &bp.RangeProof{}
+		d.Unmarshaler(b.RangeProofs[i])
+	}
+	b.SumArgumentProof = &sum.Proof{}
+	d.Unmarshaler(b.SumArgumentProof)
+	b.EqualityProof = &poe.AggregatedProof{}
+	d.Unmarshaler(b.EqualityProof)
+	return d.Finish()
+}
+
+// distinctVertices de-duplicates the vertices along the paths of several epochs.
+type distinctVertices struct {
+	seen map[string]struct{}
+	// V are the distinct vertex commitments in the order of their first appearance
+	V common.G1v
+	// indices, parents and children are the distinct links between a vertex and its child along the paths
+	links    map[string]struct{}
+	indices  []uint16
+	parents  common.G1v
+	children common.G1v
+}
+
+func newDistinctVertices() *distinctVertices {
+	return &distinctVertices{
+		seen:  make(map[string]struct{}),
+		links: make(map[string]struct{}),
+	}
+}
+
+// add adds the vertices along a path, and returns the positions of the newly seen vertices and links in the path.
+func (dv *distinctVertices) add(path []uint16, V common.G1v) ([]int, []int) {
+	var newVertices, newLinks []int
+	for i := range V {
+		key := string(V[i].Bytes())
+		if _, exists := dv.seen[key]; !exists {
+			dv.seen[key] = struct{}{}
+			dv.V = append(dv.V, V[i])
+			newVertices = append(newVertices, i)
+		}
+
+		if i == len(V)-1 {
+			continue
+		}
+
+		link := fmt.Sprintf("%s%s%d", key, V[i+1].Bytes(), path[i])
+		if _, exists := dv.links[link]; !exists {
+			dv.links[link] = struct{}{}
+			dv.indices = append(dv.indices, path[i])
+			dv.parents = append(dv.parents, V[i])
+			dv.children = append(dv.children, V[i+1])
+			newLinks = append(newLinks, i)
+		}
+	}
+	return newVertices, newLinks
+}
+
+// ProveEpochs proves the balances of the given id in the snapshots of the given epochs.
+func (ls *LiabilitySet) ProveEpochs(id string, epochs []uint64) (LiabilityBundle, error) {
+	if len(epochs) == 0 {
+		return LiabilityBundle{}, fmt.Errorf("no epochs to prove")
+	}
+
+	var bundle LiabilityBundle
+	var vertices, parents, children verkle.Vertices
+	var indices []uint16
+
+	path, err := ls.tree.Tree.ID2Path(id)
+	if err != nil {
+		return LiabilityBundle{}, err
+	}
+	views, alongThePaths, err := ls.epochPaths(id, epochs)
+	if err != nil {
+		return LiabilityBundle{}, err
+	}
+
+	dv := newDistinctVertices()
+	cache := newProofCache(ls)
+
+	for e, view := range views {
+		verticesAlongThePath := alongThePaths[e]
+
+		proof, epochDigestProofs, _ := view.provePath(path, verticesAlongThePath, cache)
+		bundle.Epochs = append(bundle.Epochs, EpochProof{
+			V:              proof.V,
+			W:              proof.W,
+			Digests:        proof.Digests,
+			LiabilityProof: proof.LiabilityProof,
+			AssetProofs:    proof.AssetProofs,
+			PointProofπ:    pp.Aggregate(ls.pp.PPPP, proof.W, epochDigestProofs, pp.RO),
+		})
+
+		newVertices, newLinks := dv.add(path, proof.V)
+		for _, i := range newVertices {
+			vertices = append(vertices, verticesAlongThePath[i])
+		}
+		for _, i := range newLinks {
+			indices = append(indices, path[i])
+			parents = append(parents, verticesAlongThePath[i])
+			children = append(children, verticesAlongThePath[i+1])
+		}
+	}
+
+	waitForRangeProofs := ls.proveRanges(vertices, cache)
+
+	bundle.SumArgumentProof = vertices.SumArgument(ls.pp.SAPP)
+	equalityProof, err := ls.proveEqualities(indices, parents, children, cache)
+	rangeProofs, rangeProofErr := waitForRangeProofs()
+	if err != nil {
+		return LiabilityBundle{}, err
+	}
+	if rangeProofErr != nil {
+		return LiabilityBundle{}, rangeProofErr
+	}
+	bundle.RangeProofs = rangeProofs
+	bundle.EqualityProof = equalityProof
+
+	return bundle, nil
+}
+
+// epochPaths loads the views of the given epochs and the vertices along the path of the given id in every view,
+// with the lock held for reading.
+func (ls *LiabilitySet) epochPaths(id string, epochs []uint64) ([]*LiabilitySet, []verkle.Vertices, error) {
+	if ls.snapshots == nil {
+		return nil, nil, fmt.Errorf("a snapshot does not have snapshots")
+	}
+
+	ls.lock.RLock()
+	defer ls.lock.RUnlock()
+
+	views := make([]*LiabilitySet, len(epochs))
+	alongThePaths := make([]verkle.Vertices, len(epochs))
+	for i, epoch := range epochs {
+		view, err := ls.atEpoch(epoch)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		_, verticesAlongThePath, err := view.tree.Get(id)
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil, fmt.Errorf("%s %w in the liability set of epoch %d", id, ErrNotFound, epoch)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		views[i], alongThePaths[i] = view, verticesAlongThePath
+	}
+	return views, alongThePaths, nil
+}
+
+// Verify verifies the balances of the given id in the liability sets of the given epoch roots,
+// which are expected to be in the order of the epochs of the bundle.
+func (b LiabilityBundle) Verify(publicParams *PublicParams, id string, roots []EpochRoot, id2path func(string) ([]uint16, error)) error {
+	if len(b.Epochs) != len(roots) {
+		return malformed("expected proofs of %d epochs but got %d", len(roots), len(b.Epochs))
+	}
+
+	path, err := id2path(id)
+	if err != nil {
+		return err
+	}
+	dv := newDistinctVertices()
+
+	for i, ep := range b.Epochs {
+		lp := ep.liabilityProof()
+		if err := lp.checkPath(publicParams, path, roots[i].V, roots[i].W); err != nil {
+			return fmt.Errorf("epoch %d: %w", roots[i].Epoch, err)
+		}
+		if err := lp.verifyBalances(publicParams, path); err != nil {
+			return fmt.Errorf("epoch %d: %w", roots[i].Epoch, err)
+		}
+
+		dv.add(path, ep.V)
+	}
+
+	if len(b.RangeProofs) != len(dv.V) {
+		return malformed("expected %d range proofs but got %d", len(dv.V), len(b.RangeProofs))
+	}
+	if b.SumArgumentProof == nil || b.EqualityProof == nil {
+		return malformed("missing sum argument or equality proof")
+	}
+
+	waitForRangeProofs := verifyRangeProofs(publicParams, b.RangeProofs, dv.V)
+
+	indices := uint16VecToIntVec(path)[:len(path)-1]
+	for i, ep := range b.Epochs {
+		if ep.PointProofπ == nil {
+			return malformed("epoch %d: missing aggregation proof", roots[i].Epoch)
+		}
+		t := make(common.Vec, len(ep.W))
+		for j := range ep.W {
+			t[j] = pp.RO(publicParams.PPPP, ep.W, j)
+		}
+		if err := pp.VerifyAggregation(publicParams.PPPP, indices, ep.W, ep.PointProofπ, ep.Digests[:len(path)-1].InnerProd(t), pp.RO); err != nil {
+			return fmt.Errorf("epoch %d: %w", roots[i].Epoch, verificationFailed(err, "hash chain aggregation proof invalid"))
+		}
+	}
+
+	if err := b.SumArgumentProof.VerifyAggregated(publicParams.SAPP, dv.V); err != nil {
+		return verificationFailed(err, "failed verifying sum argument")
+	}
+
+	if err := verifyEqualities(publicParams, b.EqualityProof, dv.indices, dv.parents, dv.children); err != nil {
+		return err
+	}
+
+	if err := waitForRangeProofs(); err != nil {
+		return verificationFailed(err, "failed verifying range proofs")
+	}
+	return nil
+}
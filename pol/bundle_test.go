@@ -0,0 +1,98 @@
+package pol
+
+import (
+	"errors"
+	"math/big"
+	"pol/kv"
+	"pol/pp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProveEpochs(t *testing.T) {
+	fanout := uint16(7)
+	id2Path, pp := generatePublicParams(t, fanout, Dense)
+
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+
+	assert.NoError(t, ls.Set("282475250", big.NewInt(100)))
+	assert.NoError(t, ls.Set("987654321", big.NewInt(300)))
+	assert.NoError(t, ls.Snapshot(1))
+
+	// Only the root is on both paths, so the rest of the path of 282475250 is shared between the epochs
+	assert.NoError(t, ls.Set("987654321", big.NewInt(350)))
+	assert.NoError(t, ls.Snapshot(2))
+
+	assert.NoError(t, ls.Set("282475250", big.NewInt(150)))
+
+	_, err := ls.ProveEpochs("123456789", []uint64{1, 2})
+	assert.EqualError(t, err, "123456789 not found in the liability set of epoch 1")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	_, err = ls.ProveEpochs("282475250", []uint64{1, 3})
+	assert.EqualError(t, err, "snapshot of epoch 3 not found")
+
+	bundle, err := ls.ProveEpochs("282475250", []uint64{1, 2})
+	assert.NoError(t, err)
+
+	path := pathOf(t, id2Path, "282475250")
+	assert.Len(t, bundle.Epochs, 2)
+	assert.Len(t, bundle.RangeProofs, len(path)+1)
+	for _, ep := range bundle.Epochs {
+		assert.Equal(t, int64(100), ep.LiabilityProof.Sum.Int64())
+	}
+
+	roots := ls.RootHistory()
+	assert.NoError(t, bundle.Verify(pp, "282475250", roots, id2Path))
+
+	raw, err := bundle.MarshalBinary()
+	assert.NoError(t, err)
+	var decoded LiabilityBundle
+	assert.NoError(t, decoded.UnmarshalBinary(raw))
+	assert.NoError(t, decoded.Verify(pp, "282475250", roots, id2Path))
+
+	// The roots must be of the proven epochs and in their order
+	assert.EqualError(t, bundle.Verify(pp, "282475250", roots[:1], id2Path), "malformed proof: expected proofs of 1 epochs but got 2")
+	err = bundle.Verify(pp, "282475250", []EpochRoot{roots[1], roots[0]}, id2Path)
+	assert.EqualError(t, err, "epoch 2: invalid proof: root V does not match public known V value")
+	assert.True(t, errors.Is(err, ErrInvalidProof))
+	assert.Error(t, bundle.Verify(pp, "987654321", roots, id2Path))
+
+	// A balance that was not included in the epoch is rejected
+	bundle.Epochs[1].LiabilityProof.Sum = big.NewInt(150)
+	assert.Error(t, bundle.Verify(pp, "282475250", roots, id2Path))
+}
+
+func TestProveManyEpochs(t *testing.T) {
+	fanout := uint16(3)
+	id2Path, publicParams := generatePublicParams(t, fanout, Dense)
+
+	ls := NewLiabilitySet(publicParams, make(kv.MemDB), id2Path)
+	assert.NoError(t, ls.Set("282475250", big.NewInt(100)))
+
+	// The digests of all epochs together are more than a single PointProof can aggregate
+	path := pathOf(t, id2Path, "282475250")
+	epochs := make([]uint64, pp.MaxAggregated/(len(path)-1)+1)
+	for i := range epochs {
+		epochs[i] = uint64(i + 1)
+		assert.NoError(t, ls.Set("987654321", big.NewInt(int64(i))))
+		assert.NoError(t, ls.Snapshot(epochs[i]))
+	}
+
+	bundle, err := ls.ProveEpochs("282475250", epochs)
+	assert.NoError(t, err)
+	var W int
+	for _, ep := range bundle.Epochs {
+		W += len(ep.W)
+	}
+	assert.Greater(t, W, pp.MaxAggregated)
+
+	roots := ls.RootHistory()
+	assert.NoError(t, bundle.Verify(publicParams, "282475250", roots, id2Path))
+
+	// The aggregation proof of every epoch is verified on its own
+	bundle.Epochs[0].PointProofπ, bundle.Epochs[1].PointProofπ = bundle.Epochs[1].PointProofπ, bundle.Epochs[0].PointProofπ
+	err = bundle.Verify(publicParams, "282475250", roots, id2Path)
+	assert.True(t, errors.Is(err, ErrInvalidProof))
+}
@@ -0,0 +1,42 @@
+package pol
+
+import (
+	"errors"
+	"fmt"
+	"pol/common"
+)
+
+// The errors returned by liability sets and by the verification of their proofs, to be told apart with errors.Is.
+var (
+	// ErrInvalidID is returned for an id that cannot be mapped to a path in the tree of the liability set
+	ErrInvalidID = common.ErrInvalidID
+	// ErrNotFound is returned for an id that is not in the liability set
+	ErrNotFound = common.ErrNotFound
+	// ErrInvalidLiability is returned for a liability that is negative, or does not fit in the range proofs
+	ErrInvalidLiability = common.ErrInvalidLiability
+	// ErrUnsupportedFanout is returned for a fanout that the tree type does not support
+	ErrUnsupportedFanout = common.ErrUnsupportedFanout
+	// ErrMalformedProof is returned for a proof that is not structured like the proofs of the public parameters
+	ErrMalformedProof = common.ErrMalformedProof
+	// ErrInvalidProof is returned for a well-formed proof that does not verify
+	ErrInvalidProof = common.ErrInvalidProof
+)
+
+// malformed returns an error of a proof that is not structured like the proofs of the public parameters.
+func malformed(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrMalformedProof, fmt.Sprintf(format, args...))
+}
+
+// invalid returns an error of a proof that does not verify.
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidProof, fmt.Sprintf(format, args...))
+}
+
+// verificationFailed describes the failure of verifying a part of a proof.
+// If the part is malformed then so is the proof, and otherwise the proof is invalid.
+func verificationFailed(err error, what string) error {
+	if errors.Is(err, ErrMalformedProof) {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	return fmt.Errorf("%w: %s: %v", ErrInvalidProof, what, err)
+}
@@ -0,0 +1,144 @@
+package pol
+
+import (
+	"fmt"
+	"math/big"
+	"pol/verkle"
+	"pol/wal"
+	"sync"
+)
+
+// Journal applies updates to a liability set after appending them to a write-ahead log,
+// which makes the log an audit trail of how the liability set reached its root.
+//
+// The blinding factors of the vertices that an update creates are not drawn at random, but derived from a blinding source.
+// Replaying the log with the same source therefore rebuilds a byte-identical tree.
+//
+// A journal is safe for concurrent use, as long as the liability set is only updated through it.
+type Journal struct {
+	log *wal.Log
+	ls  *LiabilitySet
+	// lock makes every update be applied in the order it is appended to the log
+	lock sync.Mutex
+}
+
+// NewJournal returns a journal of a liability set that holds exactly the updates in the log,
+// such as a new liability set with an empty log, or a liability set that the log was replayed into.
+// The blinding factors of the liability set are derived from the given source from now on.
+func NewJournal(log *wal.Log, ls *LiabilitySet, source verkle.BlindingSource) *Journal {
+	ls.SetBlindingSource(source)
+	return &Journal{
+		log: log,
+		ls:  ls,
+	}
+}
+
+// Set is like LiabilitySet.Set, but appends the update to the log before applying it.
+func (j *Journal) Set(id string, liability *big.Int) error {
+	return j.SetBalances(id, []*big.Int{liability})
+}
+
+// SetBalances is like LiabilitySet.SetBalances, but appends the update to the log before applying it.
+// Updates that the liability set would reject are rejected without being appended.
+func (j *Journal) SetBalances(id string, balances []*big.Int) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if err := j.ls.checkWritable(); err != nil {
+		return err
+	}
+	if _, err := j.ls.tree.Tree.ID2Path(id); err != nil {
+		return err
+	}
+	if err := j.ls.checkBalances(id, balances); err != nil {
+		return err
+	}
+
+	j.ls.lock.RLock()
+	err := j.ls.checkTotals(map[string][]*big.Int{id: balances})
+	j.ls.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return j.apply(wal.Op{Kind: wal.Set, ID: id, Balances: balances})
+}
+
+// Delete is like LiabilitySet.Delete, but appends the update to the log before applying it.
+func (j *Journal) Delete(id string) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if err := j.ls.checkWritable(); err != nil {
+		return err
+	}
+	if _, err := j.ls.Get(id); err != nil {
+		return err
+	}
+
+	return j.apply(wal.Op{Kind: wal.Delete, ID: id})
+}
+
+// Snapshot is like LiabilitySet.Snapshot, but appends the snapshot to the log before taking it.
+// Updates after the snapshot are of its epoch as far as their blinding factors are concerned.
+func (j *Journal) Snapshot(epoch uint64) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.ls.lock.RLock()
+	err := j.ls.checkSnapshot(epoch)
+	j.ls.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return j.apply(wal.Op{Kind: wal.Snapshot, Epoch: epoch})
+}
+
+// apply appends the update to the log and then applies it. If the update cannot be applied once it is in the log,
+// the liability set no longer holds exactly the updates in the log, and should be rebuilt with Replay.
+func (j *Journal) apply(op wal.Op) error {
+	if _, err := j.log.Append(op); err != nil {
+		return fmt.Errorf("failed appending update to log: %w", err)
+	}
+
+	return applyOp(j.ls, op)
+}
+
+// Replay applies all the updates in the log to an empty liability set, deriving the blinding factors from the given source,
+// and returns a journal to append further updates with. If the retention policy of the liability set that the log was written with
+// is not the default one, the same policy must be set on the given liability set before it is replayed into.
+func Replay(log *wal.Log, ls *LiabilitySet, source verkle.BlindingSource) (*Journal, error) {
+	if err := ls.checkWritable(); err != nil {
+		return nil, err
+	}
+	if V, _ := ls.Root(); V != nil || len(ls.Epochs()) > 0 {
+		return nil, fmt.Errorf("a log can only be replayed into an empty liability set")
+	}
+
+	ls.SetBlindingSource(source)
+	err := log.Ops(func(op wal.Op) error {
+		if err := applyOp(ls, op); err != nil {
+			return fmt.Errorf("failed replaying update %d: %w", op.Seq, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewJournal(log, ls, source), nil
+}
+
+func applyOp(ls *LiabilitySet, op wal.Op) error {
+	switch op.Kind {
+	case wal.Set:
+		return ls.SetBalances(op.ID, op.Balances)
+	case wal.Delete:
+		return ls.Delete(op.ID)
+	case wal.Snapshot:
+		return ls.Snapshot(op.Epoch)
+	default:
+		return fmt.Errorf("unknown kind of update %d", op.Kind)
+	}
+}
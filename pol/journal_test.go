@@ -0,0 +1,118 @@
+package pol
+
+import (
+	"errors"
+	"math/big"
+	"path/filepath"
+	"pol/kv"
+	"pol/verkle"
+	"pol/wal"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalReplay(t *testing.T) {
+	fanout := uint16(7)
+	id2Path, pp := generatePublicParams(t, fanout, Dense)
+	path := filepath.Join(t.TempDir(), "wal")
+	source := verkle.NewMasterKeys([]byte("master key"))
+
+	log, err := wal.Open(path)
+	assert.NoError(t, err)
+
+	db := make(kv.MemDB)
+	ls := NewLiabilitySet(pp, db, id2Path)
+	j := NewJournal(log, ls, source)
+
+	assert.NoError(t, j.Set("282475250", big.NewInt(100)))
+	assert.NoError(t, j.Set("564950499", big.NewInt(200)))
+	assert.NoError(t, j.Snapshot(1))
+	assert.NoError(t, j.Set("282475250", big.NewInt(150)))
+	assert.NoError(t, j.Delete("564950499"))
+	// The vertices deleted with 564950499 are created again in the same epoch, by a later update
+	assert.NoError(t, j.Set("564950499", big.NewInt(250)))
+	assert.NoError(t, j.Set("987654321", big.NewInt(300)))
+	assert.NoError(t, j.Snapshot(3))
+	assert.NoError(t, j.Set("123456789", big.NewInt(400)))
+
+	// Updates that are rejected are not logged
+	assert.True(t, errors.Is(j.Set("12345678", big.NewInt(1)), ErrInvalidID))
+	assert.True(t, errors.Is(j.Set("123456789", big.NewInt(-1)), ErrInvalidLiability))
+	assert.True(t, errors.Is(j.Delete("111111111"), ErrNotFound))
+	assert.Error(t, j.Snapshot(2))
+	assert.Equal(t, uint64(9), log.Seq())
+	assert.NoError(t, log.Close())
+
+	replay := func(source verkle.BlindingSource) (kv.MemDB, *LiabilitySet) {
+		log, err := wal.Open(path)
+		assert.NoError(t, err)
+		defer log.Close()
+
+		db := make(kv.MemDB)
+		ls := NewLiabilitySet(pp, db, id2Path)
+		_, err = Replay(log, ls, source)
+		assert.NoError(t, err)
+		return db, ls
+	}
+
+	// Replaying the log with the same source rebuilds the same DB, byte for byte
+	replayedDB, replayed := replay(source)
+	assert.Equal(t, db, replayedDB)
+	V, W := ls.Root()
+	replayedV, replayedW := replayed.Root()
+	assert.True(t, V.Equals(replayedV))
+	assert.True(t, W.Equals(replayedW))
+	assert.Equal(t, ls.RootHistory(), replayed.RootHistory())
+
+	// With another master key, the liabilities are the same but the commitments are not
+	otherDB, other := replay(verkle.NewMasterKeys([]byte("another master key")))
+	assert.Equal(t, len(db), len(otherDB))
+	otherV, _ := other.Root()
+	assert.False(t, V.Equals(otherV))
+	liability, err := other.Get("564950499")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(250), liability.Int64())
+
+	// A log can only be replayed into an empty liability set
+	log, err = wal.Open(path)
+	assert.NoError(t, err)
+	defer log.Close()
+	_, err = Replay(log, ls, source)
+	assert.EqualError(t, err, "a log can only be replayed into an empty liability set")
+}
+
+func TestJournalRejectsOverflowingTotals(t *testing.T) {
+	fanout := uint16(7)
+	id2Path, pp, err := GenerateMultiAssetPublicParamsWithBits(fanout, Dense, 1, 15)
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "wal")
+	source := verkle.NewMasterKeys([]byte("master key"))
+
+	log, err := wal.Open(path)
+	assert.NoError(t, err)
+
+	j := NewJournal(log, NewLiabilitySet(pp, make(kv.MemDB), id2Path), source)
+
+	max := big.NewInt(1<<15 - 1)
+	assert.NoError(t, j.Set("282475250", max))
+
+	// Both liabilities fit, but their sum does not, so the second one is not logged
+	assert.True(t, errors.Is(j.Set("987654321", max), ErrInvalidLiability))
+	assert.Equal(t, uint64(1), log.Seq())
+	assert.NoError(t, log.Close())
+
+	log, err = wal.Open(path)
+	assert.NoError(t, err)
+	defer log.Close()
+
+	replayed := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+	_, err = Replay(log, replayed, source)
+	assert.NoError(t, err)
+
+	liability, err := replayed.Get("282475250")
+	assert.NoError(t, err)
+	assert.Equal(t, max.Int64(), liability.Int64())
+	_, err = replayed.Get("987654321")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
@@ -1,8 +1,14 @@
 package pol
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	gomath "math"
+	"math/big"
 	"pol/bp"
 	"pol/common"
 	"pol/poe"
@@ -11,7 +17,6 @@ import (
 	"pol/sum"
 	"pol/verkle"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	math "github.com/IBM/mathlib"
@@ -23,25 +28,36 @@ const (
 	// Sparse tree type allows referencing liabilities by hexadecimal strings of 64 characters
 	Sparse TreeType = false
 	// Dense tree type allows referencing liabilities by nine decimal digits
-	Dense = true
+	Dense TreeType = true
 )
 
 type Parallelism bool
 
 var ParallelismEnabled = true
 
+// LiabilitySet is safe for concurrent use. Updates are applied one at a time, and every read and proof
+// sees the liability set either before or after every update. Proofs only hold up updates while they load
+// the vertices along their paths, and not while they compute the cryptography.
 type LiabilitySet struct {
-	DB   verkle.DB
-	tree *verkle.Tree
-	pp   *PublicParams
+	DB        verkle.DB
+	tree      *verkle.Tree
+	pp        *PublicParams
+	snapshots *snapshotDB
+	retention RetentionPolicy
+	// lock is shared by the liability set and the views of its snapshots,
+	// which read what the updates of the liability set write.
+	lock *sync.RWMutex
 }
 
 type PublicParams struct {
-	PPPP   *pp.PP
-	SAPP   *sum.PP
-	RPPP   *bp.RangeProofPublicParams
-	POEPP  *poe.PP
-	Fanout int
+	PPPP     *pp.PP
+	SAPP     *sum.PP
+	RPPP     *bp.RangeProofPublicParams
+	POEPP    *poe.PP
+	Fanout   int
+	TreeType TreeType
+	// Assets is the number of assets every liability is made of
+	Assets int
 }
 
 func (pp *PublicParams) Size() int {
@@ -50,54 +66,327 @@ func (pp *PublicParams) Size() int {
 
 // NewLiabilitySet creates a liability set with the given fanout and tree type.
 // Only a fan-out of the form 2^k - 1 for some natural k is permitted.
-func NewLiabilitySet(pp *PublicParams, db verkle.DB, id2Path func(string) []uint16) *LiabilitySet {
-	tree := verkle.NewVerkleTree(uint16(pp.Fanout), id2Path, db)
+func NewLiabilitySet(pp *PublicParams, db verkle.DB, id2Path func(string) ([]uint16, error)) *LiabilitySet {
+	// The tree writes through the same DB that memorizes the root, so the root is never stale
+	snapshots := newSnapshotDB(db)
+	memorizingDB := &DBMemorizeRoot{DB: snapshots}
+	tree := verkle.NewMultiAssetVerkleTree(uint16(pp.Fanout), pp.assetSlots(), id2Path, memorizingDB)
 	tree.PP = pp.PPPP
 
 	return &LiabilitySet{
-		DB:   &DBMemorizeRoot{DB: db},
-		pp:   pp,
-		tree: tree,
+		DB:        memorizingDB,
+		pp:        pp,
+		tree:      tree,
+		snapshots: snapshots,
+		lock:      &sync.RWMutex{},
+	}
+}
+
+// OpenLiabilitySet opens a liability set whose vertices were persisted to the given DB by a previous liability set,
+// without recomputing any commitment, along with its retained snapshots. If the DB is empty, the returned liability set is empty.
+// The retention policy of the previous liability set is not persisted, and must be set again on the returned liability set.
+func OpenLiabilitySet(pp *PublicParams, db verkle.DB, id2Path func(string) ([]uint16, error)) (*LiabilitySet, error) {
+	snapshots := newSnapshotDB(db)
+	if err := snapshots.load(); err != nil {
+		return nil, err
+	}
+
+	memorizingDB := &DBMemorizeRoot{DB: snapshots}
+	tree, err := verkle.OpenMultiAssetVerkleTree(uint16(pp.Fanout), pp.assetSlots(), id2Path, memorizingDB)
+	if err != nil {
+		return nil, err
+	}
+	tree.PP = pp.PPPP
+	// The tree persists its epoch only with the next update after a snapshot
+	if n := len(snapshots.snapshots); n > 0 && snapshots.snapshots[n-1].Epoch > tree.Epoch {
+		tree.Epoch = snapshots.snapshots[n-1].Epoch
+	}
+
+	return &LiabilitySet{
+		DB:        memorizingDB,
+		pp:        pp,
+		tree:      tree,
+		snapshots: snapshots,
+		lock:      &sync.RWMutex{},
+	}, nil
+}
+
+// SetBlindingSource derives the blinding factors of the vertices created from now on from the given source,
+// so that they can be regenerated from the source and the liabilities. The epoch of a vertex is the epoch of the latest snapshot
+// before it was created, or 0 if there is none. If the source is nil, blinding factors are drawn at random.
+func (ls *LiabilitySet) SetBlindingSource(source verkle.BlindingSource) {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	ls.tree.Blinding = source
+}
+
+// SetParallelBuild sets whether SetBatch, SetBatchBalances and BuildFromIterator build an empty liability set
+// by building the subtree in every index of the root of the tree concurrently, each committed to the DB in its own batch.
+// The liability set that is built is the same either way, given the same blinding source.
+func (ls *LiabilitySet) SetParallelBuild(enabled bool) {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	ls.tree.ParallelBuild = enabled
+}
+
+// GeneratePublicParams generates public parameters for liability sets of a single asset with the given fanout and tree type,
+// and returns them along with the mapping of identifiers to paths of the tree type.
+// It fails with ErrUnsupportedFanout if the tree type does not support the fanout.
+func GeneratePublicParams(fanOut uint16, treeType TreeType) (func(string) ([]uint16, error), *PublicParams, error) {
+	return GenerateMultiAssetPublicParams(fanOut, treeType, 1)
+}
+
+// GenerateMultiAssetPublicParams is like GeneratePublicParams, but for liability sets in which every liability
+// is made of a balance of each of the given number of assets, and every vertex commits to a sum per asset.
+func GenerateMultiAssetPublicParams(fanOut uint16, treeType TreeType, assets int) (func(string) ([]uint16, error), *PublicParams, error) {
+	return GenerateMultiAssetPublicParamsWithBits(fanOut, treeType, assets, bp.DefaultBits)
+}
+
+// GenerateMultiAssetPublicParamsWithBits is like GenerateMultiAssetPublicParams, but the range proofs prove
+// that every balance and every sum is in [0, 2^bits) instead of [0, 2^bp.DefaultBits).
+func GenerateMultiAssetPublicParamsWithBits(fanOut uint16, treeType TreeType, assets, bits int) (func(string) ([]uint16, error), *PublicParams, error) {
+	if assets < 1 {
+		return nil, nil, fmt.Errorf("number of assets must be positive but is %d", assets)
+	}
+	if err := bp.CheckBits(bits); err != nil {
+		return nil, nil, err
+	}
+	id2Path, m, err := pathParams(fanOut, treeType, assets)
+	if err != nil {
+		return nil, nil, err
+	}
+	n := assetSlots(assets) * int(fanOut+1)
+	return id2Path, newPublicParams(fanOut, treeType, assets, bits, poe.NewPublicParams(n+1, m)), nil
+}
+
+// GeneratePublicParamsFromPP is like GenerateMultiAssetPublicParams, but builds on top of the given PointProofs
+// public parameters, such as the output of a trusted setup ceremony, instead of sampling a trapdoor.
+func GeneratePublicParamsFromPP(fanOut uint16, treeType TreeType, assets int, pointProofsPP *pp.PP) (func(string) ([]uint16, error), *PublicParams, error) {
+	if assets < 1 {
+		return nil, nil, fmt.Errorf("number of assets must be positive but is %d", assets)
 	}
+	if n := assetSlots(assets)*int(fanOut+1) + 1; pointProofsPP.N != n {
+		return nil, nil, fmt.Errorf("a fanout of %d with %d assets requires vector commitments of size %d but they are of size %d", fanOut, assets, n, pointProofsPP.N)
+	}
+	if err := pointProofsPP.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	id2Path, m, err := pathParams(fanOut, treeType, assets)
+	if err != nil {
+		return nil, nil, err
+	}
+	return id2Path, newPublicParams(fanOut, treeType, assets, bp.DefaultBits, poe.NewPublicParamsFromPP(pointProofsPP, m)), nil
+}
+
+func newPublicParams(fanOut uint16, treeType TreeType, assets, bits int, poePP *poe.PP) *PublicParams {
+	n := assetSlots(assets) * int(fanOut+1)
+
+	pp := &PublicParams{
+		Fanout:   int(fanOut),
+		TreeType: treeType,
+		Assets:   assets,
+		PPPP:     poePP.PP,
+		SAPP:     sum.NewMultiAssetPublicParams(n, assetSlots(assets)),
+		RPPP:     bp.NewRangeProofPublicParamsWithBits(n, bits),
+		POEPP:    poePP,
+	}
+
+	pp.SAPP.F = pp.PPPP.G1s[n]
+	pp.SAPP.Gs = make(common.G1v, n)
+	copy(pp.SAPP.Gs, pp.PPPP.G1s)
+	pp.SAPP.RecomputeDigest()
+
+	pp.RPPP.Gs = pp.SAPP.Gs
+	pp.RPPP.F = pp.SAPP.F
+	pp.RPPP.RecomputeDigest()
+	return pp
+}
+
+// assetSlots returns the number of assets the vertices make room for.
+// The range proofs require the vectors to be of a size that is a power of two,
+// so the assets are padded with assets whose balances are always zero.
+func assetSlots(assets int) int {
+	slots := 1
+	for slots < assets {
+		slots *= 2
+	}
+	return slots
+}
+
+func (pp *PublicParams) assetSlots() int {
+	return assetSlots(pp.Assets)
 }
 
-func GeneratePublicParams(fanOut uint16, treeType TreeType) (func(string) []uint16, *PublicParams) {
-	var id2Path func(string) []uint16
+// pathParams returns the mapping of identifiers to paths of the given tree type,
+// and the number of equality proofs (rounded up to a power of two) in a liability proof.
+func pathParams(fanOut uint16, treeType TreeType, assets int) (func(string) ([]uint16, error), int, error) {
+	var id2Path func(string) ([]uint16, error)
 	var m int
+	var err error
 
 	if treeType == Dense {
+		id2Path, err = sparse.DigitPath(fanOut)
+		if err != nil {
+			return nil, 0, err
+		}
 		m = sparse.DigitPathLen(fanOut) - 1
-		id2Path = sparse.DigitPath(fanOut)
 	}
 
 	if treeType == Sparse {
+		id2Path, err = sparse.HexId2PathForFanOut(fanOut)
+		if err != nil {
+			return nil, 0, err
+		}
 		m = sparse.ExpectedHexPathLengthByFanOut[fanOut] - 1
-		id2Path = sparse.HexId2PathForFanOut(fanOut)
 	}
 
+	// Every asset has its own equality proof in every layer
+	m *= assets
+
 	for !common.IsPowerOfTwo(uint16(m)) {
 		m = m + 1
 	}
 
-	poePP := poe.NewPublicParams(int(fanOut+2), m)
+	return id2Path, m, nil
+}
+
+// Digest returns a digest of the public parameters that binds the fanout and the tree type.
+// Two parties hold the same public parameters if and only if their digests match.
+func (pp *PublicParams) Digest() ([]byte, error) {
+	raw, err := pp.encode()
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(raw)
+	return digest[:], nil
+}
+
+// WriteTo writes the public parameters followed by their digest to w.
+func (pp *PublicParams) WriteTo(w io.Writer) (int64, error) {
+	raw, err := pp.encode()
+	if err != nil {
+		return 0, err
+	}
+	digest := sha256.Sum256(raw)
+	n, err := w.Write(append(raw, digest[:]...))
+	return int64(n), err
+}
 
-	n := int(fanOut + 1)
+func (pp *PublicParams) encode() ([]byte, error) {
+	e := common.NewEncoder()
+	e.Uint32(uint32(pp.Fanout))
+	if pp.TreeType == Dense {
+		e.Uint32(1)
+	} else {
+		e.Uint32(0)
+	}
+	e.Uint32(uint32(pp.Assets))
+	e.Marshaler(pp.POEPP)
+	e.Marshaler(pp.SAPP)
+	e.Marshaler(pp.RPPP)
+	return e.Result()
+}
 
+// ReadPublicParams reads public parameters written by WriteTo,
+// and returns them along with the mapping of identifiers to paths of their tree type.
+// It fails if the digest does not match, or if the parameters are not structured
+// like the ones produced by GeneratePublicParams.
+func ReadPublicParams(r io.Reader) (func(string) ([]uint16, error), *PublicParams, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(raw) < sha256.Size {
+		return nil, nil, fmt.Errorf("public parameters are too short")
+	}
+
+	raw, expectedDigest := raw[:len(raw)-sha256.Size], raw[len(raw)-sha256.Size:]
+	if digest := sha256.Sum256(raw); !bytes.Equal(digest[:], expectedDigest) {
+		return nil, nil, fmt.Errorf("digest mismatch")
+	}
+
+	d := common.NewDecoder(raw)
+	fanOut := d.Uint32()
+	treeType := d.Uint32()
+	assets := d.Uint32()
 	pp := &PublicParams{
-		Fanout: int(fanOut),
-		PPPP:   poePP.PP,
-		SAPP:   sum.NewPublicParams(n),
-		RPPP:   bp.NewRangeProofPublicParams(n),
-		POEPP:  poePP,
+		Fanout:   int(fanOut),
+		TreeType: treeType == 1,
+		Assets:   int(assets),
+		POEPP:    &poe.PP{},
+		SAPP:     &sum.PP{},
+		RPPP:     &bp.RangeProofPublicParams{},
+	}
+	d.Unmarshaler(pp.POEPP)
+	d.Unmarshaler(pp.SAPP)
+	d.Unmarshaler(pp.RPPP)
+	if err := d.Finish(); err != nil {
+		return nil, nil, err
 	}
 
-	pp.SAPP.F = pp.PPPP.G1s[n]
-	pp.SAPP.Gs = make(common.G1v, n)
-	copy(pp.SAPP.Gs, pp.PPPP.G1s)
+	if treeType > 1 {
+		return nil, nil, fmt.Errorf("unknown tree type %d", treeType)
+	}
+	if fanOut >= gomath.MaxUint16 || !common.IsPowerOfTwo(uint16(fanOut+1)) {
+		return nil, nil, fmt.Errorf("invalid fanout %d", fanOut)
+	}
+	if _, exists := sparse.ExpectedHexPathLengthByFanOut[uint16(fanOut)]; pp.TreeType == Sparse && !exists {
+		return nil, nil, fmt.Errorf("a fanout of %d is not supported for sparse trees", fanOut)
+	}
+	if assets < 1 || assets > gomath.MaxUint16 {
+		return nil, nil, fmt.Errorf("invalid number of assets %d", assets)
+	}
 
+	pp.PPPP = pp.POEPP.PP
+
+	id2Path, m, err := pathParams(uint16(fanOut), pp.TreeType, pp.Assets)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := pp.checkSizes(m); err != nil {
+		return nil, nil, err
+	}
+
+	// The sum argument and the range proof commit to the same generators as the vertices
+	n := pp.assetSlots() * int(fanOut+1)
+	if !pp.SAPP.F.Equals(pp.PPPP.G1s[n]) || !pp.RPPP.F.Equals(pp.PPPP.G1s[n]) {
+		return nil, nil, fmt.Errorf("blinding generator of the sum argument or range proof does not match the vector commitment")
+	}
+	for i := 0; i < n; i++ {
+		if !pp.SAPP.Gs[i].Equals(pp.PPPP.G1s[i]) || !pp.RPPP.Gs[i].Equals(pp.PPPP.G1s[i]) {
+			return nil, nil, fmt.Errorf("generator %d of the sum argument or range proof does not match the vector commitment", i)
+		}
+	}
+	pp.SAPP.F = pp.PPPP.G1s[n]
+	pp.SAPP.Gs = pp.PPPP.G1s[:n:n]
 	pp.RPPP.Gs = pp.SAPP.Gs
 	pp.RPPP.F = pp.SAPP.F
-	return id2Path, pp
+
+	return id2Path, pp, nil
+}
+
+func (pp *PublicParams) checkSizes(m int) error {
+	n := pp.assetSlots() * (pp.Fanout + 1)
+	if pp.PPPP.N != n+1 {
+		return fmt.Errorf("vector commitment should be of size %d but is of size %d", n+1, pp.PPPP.N)
+	}
+	if len(pp.POEPP.G) != m {
+		return fmt.Errorf("equality proof parameters should be of size %d but are of size %d", m, len(pp.POEPP.G))
+	}
+	if len(pp.SAPP.Gs) != n {
+		return fmt.Errorf("sum argument parameters should be of size %d but are of size %d", n, len(pp.SAPP.Gs))
+	}
+	if pp.SAPP.Assets != pp.assetSlots() {
+		return fmt.Errorf("sum argument parameters should be of %d assets but are of %d assets", pp.assetSlots(), pp.SAPP.Assets)
+	}
+	if len(pp.RPPP.Gs) != n {
+		return fmt.Errorf("range proof parameters should be of size %d but are of size %d", n, len(pp.RPPP.Gs))
+	}
+	return nil
 }
 
 type LiabilityProof struct {
@@ -110,6 +399,8 @@ type LiabilityProof struct {
 	RangeProofs      []*bp.RangeProof
 	EqualityProof    *poe.AggregatedProof
 	LiabilityProof   TotalProof
+	// AssetProofs are the balances of the rest of the assets in a liability set of several assets
+	AssetProofs []TotalProof
 }
 
 func (lp LiabilityProof) Size() int {
@@ -121,88 +412,257 @@ func (lp LiabilityProof) Size() int {
 	return size
 }
 
-func (lp LiabilityProof) Verify(publicParams *PublicParams, id string, V, W *math.G1, id2path func(string) []uint16) ([]time.Duration, error) {
-	path := id2path(id)
-	expectedDigestNum := len(path)
-	if len(lp.W) != expectedDigestNum-1 || len(lp.Digests) != expectedDigestNum {
-		return nil, fmt.Errorf("expected digest proofs of size %d but got %d", expectedDigestNum, len(lp.W))
+func (lp LiabilityProof) MarshalBinary() ([]byte, error) {
+	e := common.NewEncoder()
+	e.Zr(lp.PointProofΣ)
+	e.G1(lp.PointProofπ)
+	e.Marshaler(lp.SumArgumentProof)
+	e.G1v(lp.V)
+	e.G1v(lp.W)
+	e.Vec(lp.Digests)
+	e.Uint32(uint32(len(lp.RangeProofs)))
+	for _, rp := range lp.RangeProofs {
+		e.Marshaler(rp)
+	}
+	e.Marshaler(lp.EqualityProof)
+	e.Marshaler(lp.LiabilityProof)
+	e.Uint32(uint32(len(lp.AssetProofs)))
+	for _, ap := range lp.AssetProofs {
+		e.Marshaler(ap)
 	}
+	return e.Result()
+}
 
-	// Check that the root is what is advertised.
-	if !lp.V[0].Equals(V) {
-		return nil, fmt.Errorf("root V does not match public known V value")
+func (lp *LiabilityProof) UnmarshalBinary(b []byte) error {
+	d := common.NewDecoder(b)
+	lp.PointProofΣ = d.Zr()
+	lp.PointProofπ = d.G1()
+	lp.SumArgumentProof = &sum.Proof{}
+	d.Unmarshaler(lp.SumArgumentProof)
+	lp.V = d.G1v()
+	lp.W = d.G1v()
+	lp.Digests = d.Vec()
+	// Every range proof is prefixed by its length, so it takes up at least 4 bytes
+	lp.RangeProofs = make([]*bp.RangeProof, d.Len(4))
+	for i := range lp.RangeProofs {
+		lp.RangeProofs[i] = &bp.RangeProof{}
+		d.Unmarshaler(lp.RangeProofs[i])
 	}
-	if !lp.W[0].Equals(W) {
-		return nil, fmt.Errorf("root W does not match public known W value")
+	lp.EqualityProof = &poe.AggregatedProof{}
+	d.Unmarshaler(lp.EqualityProof)
+	d.Unmarshaler(&lp.LiabilityProof)
+	lp.AssetProofs = make([]TotalProof, d.Len(4))
+	for i := range lp.AssetProofs {
+		d.Unmarshaler(&lp.AssetProofs[i])
+	}
+	if len(lp.AssetProofs) == 0 {
+		lp.AssetProofs = nil
 	}
+	return d.Finish()
+}
 
-	var rangeProofsVerification sync.WaitGroup
-	rangeProofsVerification.Add(len(path))
+type liabilityProofJSON struct {
+	Version          byte              `json:"version"`
+	PointProofΣ      string            `json:"pointProofSigma"`
+	PointProofπ      string            `json:"pointProofPi"`
+	SumArgumentProof json.RawMessage   `json:"sumArgumentProof"`
+	V                []string          `json:"v"`
+	W                []string          `json:"w"`
+	Digests          []string          `json:"digests"`
+	RangeProofs      []json.RawMessage `json:"rangeProofs"`
+	EqualityProof    json.RawMessage   `json:"equalityProof"`
+	LiabilityProof   json.RawMessage   `json:"liabilityProof"`
+	AssetProofs      []json.RawMessage `json:"assetProofs,omitempty"`
+}
 
-	var detectedRangeProofErr atomic.Value
+func (lp LiabilityProof) MarshalJSON() ([]byte, error) {
+	var e common.HexEncoder
+	raw := liabilityProofJSON{
+		Version:          common.EncodingVersion,
+		PointProofΣ:      e.Zr(lp.PointProofΣ),
+		PointProofπ:      e.G1(lp.PointProofπ),
+		SumArgumentProof: e.JSON(lp.SumArgumentProof),
+		V:                e.G1v(lp.V),
+		W:                e.G1v(lp.W),
+		Digests:          e.Vec(lp.Digests),
+		RangeProofs:      make([]json.RawMessage, len(lp.RangeProofs)),
+		EqualityProof:    e.JSON(lp.EqualityProof),
+		LiabilityProof:   e.JSON(lp.LiabilityProof),
+	}
+	for i, rp := range lp.RangeProofs {
+		raw.RangeProofs[i] = e.JSON(rp)
+	}
+	for _, ap := range lp.AssetProofs {
+		raw.AssetProofs = append(raw.AssetProofs, e.JSON(ap))
+	}
+	if err := e.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
 
-	equalities := &poe.Equalities{
-		PP: publicParams.POEPP,
-		W:  make(common.G1v, len(path)-1),
-		V:  make(common.G1v, len(path)-1),
-		I:  make([]int, len(path)-1),
-		J:  make([]int, len(path)-1),
-		RO: poe.RO,
+func (lp *LiabilityProof) UnmarshalJSON(b []byte) error {
+	var raw liabilityProofJSON
+	if err := common.UnmarshalJSONStrict(b, &raw); err != nil {
+		return err
+	}
+	if raw.Version != common.EncodingVersion {
+		return fmt.Errorf("unsupported encoding version %d", raw.Version)
+	}
+	var d common.HexDecoder
+	lp.PointProofΣ = d.Zr(raw.PointProofΣ)
+	lp.PointProofπ = d.G1(raw.PointProofπ)
+	lp.SumArgumentProof = &sum.Proof{}
+	d.JSON(raw.SumArgumentProof, lp.SumArgumentProof)
+	lp.V = d.G1v(raw.V)
+	lp.W = d.G1v(raw.W)
+	lp.Digests = d.Vec(raw.Digests)
+	lp.RangeProofs = make([]*bp.RangeProof, len(raw.RangeProofs))
+	for i, rp := range raw.RangeProofs {
+		lp.RangeProofs[i] = &bp.RangeProof{}
+		d.JSON(rp, lp.RangeProofs[i])
+	}
+	lp.EqualityProof = &poe.AggregatedProof{}
+	d.JSON(raw.EqualityProof, lp.EqualityProof)
+	d.JSON(raw.LiabilityProof, &lp.LiabilityProof)
+	if len(raw.AssetProofs) > 0 {
+		lp.AssetProofs = make([]TotalProof, len(raw.AssetProofs))
 	}
+	for i, ap := range raw.AssetProofs {
+		d.JSON(ap, &lp.AssetProofs[i])
+	}
+	return d.Err()
+}
 
-	for i := 0; i < len(path); i++ {
+// Verify checks the liability of the given id in the liability set with the given root V and W.
+// It fails with ErrInvalidID if the id cannot be mapped to a path, with ErrMalformedProof if the proof
+// is not structured like a proof of the public parameters, and with ErrInvalidProof if it does not verify.
+func (lp LiabilityProof) Verify(publicParams *PublicParams, id string, V, W *math.G1, id2path func(string) ([]uint16, error)) ([]time.Duration, error) {
+	path, err := id2path(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := lp.checkPath(publicParams, path, V, W); err != nil {
+		return nil, err
+	}
+	if len(lp.RangeProofs) != len(path) {
+		return nil, malformed("expected %d range proofs but got %d", len(path), len(lp.RangeProofs))
+	}
+	if lp.SumArgumentProof == nil || lp.EqualityProof == nil {
+		return nil, malformed("missing sum argument or equality proof")
+	}
 
-		if i < len(path)-1 {
-			equalities.I[i] = int(path[i])
-			equalities.J[i] = publicParams.Fanout
-			equalities.V[i] = lp.V[i]
-			equalities.W[i] = lp.V[i+1]
-		}
+	waitForRangeProofs := verifyRangeProofs(publicParams, lp.RangeProofs, lp.V)
 
-		verifyRangeProof := func(rp *bp.RangeProof, V *math.G1) {
-			defer rangeProofsVerification.Done()
-			if err := bp.VerifyRange(publicParams.RPPP, rp, V); err != nil {
-				detectedRangeProofErr.Store(err)
-			}
-		}
+	if err := pp.VerifyAggregation(publicParams.PPPP, uint16VecToIntVec(path)[:len(path)-1], lp.W, lp.PointProofπ, lp.PointProofΣ, pp.RO); err != nil {
+		return nil, verificationFailed(err, "hash chain aggregation proof invalid")
+	}
 
-		if ParallelismEnabled {
-			go verifyRangeProof(lp.RangeProofs[i], lp.V[i])
-		} else {
-			verifyRangeProof(lp.RangeProofs[i], lp.V[i])
-		}
+	saStart := time.Now()
+	if err := lp.SumArgumentProof.VerifyAggregated(publicParams.SAPP, lp.V); err != nil {
+		return nil, verificationFailed(err, "failed verifying sum argument")
+	}
+	saElapsed := time.Since(saStart)
+
+	eqStart := time.Now()
+	if err := verifyEqualities(publicParams, lp.EqualityProof, path[:len(path)-1], lp.V[:len(lp.V)-1], lp.V[1:]); err != nil {
+		return nil, err
+	}
+	eqElapsed := time.Since(eqStart)
+
+	if err := waitForRangeProofs(); err != nil {
+		return nil, verificationFailed(err, "failed verifying range proofs")
+	}
+
+	if err := lp.verifyBalances(publicParams, path); err != nil {
+		return nil, err
+	}
+
+	return []time.Duration{saElapsed, eqElapsed}, nil
+}
 
+// checkPath checks that the vertices of the proof start at the given root,
+// and that each of them is committed to by the digests of its parent.
+func (lp LiabilityProof) checkPath(publicParams *PublicParams, path []uint16, V, W *math.G1) error {
+	expectedDigestNum := len(path)
+	if len(lp.V) != expectedDigestNum || len(lp.W) != expectedDigestNum-1 || len(lp.Digests) != expectedDigestNum {
+		return malformed("expected digest proofs of size %d but got %d", expectedDigestNum, len(lp.W))
+	}
+	if lp.V.HasNil() || lp.W.HasNil() || lp.Digests.HasNil() {
+		return malformed("missing vertex commitments or digests")
+	}
+	if len(lp.AssetProofs) != publicParams.Assets-1 {
+		return malformed("expected balances of %d assets but got %d", publicParams.Assets, len(lp.AssetProofs)+1)
+	}
+
+	// Check that the root is what is advertised.
+	if !lp.V[0].Equals(V) {
+		return invalid("root V does not match public known V value")
+	}
+	if !lp.W[0].Equals(W) {
+		return invalid("root W does not match public known W value")
+	}
+
+	for i := 1; i < len(path); i++ {
 		if i == len(path)-1 {
 			// This is the leaf layer, so we have no W.
 			// Just check that the leaf matches with the previous digest.
+			return lp.checkCommitmentToLeafVertex(i)
+		}
 
-			err := lp.checkCommitmentToLeafVertex(i)
-			if err != nil {
-				return nil, err
-			}
-
-			continue
+		if err := lp.checkCommitmentToInnerVertex(i); err != nil {
+			return err
 		}
+	}
 
-		if i > 0 {
-			err := lp.checkCommitmentToInnerVertex(i)
-			if err != nil {
-				return nil, err
-			}
+	return nil
+}
+
+// verifyRangeProofs starts verifying the range proofs of the given vertex commitments in a single batch,
+// and returns a function that waits for the verification to finish.
+func verifyRangeProofs(publicParams *PublicParams, rangeProofs []*bp.RangeProof, V common.G1v) func() error {
+	if !ParallelismEnabled {
+		err := bp.BatchVerifyRange(publicParams.RPPP, rangeProofs, V)
+		return func() error {
+			return err
 		}
 	}
 
-	if err := pp.VerifyAggregation(publicParams.PPPP, uint16VecToIntVec(path)[:len(path)-1], lp.W, lp.PointProofπ, lp.PointProofΣ, pp.RO); err != nil {
-		return nil, fmt.Errorf("hash chain aggregation proof invalid: %v", err)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- bp.BatchVerifyRange(publicParams.RPPP, rangeProofs, V)
+	}()
+
+	return func() error {
+		return <-errs
 	}
+}
 
-	saStart := time.Now()
-	if err := lp.SumArgumentProof.VerifyAggregated(publicParams.SAPP, lp.V); err != nil {
-		return nil, fmt.Errorf("failed verifying sum argument: %v", err)
+// verifyEqualities checks that the value of every asset in every parent vertex, in the given index,
+// is the sum of the asset in the corresponding child vertex.
+func verifyEqualities(publicParams *PublicParams, proof *poe.AggregatedProof, indices []uint16, parents, children common.G1v) error {
+	assets := publicParams.Assets
+	block := publicParams.Fanout + 1
+	equalities := &poe.Equalities{
+		PP: publicParams.POEPP,
+		W:  make(common.G1v, len(indices)*assets),
+		V:  make(common.G1v, len(indices)*assets),
+		I:  make([]int, len(indices)*assets),
+		J:  make([]int, len(indices)*assets),
+		RO: poe.RO,
 	}
-	saElapsed := time.Since(saStart)
 
-	zeroVec := make(common.Vec, publicParams.Fanout+2)
+	for i := range indices {
+		for asset := 0; asset < assets; asset++ {
+			k := i*assets + asset
+			equalities.I[k] = asset*block + int(indices[i])
+			equalities.J[k] = asset*block + publicParams.Fanout
+			equalities.V[k] = parents[i]
+			equalities.W[k] = children[i]
+		}
+	}
+
+	zeroVec := make(common.Vec, publicParams.PPPP.N)
 	zeroVec.Zero()
 	zeroCommit := pp.Commit(publicParams.PPPP, zeroVec)
 
@@ -214,22 +674,35 @@ func (lp LiabilityProof) Verify(publicParams *PublicParams, id string, V, W *mat
 		equalities.W = append(equalities.W, zeroCommit)
 	}
 
-	eqStart := time.Now()
-	if err := equalities.Verify(lp.EqualityProof); err != nil {
-		return nil, fmt.Errorf("failed verifying equality proof")
+	if err := equalities.Verify(proof); err != nil {
+		return verificationFailed(err, "failed verifying equality proof")
 	}
-	eqElapsed := time.Since(eqStart)
 
-	rangeProofsVerification.Wait()
-	if detectedRangeProofErr.Load() != nil {
-		return nil, detectedRangeProofErr.Load().(error)
+	return nil
+}
+
+// verifyBalances checks the openings of the balances of the client in the vertex above the leaf.
+func (lp LiabilityProof) verifyBalances(publicParams *PublicParams, path []uint16) error {
+	block := publicParams.Fanout + 1
+
+	if err := lp.LiabilityProof.checkSum(); err != nil {
+		return malformed("client liability: %v", err)
+	}
+	if err := pp.Verify(publicParams.PPPP, common.BigToZr(lp.LiabilityProof.Sum), lp.LiabilityProof.LiabilityProof, lp.V[len(lp.V)-1], int(path[len(path)-1])); err != nil {
+		return verificationFailed(err, "client liability proof is invalid")
 	}
 
-	if err := pp.Verify(publicParams.PPPP, common.IntToZr(lp.LiabilityProof.Sum), lp.LiabilityProof.LiabilityProof, lp.V[len(lp.V)-1], int(path[len(path)-1])); err != nil {
-		return nil, fmt.Errorf("client liability proof is invalid: %v", err)
+	for i, ap := range lp.AssetProofs {
+		if err := ap.checkSum(); err != nil {
+			return malformed("client liability of asset %d: %v", i+1, err)
+		}
+		index := (i+1)*block + int(path[len(path)-1])
+		if err := pp.Verify(publicParams.PPPP, common.BigToZr(ap.Sum), ap.LiabilityProof, lp.V[len(lp.V)-1], index); err != nil {
+			return verificationFailed(err, fmt.Sprintf("client liability proof of asset %d is invalid", i+1))
+		}
 	}
 
-	return []time.Duration{saElapsed, eqElapsed}, nil
+	return nil
 }
 
 func (lp LiabilityProof) checkCommitmentToInnerVertex(i int) error {
@@ -239,7 +712,7 @@ func (lp LiabilityProof) checkCommitmentToInnerVertex(i int) error {
 	hash := h.Sum(nil)
 	expectedPreviousDigest := common.FieldElementFromBytes(hash)
 	if !lp.Digests[i-1].Equals(expectedPreviousDigest) {
-		return fmt.Errorf("hash path mismatch %d from root", i)
+		return invalid("hash path mismatch %d from root", i)
 	}
 	return nil
 }
@@ -250,59 +723,181 @@ func (lp LiabilityProof) checkCommitmentToLeafVertex(i int) error {
 	hash := h.Sum(nil)
 	expectedPreviousDigest := common.FieldElementFromBytes(hash)
 	if !lp.Digests[i-1].Equals(expectedPreviousDigest) {
-		return fmt.Errorf("hash path mismatch %d from root", i)
+		return invalid("hash path mismatch %d from root", i)
 	}
 	return nil
 }
 
+// Root returns the commitments of the root, or nil if the liability set is empty or its root cannot be loaded from the DB.
 func (ls *LiabilitySet) Root() (V, W *math.G1) {
+	ls.lock.RLock()
+	defer ls.lock.RUnlock()
+
+	return ls.root()
+}
+
+func (ls *LiabilitySet) root() (V, W *math.G1) {
 	if ls.tree == nil || ls.tree.Tree == nil || ls.tree.Tree.Root == nil {
 		return nil, nil
 	}
 	key := ls.tree.Tree.Root.Data.(string)
-	bytes := ls.DB.Get([]byte(key))
+	bytes, err := ls.DB.Get([]byte(key))
+	if err != nil {
+		return nil, nil
+	}
 	v := &verkle.Vertex{}
-	v.FromBytes(bytes)
+	if err := v.FromBytes(bytes); err != nil {
+		return nil, nil
+	}
 	return v.V, v.W
 }
 
+// groupOrder bounds the sums, as they are values of vector commitments
+var groupOrder = new(big.Int).SetBytes(common.GroupOrder.Bytes())
+
 type TotalProof struct {
 	LiabilityProof *math.G1
-	Sum            int
+	Sum            *big.Int
 }
 
-func (tp TotalProof) Verify(publicParams *PublicParams, V *math.G1) error {
-	mi := common.IntToZr(tp.Sum)
-	return pp.Verify(publicParams.PPPP, mi, tp.LiabilityProof, V, publicParams.Fanout)
+// checkSum returns an error if the sum cannot be the value of a vector commitment
+func (tp TotalProof) checkSum() error {
+	if tp.Sum == nil {
+		return fmt.Errorf("sum is missing")
+	}
+	if tp.Sum.Sign() < 0 {
+		return fmt.Errorf("sum cannot be negative")
+	}
+	if tp.Sum.Cmp(groupOrder) >= 0 {
+		return fmt.Errorf("sum %s is out of range", tp.Sum)
+	}
+	return nil
 }
 
-func (ls *LiabilitySet) ProveTot() TotalProof {
-	cachedRoot := ls.tree.DB.Get(nil)
+func (tp TotalProof) MarshalBinary() ([]byte, error) {
+	if err := tp.checkSum(); err != nil {
+		return nil, err
+	}
+	e := common.NewEncoder()
+	e.G1(tp.LiabilityProof)
+	e.Zr(common.BigToZr(tp.Sum))
+	return e.Result()
+}
 
-	v := &verkle.Vertex{}
-	v.FromBytes(cachedRoot)
+func (tp *TotalProof) UnmarshalBinary(b []byte) error {
+	d := common.NewDecoder(b)
+	tp.LiabilityProof = d.G1()
+	sum := d.Zr()
+	if err := d.Finish(); err != nil {
+		return err
+	}
+	tp.Sum = common.ZrToBig(sum)
+	return nil
+}
 
-	sum, π := ls.openSumFromVertex(v)
+type totalProofJSON struct {
+	Version        byte   `json:"version"`
+	LiabilityProof string `json:"liabilityProof"`
+	Sum            string `json:"sum"`
+}
 
-	sumAsInt, err := sum.Int()
-	if err != nil {
-		panic(err)
+func (tp TotalProof) MarshalJSON() ([]byte, error) {
+	if err := tp.checkSum(); err != nil {
+		return nil, err
+	}
+	var e common.HexEncoder
+	raw := totalProofJSON{
+		Version:        common.EncodingVersion,
+		LiabilityProof: e.G1(tp.LiabilityProof),
+		Sum:            tp.Sum.String(),
 	}
+	if err := e.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
 
-	return TotalProof{
-		Sum:            int(sumAsInt),
-		LiabilityProof: π,
+func (tp *TotalProof) UnmarshalJSON(b []byte) error {
+	var raw totalProofJSON
+	if err := common.UnmarshalJSONStrict(b, &raw); err != nil {
+		return err
 	}
+	if raw.Version != common.EncodingVersion {
+		return fmt.Errorf("unsupported encoding version %d", raw.Version)
+	}
+	var d common.HexDecoder
+	tp.LiabilityProof = d.G1(raw.LiabilityProof)
+	if err := d.Err(); err != nil {
+		return err
+	}
+	sum, ok := new(big.Int).SetString(raw.Sum, 10)
+	if !ok || sum.Sign() < 0 || sum.String() != raw.Sum {
+		return fmt.Errorf("sum %q is not a canonical non-negative decimal number", raw.Sum)
+	}
+	tp.Sum = sum
+	return tp.checkSum()
 }
 
-func (ls *LiabilitySet) openSumFromVertex(v *verkle.Vertex) (*math.Zr, *math.G1) {
-	values := v.Values(ls.pp.PPPP.N - 1)
-	m := make(common.Vec, len(values)+1)
-	copy(m, values)
-	m[len(m)-1] = v.BlindingFactor
+func (tp TotalProof) Verify(publicParams *PublicParams, V *math.G1) error {
+	return tp.VerifyAsset(publicParams, V, 0)
+}
 
-	sum, π := pp.Open(ls.pp.PPPP, len(m)-2, m)
-	return sum, π
+// VerifyAsset checks that the total of the given asset in the root V is the sum of the proof.
+func (tp TotalProof) VerifyAsset(publicParams *PublicParams, V *math.G1, asset int) error {
+	if asset < 0 || asset >= publicParams.Assets {
+		return fmt.Errorf("asset %d does not exist", asset)
+	}
+	if err := tp.checkSum(); err != nil {
+		return malformed("%v", err)
+	}
+	mi := common.BigToZr(tp.Sum)
+	if err := pp.Verify(publicParams.PPPP, mi, tp.LiabilityProof, V, asset*(publicParams.Fanout+1)+publicParams.Fanout); err != nil {
+		return verificationFailed(err, "total proof is invalid")
+	}
+	return nil
+}
+
+func (ls *LiabilitySet) ProveTot() (TotalProof, error) {
+	totals, err := ls.ProveTotals()
+	if err != nil {
+		return TotalProof{}, err
+	}
+	return totals[0], nil
+}
+
+// ProveTotals returns a proof of the total of every asset, to be verified with VerifyAsset.
+// It fails with ErrNotFound if the liability set is empty.
+func (ls *LiabilitySet) ProveTotals() ([]TotalProof, error) {
+	ls.lock.RLock()
+	cachedRoot, err := ls.tree.DB.Get(nil)
+	ls.lock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if len(cachedRoot) == 0 {
+		return nil, fmt.Errorf("root %w: the liability set is empty", ErrNotFound)
+	}
+
+	v := &verkle.Vertex{}
+	if err := v.FromBytes(cachedRoot); err != nil {
+		return nil, err
+	}
+
+	totals := make([]TotalProof, ls.pp.Assets)
+	for asset := range totals {
+		sum, π := ls.openSumFromVertex(v, asset)
+
+		totals[asset] = TotalProof{
+			Sum:            common.ZrToBig(sum),
+			LiabilityProof: π,
+		}
+	}
+
+	return totals, nil
+}
+
+func (ls *LiabilitySet) openSumFromVertex(v *verkle.Vertex, asset int) (*math.Zr, *math.G1) {
+	return ls.openForClient(v, asset*(ls.pp.Fanout+1)+ls.pp.Fanout)
 }
 
 func (ls *LiabilitySet) openForClient(v *verkle.Vertex, index int) (*math.Zr, *math.G1) {
@@ -315,95 +910,327 @@ func (ls *LiabilitySet) openForClient(v *verkle.Vertex, index int) (*math.Zr, *m
 	return sum, π
 }
 
-func (ls *LiabilitySet) Set(id string, liability int64) {
-	if liability < 0 {
-		panic("Liability cannot be negative")
+// Set sets the liability of the given id, which must be in [0, 2^bits) for the bit width of the range proofs.
+// The sums of the liabilities must be in that range as well, or else they cannot be proven.
+// It fails with ErrInvalidID if the id cannot be mapped to a path, and with ErrInvalidLiability if the liability
+// or the sum of the liabilities would be out of range.
+func (ls *LiabilitySet) Set(id string, liability *big.Int) error {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	if err := ls.checkWritable(); err != nil {
+		return err
+	}
+	if err := ls.checkLiability(id, liability); err != nil {
+		return err
+	}
+	if err := ls.checkTotals(map[string][]*big.Int{id: {liability}}); err != nil {
+		return err
 	}
+	return ls.tree.Put(id, liability)
+}
+
+// SetBalances sets the balance of every asset of the given id. Missing balances at the end are zero.
+func (ls *LiabilitySet) SetBalances(id string, balances []*big.Int) error {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
 
-	ls.tree.Put(id, liability)
+	if err := ls.checkWritable(); err != nil {
+		return err
+	}
+	if err := ls.checkBalances(id, balances); err != nil {
+		return err
+	}
+	if err := ls.checkTotals(map[string][]*big.Int{id: balances}); err != nil {
+		return err
+	}
+	return ls.tree.PutBalances(id, balances)
 }
 
-func (ls *LiabilitySet) Get(id string) (int64, bool) {
-	liability, _, ok := ls.tree.Get(id)
-	return liability, ok
+func (ls *LiabilitySet) checkBalances(id string, balances []*big.Int) error {
+	if len(balances) > ls.pp.Assets {
+		return fmt.Errorf("%w: %s has %d balances but there are %d assets", ErrInvalidLiability, id, len(balances), ls.pp.Assets)
+	}
+	for _, balance := range balances {
+		if err := ls.checkLiability(id, balance); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (ls *LiabilitySet) ProveLiability(id string) (int64, LiabilityProof, []time.Duration, bool) {
-	path := ls.tree.Tree.ID2Path(id)
-	_, verticesAlongThePath, ok := ls.tree.Get(id)
+func (ls *LiabilitySet) checkLiability(id string, liability *big.Int) error {
+	if liability == nil {
+		return fmt.Errorf("%w: liability of %s is missing", ErrInvalidLiability, id)
+	}
+	if liability.Sign() < 0 {
+		return fmt.Errorf("%w: liability of %s cannot be negative", ErrInvalidLiability, id)
+	}
+	if liability.BitLen() > ls.pp.RPPP.Bits {
+		return fmt.Errorf("%w: liability of %s does not fit in %d bits", ErrInvalidLiability, id, ls.pp.RPPP.Bits)
+	}
+	return nil
+}
 
-	var vertices verkle.Vertices
-	var proof LiabilityProof
-	var digestProofs common.G1v
+// checkTotals fails with ErrInvalidLiability if replacing the balances of the given ids would make the total
+// of any asset not fit in the bit width of the range proofs. Every vertex sums a subset of the liabilities,
+// so if the totals in the root fit, then so do the sums in all ancestors of the ids.
+func (ls *LiabilitySet) checkTotals(balances map[string][]*big.Int) error {
+	totals, err := ls.totals()
+	if err != nil {
+		return err
+	}
+
+	for id, newBalances := range balances {
+		oldBalances, _, err := ls.tree.Tree.Get(id)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
 
-	if !ok {
-		return 0, proof, nil, false
+		for asset, total := range totals {
+			// Missing balances at the end are zero
+			if asset < len(newBalances) {
+				total.Add(total, newBalances[asset])
+			}
+			if oldBalances != nil {
+				total.Sub(total, oldBalances.([]*big.Int)[asset])
+			}
+		}
 	}
 
-	start := time.Now()
+	for asset, total := range totals {
+		if total.BitLen() > ls.pp.RPPP.Bits {
+			return fmt.Errorf("%w: total of asset %d would not fit in %d bits", ErrInvalidLiability, asset, ls.pp.RPPP.Bits)
+		}
+	}
+	return nil
+}
 
-	var rangeProofProduction sync.WaitGroup
-	rangeProofProduction.Add(len(path))
+// totals returns the total of every asset, as the root sums them
+func (ls *LiabilitySet) totals() ([]*big.Int, error) {
+	totals := make([]*big.Int, ls.pp.Assets)
+	for asset := range totals {
+		totals[asset] = new(big.Int)
+	}
 
-	var lock sync.Mutex
+	cachedRoot, err := ls.tree.DB.Get(nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(cachedRoot) == 0 {
+		return totals, nil
+	}
 
-	proof.RangeProofs = make([]*bp.RangeProof, len(path))
+	v := &verkle.Vertex{}
+	if err := v.FromBytes(cachedRoot); err != nil {
+		return nil, err
+	}
+	for asset := range totals {
+		totals[asset] = common.ZrToBig(v.Sums()[asset])
+	}
+	return totals, nil
+}
 
-	vEQ := make([]common.Vec, len(path)-1)
-	wEQ := make([]common.Vec, len(path)-1)
-	equalities := &poe.Equalities{
-		PP: ls.pp.POEPP,
-		W:  make(common.G1v, len(path)-1),
-		V:  make(common.G1v, len(path)-1),
-		I:  make([]int, len(path)-1),
-		J:  make([]int, len(path)-1),
-		RO: poe.RO,
+// SetBatch sets all the given liabilities at once. Unlike calling Set for each liability,
+// the commitments of every vertex along the paths of the liabilities are computed exactly once,
+// and vertices in the same layer of the tree are computed in parallel.
+// If any of the ids or liabilities is invalid, none of the liabilities is set.
+func (ls *LiabilitySet) SetBatch(liabilities map[string]*big.Int) error {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	if err := ls.checkWritable(); err != nil {
+		return err
+	}
+	balances := make(map[string][]*big.Int, len(liabilities))
+	for id, liability := range liabilities {
+		if err := ls.checkLiability(id, liability); err != nil {
+			return err
+		}
+		balances[id] = []*big.Int{liability}
+	}
+	if err := ls.checkTotals(balances); err != nil {
+		return err
 	}
 
-	for i := 0; i < len(path); i++ {
-		var digests []*math.Zr
-		v := verticesAlongThePath[i]
-		for j := 0; j <= ls.tree.Tree.FanOut+1; j++ {
-			digest, exists := v.Digests[uint16(j)]
-			if exists {
-				digests = append(digests, digest)
-			} else {
-				digests = append(digests, common.IntToZr(0))
-			}
+	return ls.tree.PutBatch(liabilities)
+}
+
+// SetBatchBalances is like SetBatch, but sets the balance of every asset of every id.
+func (ls *LiabilitySet) SetBatchBalances(balances map[string][]*big.Int) error {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	if err := ls.checkWritable(); err != nil {
+		return err
+	}
+	for id, b := range balances {
+		if err := ls.checkBalances(id, b); err != nil {
+			return err
 		}
+	}
+	if err := ls.checkTotals(balances); err != nil {
+		return err
+	}
 
-		values := v.Values(ls.tree.Tree.FanOut + 1)
-
-		// The last entry in the path points is the liabilities and not to other layers in the tree
-		if i < len(path)-1 {
-			equalities.I[i] = int(path[i])
-			equalities.J[i] = ls.tree.Tree.FanOut
-			equalities.V[i] = v.V
-			equalities.W[i] = verticesAlongThePath[i+1].V
-
-			vEQ[i] = make(common.Vec, ls.tree.Tree.FanOut+2)
-			wEQ[i] = make(common.Vec, ls.tree.Tree.FanOut+2)
-			copy(vEQ[i], values)
-			copy(wEQ[i], verticesAlongThePath[i+1].Values(ls.tree.Tree.FanOut+1))
-			vEQ[i][ls.tree.Tree.FanOut+1] = v.BlindingFactor
-			wEQ[i][ls.tree.Tree.FanOut+1] = verticesAlongThePath[i+1].BlindingFactor
+	return ls.tree.PutBatchBalances(balances)
+}
+
+// BuildFromIterator sets all liabilities returned by next until it returns false, as a single batch.
+// If an id is returned more than once, its last liability is set.
+func (ls *LiabilitySet) BuildFromIterator(next func() (id string, liability *big.Int, ok bool)) error {
+	liabilities := make(map[string]*big.Int)
+	for {
+		id, liability, ok := next()
+		if !ok {
+			break
 		}
+		liabilities[id] = liability
+	}
+
+	return ls.SetBatch(liabilities)
+}
+
+// Delete removes the liability of the given id, for example when the account is closed.
+// Vertices left without descendants are deleted from the DB, and the sums and commitments
+// of the rest of the vertices along the path are updated. It fails with ErrNotFound if the id is not in the liability set.
+func (ls *LiabilitySet) Delete(id string) error {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	if err := ls.checkWritable(); err != nil {
+		return err
+	}
+	return ls.tree.Delete(id)
+}
+
+// checkWritable returns an error if the liability set is a read-only view of a snapshot
+func (ls *LiabilitySet) checkWritable() error {
+	if ls.snapshots == nil {
+		return fmt.Errorf("a snapshot is read-only")
+	}
+	return nil
+}
+
+// Get returns the liability of the given id, or ErrNotFound if it is not in the liability set.
+func (ls *LiabilitySet) Get(id string) (*big.Int, error) {
+	ls.lock.RLock()
+	defer ls.lock.RUnlock()
+
+	liability, _, err := ls.tree.Get(id)
+	return liability, err
+}
+
+// GetBalances returns the balance of every asset of the given id.
+func (ls *LiabilitySet) GetBalances(id string) ([]*big.Int, error) {
+	ls.lock.RLock()
+	defer ls.lock.RUnlock()
+
+	balances, _, err := ls.tree.GetBalances(id)
+	if err != nil {
+		return nil, err
+	}
+	return balances[:ls.pp.Assets], nil
+}
+
+// ProveLiability proves the liability of the given id, or fails with ErrNotFound if it is not in the liability set,
+// and with ErrInvalidLiability if a value along its path does not fit in the bit width of the range proofs.
+func (ls *LiabilitySet) ProveLiability(id string) (*big.Int, LiabilityProof, []time.Duration, error) {
+	balances, proof, durations, err := ls.ProveBalances(id)
+	if err != nil {
+		return nil, proof, nil, err
+	}
+	return balances[0], proof, durations, nil
+}
+
+// ProveBalances is like ProveLiability, but returns the balance of every asset,
+// all of which are shown by the proof.
+func (ls *LiabilitySet) ProveBalances(id string) ([]*big.Int, LiabilityProof, []time.Duration, error) {
+	path, err := ls.tree.Tree.ID2Path(id)
+	if err != nil {
+		return nil, LiabilityProof{}, nil, err
+	}
+	ls.lock.RLock()
+	_, verticesAlongThePath, err := ls.tree.Get(id)
+	ls.lock.RUnlock()
+	if err != nil {
+		return nil, LiabilityProof{}, nil, err
+	}
+
+	start := time.Now()
+
+	vertices := verkle.Vertices(verticesAlongThePath)
+	cache := newProofCache(ls)
+	waitForRangeProofs := ls.proveRanges(vertices, cache)
+
+	proof, digestProofs, balances := ls.provePath(path, vertices, cache)
+
+	saStart := time.Now()
+	proof.PointProofΣ, proof.PointProofπ = ls.aggregateDigestProofs(proof, digestProofs)
+	proof.SumArgumentProof = vertices.SumArgument(ls.pp.SAPP)
+	saElapsed := time.Since(saStart)
+
+	eqProofStart := time.Now()
+	// The last entry in the path points is the liabilities and not to other layers in the tree
+	proof.EqualityProof, err = ls.proveEqualities(path[:len(path)-1], vertices[:len(path)-1], vertices[1:], cache)
+	eqProofElapsed := time.Since(eqProofStart)
+
+	rangeProofs, rangeProofErr := waitForRangeProofs()
+	if err != nil {
+		return nil, LiabilityProof{}, nil, err
+	}
+	if rangeProofErr != nil {
+		return nil, LiabilityProof{}, nil, rangeProofErr
+	}
+	proof.RangeProofs = rangeProofs
+
+	return balances, proof, []time.Duration{saElapsed, eqProofElapsed, time.Since(start)}, nil
+}
+
+// proveRanges starts proving that the values of the given vertices are in range,
+// and returns a function that waits for the range proofs, or for the first error in proving them.
+func (ls *LiabilitySet) proveRanges(vertices verkle.Vertices, cache *proofCache) func() ([]*bp.RangeProof, error) {
+	var rangeProofProduction sync.WaitGroup
+	rangeProofProduction.Add(len(vertices))
+
+	var lock sync.Mutex
+
+	rangeProofs := make([]*bp.RangeProof, len(vertices))
+	var firstErr error
 
-		createRangeProof := func(i int, pp *bp.RangeProofPublicParams, V *math.G1, v common.Vec, r *math.Zr) {
-			defer rangeProofProduction.Done()
-			rp := bp.ProveRange(pp, V, v, r)
-			lock.Lock()
-			proof.RangeProofs[i] = rp
-			lock.Unlock()
+	createRangeProof := func(i int, v *verkle.Vertex) {
+		defer rangeProofProduction.Done()
+		rp, err := cache.rangeProof(v)
+		lock.Lock()
+		rangeProofs[i] = rp
+		if err != nil && firstErr == nil {
+			firstErr = err
 		}
+		lock.Unlock()
+	}
 
+	for i, v := range vertices {
 		if ParallelismEnabled {
-			go createRangeProof(i, ls.pp.RPPP, v.V, values, v.BlindingFactor)
+			go createRangeProof(i, v)
 		} else {
-			createRangeProof(i, ls.pp.RPPP, v.V, values, v.BlindingFactor)
+			createRangeProof(i, v)
 		}
+	}
+
+	return func() ([]*bp.RangeProof, error) {
+		rangeProofProduction.Wait()
+		return rangeProofs, firstErr
+	}
+}
+
+// provePath fills the vertex commitments and digests along the path, and the balances of the client,
+// and returns the openings of the digests of the vertices that commit to digests, to be aggregated.
+func (ls *LiabilitySet) provePath(path []uint16, vertices verkle.Vertices, cache *proofCache) (LiabilityProof, common.G1v, []*big.Int) {
+	var proof LiabilityProof
+	var digestProofs common.G1v
 
-		digest, π := pp.Open(ls.tree.PP, int(path[i]), digests)
+	for i, v := range vertices {
+		digest, π := cache.openDigest(v, int(path[i]))
 
 		if v.W != nil {
 			proof.W = append(proof.W, v.W)
@@ -411,33 +1238,70 @@ func (ls *LiabilitySet) ProveLiability(id string) (int64, LiabilityProof, []time
 		}
 		proof.V = append(proof.V, v.V)
 		proof.Digests = append(proof.Digests, digest)
-		vertices = append(vertices, v)
 	}
 
+	block := ls.pp.Fanout + 1
 	lastVertex := vertices[len(path)-1]
-	l, liabilityProof := ls.openForClient(lastVertex, int(path[len(path)-1]))
-	liability, err := l.Int()
-	if err != nil {
-		panic(err)
-	}
+	balances := make([]*big.Int, ls.pp.Assets)
+	for asset := range balances {
+		l, liabilityProof := cache.openValue(lastVertex, asset*block+int(path[len(path)-1]))
+
+		balances[asset] = common.ZrToBig(l)
+		totalProof := TotalProof{
+			LiabilityProof: liabilityProof,
+			Sum:            common.ZrToBig(l),
+		}
 
-	proof.LiabilityProof = TotalProof{
-		LiabilityProof: liabilityProof,
-		Sum:            int(liability),
+		if asset == 0 {
+			proof.LiabilityProof = totalProof
+		} else {
+			proof.AssetProofs = append(proof.AssetProofs, totalProof)
+		}
 	}
 
-	var tPP common.Vec
-	for i := 0; i < len(path)-1; i++ {
-		tPP = append(tPP, pp.RO(ls.tree.PP, proof.W, i))
+	return proof, digestProofs, balances
+}
+
+// proveEqualities proves that the value of every asset in every parent vertex, in the given index,
+// is the sum of the asset in the corresponding child vertex.
+func (ls *LiabilitySet) proveEqualities(indices []uint16, parents, children verkle.Vertices, cache *proofCache) (*poe.AggregatedProof, error) {
+	assets := ls.pp.Assets
+	block := ls.pp.Fanout + 1
+	vectorSize := ls.pp.PPPP.N
+
+	vEQ := make([]common.Vec, len(indices)*assets)
+	wEQ := make([]common.Vec, len(indices)*assets)
+	equalities := &poe.Equalities{
+		PP: ls.pp.POEPP,
+		W:  make(common.G1v, len(indices)*assets),
+		V:  make(common.G1v, len(indices)*assets),
+		I:  make([]int, len(indices)*assets),
+		J:  make([]int, len(indices)*assets),
+		RO: poe.RO,
 	}
 
-	saStart := time.Now()
-	proof.PointProofΣ = proof.Digests[:len(tPP)].InnerProd(tPP)
-	proof.PointProofπ = pp.Aggregate(ls.tree.PP, proof.W, digestProofs, pp.RO)
-	proof.SumArgumentProof = vertices.SumArgument(ls.pp.SAPP)
-	saElapsed := time.Since(saStart)
+	for i := range indices {
+		v, w := parents[i], children[i]
+
+		vVec := make(common.Vec, vectorSize)
+		wVec := make(common.Vec, vectorSize)
+		copy(vVec, v.Values(vectorSize-1))
+		copy(wVec, w.Values(vectorSize-1))
+		vVec[vectorSize-1] = v.BlindingFactor
+		wVec[vectorSize-1] = w.BlindingFactor
+
+		for asset := 0; asset < assets; asset++ {
+			k := i*assets + asset
+			equalities.I[k] = asset*block + int(indices[i])
+			equalities.J[k] = asset*block + ls.pp.Fanout
+			equalities.V[k] = v.V
+			equalities.W[k] = w.V
+			vEQ[k] = vVec
+			wEQ[k] = wVec
+		}
+	}
 
-	zeroVec := make(common.Vec, ls.tree.Tree.FanOut+2)
+	zeroVec := make(common.Vec, vectorSize)
 	zeroVec.Zero()
 
 	zeroCommit := pp.Commit(ls.pp.PPPP, zeroVec)
@@ -454,13 +1318,189 @@ func (ls *LiabilitySet) ProveLiability(id string) (int64, LiabilityProof, []time
 		equalities.W = append(equalities.W, zeroCommit)
 	}
 
-	eqProofStart := time.Now()
-	proof.EqualityProof = equalities.Prove(vEQ, wEQ)
-	eqProofElapsed := time.Since(eqProofStart)
+	_, zeroOpening := pp.Open(ls.pp.PPPP, 0, zeroVec)
+
+	return equalities.ProveWithOpenings(vEQ, wEQ, func(k int) (*math.G1, *math.G1) {
+		if k >= len(indices)*assets {
+			return zeroOpening, zeroOpening
+		}
+		i := k / assets
+		_, ΩV := cache.openValue(parents[i], equalities.I[k])
+		_, ΩW := cache.openValue(children[i], equalities.J[k])
+		return ΩV, ΩW
+	})
+}
 
-	rangeProofProduction.Wait()
+// digestVector returns the vector of digests W of the given vertex commits to.
+func (ls *LiabilitySet) digestVector(v *verkle.Vertex) common.Vec {
+	var digests common.Vec
+	for j := 0; j < ls.pp.PPPP.N; j++ {
+		digest, exists := v.Digests[uint16(j)]
+		if exists {
+			digests = append(digests, digest)
+		} else {
+			digests = append(digests, common.IntToZr(0))
+		}
+	}
+	return digests
+}
+
+// AbsenceProof proves that an id is not in the liability set, by opening the first missing slot
+// along the path of the id: The value in V is zero, and if the vertex is an inner vertex, so is the digest in W.
+// Since leaves have no digests, a leaf with zero balances cannot be told apart from a missing one.
+type AbsenceProof struct {
+	// V and W are the commitments of the vertices along the path of the id, from the root down to
+	// the deepest vertex in the tree. The vertex in the layer above the leaves has no W.
+	V common.G1v
+	W common.G1v
+	// DigestProof is an aggregated opening of the digest in W[i] of the vertex in V[i+1], and of the zero digest
+	// of the missing slot if the last vertex is an inner vertex.
+	DigestProof *math.G1
+	// ValueProof is an opening of the zero value of the missing slot in the last vertex.
+	ValueProof *math.G1
+	// AssetValueProofs are the openings of the zero values of the rest of the assets in a liability set of several assets.
+	AssetValueProofs []*math.G1
+}
+
+// ProveAbsence returns a proof that the given id is not in the liability set.
+// It fails if the id is in the set, or if the set is empty.
+func (ls *LiabilitySet) ProveAbsence(id string) (AbsenceProof, error) {
+	var proof AbsenceProof
+
+	path, err := ls.tree.Tree.ID2Path(id)
+	if err != nil {
+		return proof, err
+	}
+
+	ls.lock.RLock()
+	if ls.tree.Tree.Root == nil {
+		ls.lock.RUnlock()
+		return proof, fmt.Errorf("root %w: the liability set is empty", ErrNotFound)
+	}
+	verticesAlongThePath, exists, err := ls.tree.GetPrefix(id)
+	ls.lock.RUnlock()
+	if err != nil {
+		return proof, err
+	}
+	if exists {
+		return proof, fmt.Errorf("%s is in the liability set", id)
+	}
 
-	return liability, proof, []time.Duration{saElapsed, eqProofElapsed, time.Since(start)}, true
+	var digestProofs common.G1v
+	var digests common.Vec
+
+	for i, v := range verticesAlongThePath {
+		proof.V = append(proof.V, v.V)
+		if v.W == nil {
+			continue
+		}
+		digest, π := pp.Open(ls.tree.PP, int(path[i]), ls.digestVector(v))
+		proof.W = append(proof.W, v.W)
+		digestProofs = append(digestProofs, π)
+		digests = append(digests, digest)
+	}
+
+	if len(proof.W) > 0 {
+		proof.DigestProof = pp.Aggregate(ls.tree.PP, proof.W, digestProofs, pp.RO)
+	}
+
+	k := len(verticesAlongThePath) - 1
+	_, proof.ValueProof = ls.openForClient(verticesAlongThePath[k], int(path[k]))
+	for asset := 1; asset < ls.pp.Assets; asset++ {
+		_, π := ls.openForClient(verticesAlongThePath[k], asset*(ls.pp.Fanout+1)+int(path[k]))
+		proof.AssetValueProofs = append(proof.AssetValueProofs, π)
+	}
+
+	return proof, nil
+}
+
+// Verify checks that the id is not in the liability set with the given root V and W.
+// Its errors are classified like the errors of LiabilityProof.Verify.
+func (ap AbsenceProof) Verify(publicParams *PublicParams, id string, V, W *math.G1, id2path func(string) ([]uint16, error)) error {
+	path, err := id2path(id)
+	if err != nil {
+		return err
+	}
+
+	if len(ap.V) == 0 || len(ap.V) > len(path) {
+		return malformed("expected between 1 and %d vertices but got %d", len(path), len(ap.V))
+	}
+	if ap.V.HasNil() || ap.W.HasNil() {
+		return malformed("missing vertex commitments")
+	}
+
+	// Only the vertex in the layer above the leaves has no W
+	k := len(ap.V) - 1
+	expectedDigestNum := len(ap.V)
+	if k == len(path)-1 {
+		expectedDigestNum--
+	}
+	if len(ap.W) != expectedDigestNum {
+		return malformed("expected %d digest commitments but got %d", expectedDigestNum, len(ap.W))
+	}
+	if len(ap.W) == 0 {
+		return malformed("missing root W")
+	}
+
+	// Check that the root is what is advertised.
+	if !ap.V[0].Equals(V) {
+		return invalid("root V does not match public known V value")
+	}
+	if !ap.W[0].Equals(W) {
+		return invalid("root W does not match public known W value")
+	}
+
+	// Each vertex is committed to by its parent, and the missing slot has a zero digest.
+	var digests common.Vec
+	for i := 1; i < len(ap.V); i++ {
+		h := sha256.New()
+		h.Write(ap.V[i].Bytes())
+		if i < len(ap.W) {
+			h.Write(ap.W[i].Bytes())
+		}
+		digests = append(digests, common.FieldElementFromBytes(h.Sum(nil)))
+	}
+	if len(ap.W) > k {
+		digests = append(digests, common.IntToZr(0))
+	}
+
+	var tPP common.Vec
+	for i := 0; i < len(ap.W); i++ {
+		tPP = append(tPP, pp.RO(publicParams.PPPP, ap.W, i))
+	}
+
+	if ap.DigestProof == nil {
+		return malformed("missing digest proof")
+	}
+
+	Σ := digests.InnerProd(tPP)
+	if err := pp.VerifyAggregation(publicParams.PPPP, uint16VecToIntVec(path)[:len(ap.W)], ap.W, ap.DigestProof, Σ, pp.RO); err != nil {
+		return verificationFailed(err, "hash chain aggregation proof invalid")
+	}
+
+	if ap.ValueProof == nil {
+		return malformed("missing value proof")
+	}
+
+	if err := pp.Verify(publicParams.PPPP, common.IntToZr(0), ap.ValueProof, ap.V[k], int(path[k])); err != nil {
+		return verificationFailed(err, "missing slot is not zero")
+	}
+
+	if len(ap.AssetValueProofs) != publicParams.Assets-1 {
+		return malformed("expected value proofs of %d assets but got %d", publicParams.Assets, len(ap.AssetValueProofs)+1)
+	}
+
+	for i, π := range ap.AssetValueProofs {
+		index := (i+1)*(publicParams.Fanout+1) + int(path[k])
+		if π == nil {
+			return malformed("missing value proof of asset %d", i+1)
+		}
+		if err := pp.Verify(publicParams.PPPP, common.IntToZr(0), π, ap.V[k], index); err != nil {
+			return verificationFailed(err, fmt.Sprintf("missing slot of asset %d is not zero", i+1))
+		}
+	}
+
+	return nil
 }
 
 func uint16VecToIntVec(in []uint16) []int {
@@ -471,28 +1511,89 @@ func uint16VecToIntVec(in []uint16) []int {
 	return res
 }
 
+// DBMemorizeRoot is a DB that memorizes the root of the tree, which is the value of the empty key.
+// It is safe for concurrent use if the underlying DB is.
 type DBMemorizeRoot struct {
 	DB   verkle.DB
+	lock sync.Mutex
 	root []byte
 }
 
-func (db *DBMemorizeRoot) Get(key []byte) []byte {
-	if len(key) == 0 && len(db.root) != 0 {
-		return db.root
+func (db *DBMemorizeRoot) Get(key []byte) ([]byte, error) {
+	if len(key) != 0 {
+		return db.DB.Get(key)
 	}
 
-	val := db.DB.Get(key)
+	// The root is read from the DB under the lock, so that a root committed meanwhile is not overwritten by an older one
+	db.lock.Lock()
+	defer db.lock.Unlock()
 
-	if len(key) == 0 {
-		db.root = val
+	if len(db.root) != 0 {
+		return db.root, nil
+	}
+
+	val, err := db.DB.Get(key)
+	if err != nil {
+		return nil, err
 	}
+	db.root = val
 
-	return val
+	return val, nil
 }
 
-func (db *DBMemorizeRoot) Put(key []byte, val []byte) {
+func (db *DBMemorizeRoot) Put(key []byte, val []byte) error {
+	b := db.NewBatch()
+	b.Put(key, val)
+	return b.Commit()
+}
+
+func (db *DBMemorizeRoot) Delete(key []byte) error {
+	b := db.NewBatch()
+	b.Delete(key)
+	return b.Commit()
+}
+
+func (db *DBMemorizeRoot) NewBatch() verkle.Batch {
+	return &memorizingBatch{db: db, Batch: db.DB.NewBatch()}
+}
+
+func (db *DBMemorizeRoot) Iterate(prefix []byte, f func(key, val []byte) error) error {
+	return db.DB.Iterate(prefix, f)
+}
+
+// memorizingBatch memorizes the root it writes once it is committed, so that a batch that fails does not change the root.
+type memorizingBatch struct {
+	verkle.Batch
+	db          *DBMemorizeRoot
+	root        []byte
+	rootWritten bool
+}
+
+func (b *memorizingBatch) Put(key []byte, val []byte) {
+	if len(key) == 0 {
+		b.root, b.rootWritten = val, true
+	}
+	b.Batch.Put(key, val)
+}
+
+func (b *memorizingBatch) Delete(key []byte) {
 	if len(key) == 0 {
-		db.root = val
+		b.root, b.rootWritten = nil, true
 	}
-	db.DB.Put(key, val)
+	b.Batch.Delete(key)
+}
+
+func (b *memorizingBatch) Commit() error {
+	if !b.rootWritten {
+		return b.Batch.Commit()
+	}
+
+	b.db.lock.Lock()
+	defer b.db.lock.Unlock()
+
+	if err := b.Batch.Commit(); err != nil {
+		return err
+	}
+	b.db.root = b.root
+	return nil
 }
@@ -1,107 +1,1165 @@
 package pol
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"pol/bp"
+	"pol/ceremony"
+	"pol/common"
+	"pol/kv"
+	"pol/poe"
+	"pol/pp"
 	"pol/sparse"
+	"pol/sum"
+	"pol/verkle"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	math "github.com/IBM/mathlib"
 	"github.com/stretchr/testify/assert"
 )
 
-type MemDB map[string][]byte
-
-func (m MemDB) Get(key []byte) []byte {
-	return m[string(key)]
-}
-
-func (m MemDB) Put(key []byte, val []byte) {
-	m[string(key)] = val
-}
-
 func TestPolSparse(t *testing.T) {
 	fanout := uint16(7)
-	id2Path, pp := GeneratePublicParams(fanout, Sparse)
+	id2Path, pp := generatePublicParams(t, fanout, Sparse)
 
-	ls := NewLiabilitySet(pp, make(MemDB), id2Path)
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
 
 	idBuff := make([]byte, 32)
 	rand.Read(idBuff)
 
 	id := hex.EncodeToString(idBuff)
 
-	ls.Set(id, 100)
+	assert.NoError(t, ls.Set(id, big.NewInt(100)))
 
 	idBuff = make([]byte, 32)
 	rand.Read(idBuff)
 	id = hex.EncodeToString(idBuff)
-	ls.Set(id, 101)
+	assert.NoError(t, ls.Set(id, big.NewInt(101)))
 
 	t1 := time.Now()
-	hundred, proof, _, ok := ls.ProveLiability(id)
+	hundred, proof, _, err := ls.ProveLiability(id)
 	fmt.Println("Proof time:", time.Since(t1))
 
 	vRoot, wRoot := ls.Root()
 
-	assert.Equal(t, int64(101), hundred)
-	assert.True(t, ok)
+	assert.Equal(t, int64(101), hundred.Int64())
+	assert.NoError(t, err)
 	t1 = time.Now()
-	_, err := proof.Verify(pp, id, vRoot, wRoot, id2Path)
+	_, err = proof.Verify(pp, id, vRoot, wRoot, id2Path)
 	fmt.Println("Verification time:", time.Since(t1))
 	assert.NoError(t, err)
 }
 
 func TestPolDense(t *testing.T) {
 	fanout := uint16(7)
-	id2Path, pp := GeneratePublicParams(fanout, Dense)
+	id2Path, pp := generatePublicParams(t, fanout, Dense)
 
-	ls := NewLiabilitySet(pp, make(MemDB), id2Path)
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
 
 	id := "987654321"
 
-	ls.Set(id, 100)
+	assert.NoError(t, ls.Set(id, big.NewInt(100)))
 
 	t1 := time.Now()
-	hundred, proof, _, ok := ls.ProveLiability(id)
+	hundred, proof, _, err := ls.ProveLiability(id)
 	fmt.Println("Proof time:", time.Since(t1))
 
 	vRoot, wRoot := ls.Root()
 
-	assert.Equal(t, int64(100), hundred)
-	assert.True(t, ok)
+	assert.Equal(t, int64(100), hundred.Int64())
+	assert.NoError(t, err)
+	digitPath, err := sparse.DigitPath(fanout)
+	assert.NoError(t, err)
 	t1 = time.Now()
-	_, err := proof.Verify(pp, id, vRoot, wRoot, sparse.DigitPath(fanout))
+	_, err = proof.Verify(pp, id, vRoot, wRoot, digitPath)
 	fmt.Println("Verification time:", time.Since(t1))
 	assert.NoError(t, err)
 }
 
 func TestProveTot(t *testing.T) {
 	fanout := uint16(7)
-	id2Path, pp := GeneratePublicParams(fanout, Sparse)
+	id2Path, pp := generatePublicParams(t, fanout, Sparse)
 
-	ls := NewLiabilitySet(pp, make(MemDB), id2Path)
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
 
 	idBuff := make([]byte, 32)
 	rand.Read(idBuff)
 
 	id := hex.EncodeToString(idBuff)
 
-	ls.Set(id, 50)
+	assert.NoError(t, ls.Set(id, big.NewInt(50)))
 
 	rand.Read(idBuff)
 	id = hex.EncodeToString(idBuff)
-	ls.Set(id, 50)
+	assert.NoError(t, ls.Set(id, big.NewInt(50)))
 
 	t1 := time.Now()
-	totProof := ls.ProveTot()
+	totProof, err := ls.ProveTot()
+	assert.NoError(t, err)
 	fmt.Println(time.Since(t1))
 	t1 = time.Now()
 
 	V, _ := ls.Root()
 
-	err := totProof.Verify(pp, V)
+	err = totProof.Verify(pp, V)
 	fmt.Println(time.Since(t1))
 	assert.NoError(t, err)
 }
+
+func TestLiabilityProofEncoding(t *testing.T) {
+	fanout := uint16(7)
+	id2Path, pp := generatePublicParams(t, fanout, Dense)
+
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+
+	id := "123456789"
+	assert.NoError(t, ls.Set(id, big.NewInt(100)))
+	assert.NoError(t, ls.Set("123456788", big.NewInt(200)))
+
+	_, proof, _, err := ls.ProveLiability(id)
+	assert.NoError(t, err)
+
+	raw, err := proof.MarshalBinary()
+	assert.NoError(t, err)
+
+	var decoded LiabilityProof
+	assert.NoError(t, decoded.UnmarshalBinary(raw))
+
+	vRoot, wRoot := ls.Root()
+	_, err = decoded.Verify(pp, id, vRoot, wRoot, id2Path)
+	assert.NoError(t, err)
+
+	raw2, err := decoded.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, raw, raw2)
+
+	assert.Error(t, decoded.UnmarshalBinary(append(raw, 0)))
+	assert.Error(t, decoded.UnmarshalBinary(raw[:len(raw)-1]))
+
+	totProof, err := ls.ProveTot()
+	assert.NoError(t, err)
+	raw, err = totProof.MarshalBinary()
+	assert.NoError(t, err)
+
+	var decodedTot TotalProof
+	assert.NoError(t, decodedTot.UnmarshalBinary(raw))
+	assert.Equal(t, int64(300), decodedTot.Sum.Int64())
+	assert.NoError(t, decodedTot.Verify(pp, vRoot))
+}
+
+var update = flag.Bool("update", false, "generate the golden proofs of the current encoding version in testdata")
+
+// goldenDir holds the golden proofs of the current encoding version.
+// The golden proofs of previous versions are kept next to it, to make sure they are rejected.
+var goldenDir = filepath.Join("testdata", fmt.Sprintf("v%d", common.EncodingVersion))
+
+func TestLiabilityProofJSON(t *testing.T) {
+	fanout := uint16(7)
+	id2Path, pp := generatePublicParams(t, fanout, Dense)
+
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+
+	id := "123456789"
+	assert.NoError(t, ls.Set(id, big.NewInt(100)))
+	assert.NoError(t, ls.Set("123456788", big.NewInt(200)))
+
+	_, proof, _, err := ls.ProveLiability(id)
+	assert.NoError(t, err)
+
+	totProof, err := ls.ProveTot()
+	assert.NoError(t, err)
+
+	rawProof, err := json.MarshalIndent(proof, "", "  ")
+	assert.NoError(t, err)
+	rawTotProof, err := json.MarshalIndent(totProof, "", "  ")
+	assert.NoError(t, err)
+
+	if *update {
+		// Golden proofs are never regenerated in place, a change that breaks them bumps common.EncodingVersion
+		if _, err := os.Stat(goldenDir); err == nil {
+			t.Fatalf("golden proofs of encoding version %d already exist in %s", common.EncodingVersion, goldenDir)
+		}
+		assert.NoError(t, os.Mkdir(goldenDir, 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(goldenDir, "liability_proof.json"), append(rawProof, '\n'), 0644))
+		assert.NoError(t, os.WriteFile(filepath.Join(goldenDir, "total_proof.json"), append(rawTotProof, '\n'), 0644))
+		writeGoldenParams(t, pp, ls, id)
+	}
+
+	var decoded LiabilityProof
+	assert.NoError(t, json.Unmarshal(rawProof, &decoded))
+
+	vRoot, wRoot := ls.Root()
+	_, err = decoded.Verify(pp, id, vRoot, wRoot, id2Path)
+	assert.NoError(t, err)
+
+	var decodedTot TotalProof
+	assert.NoError(t, json.Unmarshal(rawTotProof, &decodedTot))
+	assert.Equal(t, int64(300), decodedTot.Sum.Int64())
+	assert.NoError(t, decodedTot.Verify(pp, vRoot))
+
+	assert.Error(t, json.Unmarshal([]byte(fmt.Sprintf(`{"version":%d,"liabilityProof":"00","sum":"1"}`, common.EncodingVersion)), &decodedTot))
+	assert.Error(t, json.Unmarshal(bytes.Replace(rawTotProof, []byte(`"sum"`), []byte(`"extra":0,"sum"`), 1), &decodedTot))
+	assert.Error(t, json.Unmarshal(bytes.Replace(rawTotProof, []byte(`"sum": "300"`), []byte(`"sum": "0300"`), 1), &decodedTot))
+	version := fmt.Sprintf(`"version": %d`, common.EncodingVersion)
+	assert.Error(t, json.Unmarshal(bytes.Replace(rawTotProof, []byte(version), []byte(`"version": 0`), 1), &decodedTot))
+}
+
+// goldenRoot is the root of the liability set the golden proofs were produced from
+type goldenRoot struct {
+	ID string `json:"id"`
+	V  string `json:"v"`
+	W  string `json:"w"`
+}
+
+func writeGoldenParams(t *testing.T, pp *PublicParams, ls *LiabilitySet, id string) {
+	f, err := os.Create(filepath.Join(goldenDir, "public_params.bin"))
+	assert.NoError(t, err)
+	_, err = pp.WriteTo(f)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	var e common.HexEncoder
+	V, W := ls.Root()
+	raw, err := json.MarshalIndent(goldenRoot{ID: id, V: e.G1(V), W: e.G1(W)}, "", "  ")
+	assert.NoError(t, err)
+	assert.NoError(t, e.Err())
+	assert.NoError(t, os.WriteFile(filepath.Join(goldenDir, "root.json"), append(raw, '\n'), 0644))
+}
+
+func TestGoldenProofs(t *testing.T) {
+	schema := loadJSON(t, filepath.Join("..", "schema", "proofs.schema.json"))
+
+	f, err := os.Open(filepath.Join(goldenDir, "public_params.bin"))
+	assert.NoError(t, err)
+	defer f.Close()
+	id2Path, pp, err := ReadPublicParams(f)
+	assert.NoError(t, err)
+
+	var root goldenRoot
+	raw, err := os.ReadFile(filepath.Join(goldenDir, "root.json"))
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(raw, &root))
+	var d common.HexDecoder
+	V, W := d.G1(root.V), d.G1(root.W)
+	assert.NoError(t, d.Err())
+
+	var liabilityProof LiabilityProof
+	var totalProof TotalProof
+
+	for _, tst := range []struct {
+		file   string
+		proof  json.Unmarshaler
+		verify func() error
+	}{
+		{"liability_proof.json", &liabilityProof, func() error {
+			_, err := liabilityProof.Verify(pp, root.ID, V, W, id2Path)
+			return err
+		}},
+		{"total_proof.json", &totalProof, func() error {
+			return totalProof.Verify(pp, V)
+		}},
+	} {
+		t.Run(tst.file, func(t *testing.T) {
+			golden, err := os.ReadFile(filepath.Join(goldenDir, tst.file))
+			assert.NoError(t, err)
+
+			assert.NoError(t, validateSchema(schema, schema, loadJSON(t, filepath.Join(goldenDir, tst.file))))
+
+			assert.NoError(t, json.Unmarshal(golden, tst.proof))
+			reencoded, err := json.MarshalIndent(tst.proof, "", "  ")
+			assert.NoError(t, err)
+			assert.Equal(t, string(golden), string(reencoded)+"\n")
+
+			assert.NoError(t, tst.verify())
+		})
+	}
+}
+
+// TestGoldenProofsOfPreviousVersions makes sure that proofs of a previous encoding version,
+// which do not verify anymore, are rejected for their version.
+func TestGoldenProofsOfPreviousVersions(t *testing.T) {
+	for version := byte(1); version < common.EncodingVersion; version++ {
+		dir := filepath.Join("testdata", fmt.Sprintf("v%d", version))
+		unsupported := fmt.Sprintf("unsupported encoding version %d", version)
+
+		f, err := os.Open(filepath.Join(dir, "public_params.bin"))
+		assert.NoError(t, err)
+		_, _, err = ReadPublicParams(f)
+		assert.NoError(t, f.Close())
+		assert.ErrorContains(t, err, unsupported)
+
+		for _, tst := range []struct {
+			file  string
+			proof json.Unmarshaler
+		}{
+			{"liability_proof.json", &LiabilityProof{}},
+			{"total_proof.json", &TotalProof{}},
+		} {
+			golden, err := os.ReadFile(filepath.Join(dir, tst.file))
+			assert.NoError(t, err)
+			assert.EqualError(t, json.Unmarshal(golden, tst.proof), unsupported, "%s of version %d", tst.file, version)
+		}
+	}
+}
+
+func loadJSON(t *testing.T, path string) interface{} {
+	raw, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var v interface{}
+	assert.NoError(t, json.Unmarshal(raw, &v))
+	return v
+}
+
+// validateSchema checks v against the subset of JSON Schema used by proofs.schema.json
+func validateSchema(root, schema, v interface{}) error {
+	s := schema.(map[string]interface{})
+
+	if ref, ok := s["$ref"].(string); ok {
+		def := root.(map[string]interface{})["$defs"].(map[string]interface{})[strings.TrimPrefix(ref, "#/$defs/")]
+		return validateSchema(root, def, v)
+	}
+
+	if oneOf, ok := s["oneOf"].([]interface{}); ok {
+		var matches int
+		for _, option := range oneOf {
+			if validateSchema(root, option, v) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%d schemas in oneOf match", matches)
+		}
+		return nil
+	}
+
+	if expected, ok := s["const"]; ok && expected != v {
+		return fmt.Errorf("expected %v but got %v", expected, v)
+	}
+
+	switch s["type"] {
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%v is not a string", v)
+		}
+		if pattern, ok := s["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(str) {
+			return fmt.Errorf("%s does not match %s", str, pattern)
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%v is not an array", v)
+		}
+		if min, ok := s["minItems"].(float64); ok && len(arr) < int(min) {
+			return fmt.Errorf("array has less than %v items", min)
+		}
+		if max, ok := s["maxItems"].(float64); ok && len(arr) > int(max) {
+			return fmt.Errorf("array has more than %v items", max)
+		}
+		for _, item := range arr {
+			if err := validateSchema(root, s["items"], item); err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v is not an object", v)
+		}
+		properties := s["properties"].(map[string]interface{})
+		for _, required := range s["required"].([]interface{}) {
+			if _, exists := obj[required.(string)]; !exists {
+				return fmt.Errorf("missing property %s", required)
+			}
+		}
+		for key, val := range obj {
+			property, exists := properties[key]
+			if !exists {
+				return fmt.Errorf("unexpected property %s", key)
+			}
+			if err := validateSchema(root, property, val); err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func TestPublicParamsPersistence(t *testing.T) {
+	fanout := uint16(7)
+	_, pp := generatePublicParams(t, fanout, Dense)
+
+	var buff bytes.Buffer
+	_, err := pp.WriteTo(&buff)
+	assert.NoError(t, err)
+	raw := buff.Bytes()
+
+	id2Path, loaded, err := ReadPublicParams(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, Dense, loaded.TreeType)
+	assert.Equal(t, int(fanout), loaded.Fanout)
+
+	digest, err := pp.Digest()
+	assert.NoError(t, err)
+	loadedDigest, err := loaded.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, digest, loadedDigest)
+
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+	id := "123456789"
+	assert.NoError(t, ls.Set(id, big.NewInt(100)))
+
+	_, proof, _, err := ls.ProveLiability(id)
+	assert.NoError(t, err)
+
+	vRoot, wRoot := ls.Root()
+	_, err = proof.Verify(loaded, id, vRoot, wRoot, id2Path)
+	assert.NoError(t, err)
+	totProof, err := ls.ProveTot()
+	assert.NoError(t, err)
+	assert.NoError(t, totProof.Verify(loaded, vRoot))
+
+	t.Run("corrupted", func(t *testing.T) {
+		corrupted := append([]byte{}, raw...)
+		corrupted[len(corrupted)/2] ^= 1
+		_, _, err := ReadPublicParams(bytes.NewReader(corrupted))
+		assert.EqualError(t, err, "digest mismatch")
+	})
+
+	t.Run("tree type", func(t *testing.T) {
+		_, sparsePP := generatePublicParams(t, fanout, Sparse)
+		sparseDigest, err := sparsePP.Digest()
+		assert.NoError(t, err)
+		assert.NotEqual(t, digest, sparseDigest)
+
+		sparsePP.POEPP, sparsePP.PPPP = pp.POEPP, pp.PPPP
+		sparsePP.TreeType = Dense
+		sparseDigest, err = sparsePP.Digest()
+		assert.NoError(t, err)
+		assert.NotEqual(t, digest, sparseDigest)
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		G1s := pp.PPPP.G1s
+		defer func() {
+			pp.PPPP.G1s = G1s
+		}()
+
+		pp.PPPP.G1s = append(common.G1v{}, G1s...)
+		pp.PPPP.G1s[int(fanout)+3] = pp.PPPP.G1s[int(fanout)+4]
+
+		var buff bytes.Buffer
+		_, err := pp.WriteTo(&buff)
+		assert.NoError(t, err)
+		_, _, err = ReadPublicParams(&buff)
+		assert.EqualError(t, err, "G1 elements are not consecutive powers of α")
+	})
+}
+
+func TestPublicParamsFromCeremony(t *testing.T) {
+	fanout := uint16(7)
+
+	pointProofsPP := runCeremony(t, int(fanout+2))
+
+	_, _, err := GeneratePublicParamsFromPP(fanout+8, Dense, 1, pointProofsPP)
+	assert.EqualError(t, err, "a fanout of 15 with 1 assets requires vector commitments of size 17 but they are of size 9")
+
+	id2Path, pp, err := GeneratePublicParamsFromPP(fanout, Dense, 1, pointProofsPP)
+	assert.NoError(t, err)
+
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+	id := "123456789"
+	assert.NoError(t, ls.Set(id, big.NewInt(100)))
+
+	_, proof, _, err := ls.ProveLiability(id)
+	assert.NoError(t, err)
+
+	vRoot, wRoot := ls.Root()
+	_, err = proof.Verify(pp, id, vRoot, wRoot, id2Path)
+	assert.NoError(t, err)
+
+	// Three assets are padded to four slots per descendant
+	pointProofsPP = runCeremony(t, 4*int(fanout+1)+1)
+
+	_, _, err = GeneratePublicParamsFromPP(fanout, Dense, 1, pointProofsPP)
+	assert.EqualError(t, err, "a fanout of 7 with 1 assets requires vector commitments of size 9 but they are of size 33")
+
+	id2Path, pp, err = GeneratePublicParamsFromPP(fanout, Dense, 3, pointProofsPP)
+	assert.NoError(t, err)
+
+	ls = NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+	assert.NoError(t, ls.SetBalances(id, balancesOf(100, 0, 300)))
+
+	balances, proof, _, err := ls.ProveBalances(id)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{100, 0, 300}, int64s(balances))
+
+	vRoot, wRoot = ls.Root()
+	_, err = proof.Verify(pp, id, vRoot, wRoot, id2Path)
+	assert.NoError(t, err)
+}
+
+func runCeremony(t *testing.T, N int) *pp.PP {
+	transcript := ceremony.NewTranscript(N)
+	for i := 0; i < 2; i++ {
+		contribution, err := ceremony.Contribute(transcript.Current(), rand.Reader)
+		assert.NoError(t, err)
+		assert.NoError(t, transcript.Add(contribution))
+	}
+
+	pointProofsPP, err := transcript.Result()
+	assert.NoError(t, err)
+	return pointProofsPP
+}
+
+func TestOpenLiabilitySet(t *testing.T) {
+	fanout := uint16(7)
+	id2Path, pp := generatePublicParams(t, fanout, Dense)
+
+	db := make(kv.MemDB)
+	ls := NewLiabilitySet(pp, db, id2Path)
+	assert.NoError(t, ls.Set("123456789", big.NewInt(100)))
+	assert.NoError(t, ls.Set("923456789", big.NewInt(200)))
+
+	reopened, err := OpenLiabilitySet(pp, db, id2Path)
+	assert.NoError(t, err)
+
+	liability, err := reopened.Get("923456789")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(200), liability.Int64())
+
+	V, W := ls.Root()
+	reopenedV, reopenedW := reopened.Root()
+	assert.True(t, V.Equals(reopenedV))
+	assert.True(t, W.Equals(reopenedW))
+
+	id := "987654321"
+	assert.NoError(t, reopened.Set(id, big.NewInt(300)))
+
+	_, proof, _, err := reopened.ProveLiability(id)
+	assert.NoError(t, err)
+
+	vRoot, wRoot := reopened.Root()
+	_, err = proof.Verify(pp, id, vRoot, wRoot, id2Path)
+	assert.NoError(t, err)
+
+	totProof, err := reopened.ProveTot()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(600), totProof.Sum.Int64())
+	assert.NoError(t, totProof.Verify(pp, vRoot))
+
+	empty, err := OpenLiabilitySet(pp, make(kv.MemDB), id2Path)
+	assert.NoError(t, err)
+	_, err = empty.Get(id)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = empty.ProveTot()
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestDelete(t *testing.T) {
+	fanout := uint16(7)
+	id2Path, pp := generatePublicParams(t, fanout, Dense)
+
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+
+	closed, open := "282475250", "564950499"
+	assert.NoError(t, ls.Set(closed, big.NewInt(100)))
+	assert.NoError(t, ls.Set(open, big.NewInt(200)))
+	assert.NoError(t, ls.Set("987654321", big.NewInt(300)))
+
+	assert.NoError(t, ls.Delete(closed))
+	assert.True(t, errors.Is(ls.Delete(closed), ErrNotFound))
+
+	_, err := ls.Get(closed)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, _, _, err = ls.ProveLiability(closed)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	_, proof, _, err := ls.ProveLiability(open)
+	assert.NoError(t, err)
+
+	vRoot, wRoot := ls.Root()
+	_, err = proof.Verify(pp, open, vRoot, wRoot, id2Path)
+	assert.NoError(t, err)
+
+	totProof, err := ls.ProveTot()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(500), totProof.Sum.Int64())
+	assert.NoError(t, totProof.Verify(pp, vRoot))
+}
+
+func TestBuildFromIterator(t *testing.T) {
+	fanout := uint16(7)
+	id2Path, pp := generatePublicParams(t, fanout, Dense)
+
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+	assert.NoError(t, ls.Set("987654321", big.NewInt(1)))
+
+	ids := []string{"123456789", "282475250", "564950499", "987654321", "123456789"}
+	var i int
+	assert.NoError(t, ls.BuildFromIterator(func() (string, *big.Int, bool) {
+		if i == len(ids) {
+			return "", nil, false
+		}
+		i++
+		return ids[i-1], big.NewInt(int64(i * 100)), true
+	}))
+
+	liability, err := ls.Get("123456789")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(500), liability.Int64())
+
+	id := "987654321"
+	liability, err = ls.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(400), liability.Int64())
+
+	_, proof, _, err := ls.ProveLiability(id)
+	assert.NoError(t, err)
+
+	vRoot, wRoot := ls.Root()
+	_, err = proof.Verify(pp, id, vRoot, wRoot, id2Path)
+	assert.NoError(t, err)
+
+	totProof, err := ls.ProveTot()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(500+200+300+400), totProof.Sum.Int64())
+	assert.NoError(t, totProof.Verify(pp, vRoot))
+}
+
+func TestProveAbsence(t *testing.T) {
+	fanout := uint16(7)
+	id2Path, pp := generatePublicParams(t, fanout, Dense)
+
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+
+	_, err := ls.ProveAbsence("282475250")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	assert.NoError(t, ls.Set("282475250", big.NewInt(100)))
+	assert.NoError(t, ls.Set("987654321", big.NewInt(300)))
+
+	vRoot, wRoot := ls.Root()
+
+	_, err = ls.ProveAbsence("282475250")
+	assert.Error(t, err)
+
+	// The first id shares all vertices with an id in the set except for the leaf,
+	// and the second one diverges from all ids in the set near the root.
+	for _, id := range []string{"564950499", "123456789"} {
+		proof, err := ls.ProveAbsence(id)
+		assert.NoError(t, err)
+		assert.NoError(t, proof.Verify(pp, id, vRoot, wRoot, id2Path))
+
+		// The proof is only valid for the id it was created for
+		assert.Error(t, proof.Verify(pp, "282475250", vRoot, wRoot, id2Path))
+	}
+
+	proof, _ := ls.ProveAbsence("564950499")
+	assert.Len(t, proof.V, len(pathOf(t, id2Path, "564950499")))
+	assert.Len(t, proof.W, len(proof.V)-1)
+
+	proof, _ = ls.ProveAbsence("123456789")
+	assert.Less(t, len(proof.V), len(pathOf(t, id2Path, "123456789"))-1)
+	assert.Len(t, proof.W, len(proof.V))
+
+	// A proof that does not open a zero value is rejected
+	proof.ValueProof = proof.DigestProof
+	assert.Error(t, proof.Verify(pp, "123456789", vRoot, wRoot, id2Path))
+
+	// Once the id is added, the proof no longer verifies against the new root
+	proof, _ = ls.ProveAbsence("123456789")
+	assert.NoError(t, ls.Set("123456789", big.NewInt(50)))
+	vRoot, wRoot = ls.Root()
+	assert.Error(t, proof.Verify(pp, "123456789", vRoot, wRoot, id2Path))
+}
+
+func TestMultiAsset(t *testing.T) {
+	fanout := uint16(7)
+	_, pp, err := GenerateMultiAssetPublicParams(fanout, Dense, 2)
+	assert.NoError(t, err)
+
+	// The public parameters are persisted along with the number of assets
+	var buff bytes.Buffer
+	_, err = pp.WriteTo(&buff)
+	assert.NoError(t, err)
+	id2Path, loaded, err := ReadPublicParams(&buff)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, loaded.Assets)
+
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+	assert.NoError(t, ls.SetBalances("282475250", balancesOf(1, 2)))
+	assert.NoError(t, ls.SetBatchBalances(map[string][]*big.Int{
+		"564950499": balancesOf(10, 30),
+		"987654321": balancesOf(100),
+	}))
+
+	balances, err := ls.GetBalances("987654321")
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{100, 0}, int64s(balances))
+
+	assert.True(t, errors.Is(ls.SetBalances("282475250", balancesOf(1, 2, 3)), ErrInvalidLiability))
+	assert.True(t, errors.Is(ls.SetBalances("282475250", balancesOf(1, -2)), ErrInvalidLiability))
+
+	vRoot, wRoot := ls.Root()
+
+	totals, err := ls.ProveTotals()
+	assert.NoError(t, err)
+	assert.Len(t, totals, 2)
+	for asset, expected := range []int64{111, 32} {
+		assert.Equal(t, expected, totals[asset].Sum.Int64())
+		assert.NoError(t, totals[asset].VerifyAsset(loaded, vRoot, asset))
+	}
+	assert.Error(t, totals[1].VerifyAsset(loaded, vRoot, 0))
+	assert.Error(t, totals[0].VerifyAsset(loaded, vRoot, 2))
+
+	id := "564950499"
+	balances, proof, _, err := ls.ProveBalances(id)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{10, 30}, int64s(balances))
+	assert.Equal(t, int64(10), proof.LiabilityProof.Sum.Int64())
+	assert.Len(t, proof.AssetProofs, 1)
+	assert.Equal(t, int64(30), proof.AssetProofs[0].Sum.Int64())
+
+	_, err = proof.Verify(loaded, id, vRoot, wRoot, id2Path)
+	assert.NoError(t, err)
+
+	rawJSON, err := json.Marshal(proof)
+	assert.NoError(t, err)
+	schema := loadJSON(t, filepath.Join("..", "schema", "proofs.schema.json"))
+	var decodedJSON interface{}
+	assert.NoError(t, json.Unmarshal(rawJSON, &decodedJSON))
+	assert.NoError(t, validateSchema(schema, schema, decodedJSON))
+
+	raw, err := proof.MarshalBinary()
+	assert.NoError(t, err)
+	var decoded LiabilityProof
+	assert.NoError(t, decoded.UnmarshalBinary(raw))
+	assert.Equal(t, proof.AssetProofs, decoded.AssetProofs)
+
+	// A balance of an asset other than the first cannot be changed
+	decoded.AssetProofs[0].Sum = big.NewInt(31)
+	_, err = decoded.Verify(loaded, id, vRoot, wRoot, id2Path)
+	assert.Error(t, err)
+
+	absence, err := ls.ProveAbsence("282475251")
+	assert.NoError(t, err)
+	assert.Len(t, absence.AssetValueProofs, 1)
+	assert.NoError(t, absence.Verify(loaded, "282475251", vRoot, wRoot, id2Path))
+
+	// The number of assets is padded to a power of two in the vectors, but not in the proofs
+	_, pp, err = GenerateMultiAssetPublicParams(fanout, Dense, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 4*int(fanout+1)+1, pp.PPPP.N)
+
+	ls = NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+	assert.NoError(t, ls.SetBalances(id, balancesOf(1, 2, 3)))
+	balances, err = ls.GetBalances(id)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 2, 3}, int64s(balances))
+
+	vRoot, _ = ls.Root()
+	totals, err = ls.ProveTotals()
+	assert.NoError(t, err)
+	assert.Len(t, totals, 3)
+	for asset, total := range totals {
+		assert.Equal(t, int64(asset+1), total.Sum.Int64())
+		assert.NoError(t, total.VerifyAsset(pp, vRoot, asset))
+	}
+}
+
+func TestBitWidth(t *testing.T) {
+	fanout := uint16(3)
+	id2Path, pp, err := GenerateMultiAssetPublicParamsWithBits(fanout, Dense, 1, 127)
+	assert.NoError(t, err)
+	assert.Equal(t, 127, pp.RPPP.Bits)
+
+	_, _, err = GenerateMultiAssetPublicParamsWithBits(fanout, Dense, 1, 100)
+	assert.Error(t, err)
+
+	// The bit width is persisted along with the range proof parameters
+	var buff bytes.Buffer
+	_, err = pp.WriteTo(&buff)
+	assert.NoError(t, err)
+	_, loaded, err := ReadPublicParams(&buff)
+	assert.NoError(t, err)
+	assert.Equal(t, 127, loaded.RPPP.Bits)
+
+	// A balance of 10^24 base units overflows 63 bits
+	large, _ := new(big.Int).SetString("1000000000000000000000000", 10)
+
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+	assert.NoError(t, ls.Set("282475250", large))
+	assert.NoError(t, ls.Set("564950499", big.NewInt(1)))
+
+	liability, proof, _, err := ls.ProveLiability("282475250")
+	assert.NoError(t, err)
+	assert.Equal(t, large.String(), liability.String())
+
+	vRoot, wRoot := ls.Root()
+	_, err = proof.Verify(loaded, "282475250", vRoot, wRoot, id2Path)
+	assert.NoError(t, err)
+
+	total, err := ls.ProveTot()
+	assert.NoError(t, err)
+	assert.Equal(t, new(big.Int).Add(large, big.NewInt(1)).String(), total.Sum.String())
+	assert.NoError(t, total.Verify(loaded, vRoot))
+
+	assert.True(t, errors.Is(ls.Set("987654321", new(big.Int).Lsh(big.NewInt(1), 127)), ErrInvalidLiability))
+	assert.True(t, errors.Is(ls.Set("987654321", big.NewInt(-1)), ErrInvalidLiability))
+}
+
+func TestTotalsFitInBitWidth(t *testing.T) {
+	fanout := uint16(7)
+	id2Path, pp, err := GenerateMultiAssetPublicParamsWithBits(fanout, Dense, 2, 15)
+	assert.NoError(t, err)
+
+	max := big.NewInt(1<<15 - 1)
+
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+	assert.NoError(t, ls.Set("282475250", max))
+
+	// Both liabilities fit, but their sum in the root does not
+	err = ls.Set("987654321", max)
+	assert.True(t, errors.Is(err, ErrInvalidLiability))
+	assert.EqualError(t, err, "invalid liability: total of asset 0 would not fit in 15 bits")
+	assert.True(t, errors.Is(ls.SetBatch(map[string]*big.Int{"987654321": big.NewInt(1)}), ErrInvalidLiability))
+	assert.NoError(t, ls.SetBalances("987654321", balancesOf(0, 1<<14)))
+	assert.True(t, errors.Is(ls.SetBatchBalances(map[string][]*big.Int{"123456789": balancesOf(0, 1<<14)}), ErrInvalidLiability))
+
+	_, err = ls.Get("987654321")
+	assert.NoError(t, err)
+	_, err = ls.Get("123456789")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// Replacing a liability only counts the difference
+	assert.NoError(t, ls.Set("282475250", big.NewInt(1)))
+	assert.NoError(t, ls.Set("987654321", big.NewInt(1<<15-2)))
+
+	_, proof, _, err := ls.ProveLiability("987654321")
+	assert.NoError(t, err)
+	vRoot, wRoot := ls.Root()
+	_, err = proof.Verify(pp, "987654321", vRoot, wRoot, id2Path)
+	assert.NoError(t, err)
+}
+
+func TestMalformedInput(t *testing.T) {
+	_, _, err := GeneratePublicParams(6, Dense)
+	assert.True(t, errors.Is(err, ErrUnsupportedFanout))
+
+	fanout := uint16(7)
+	id2Path, pp := generatePublicParams(t, fanout, Dense)
+
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+	assert.True(t, errors.Is(ls.Set("12345678", big.NewInt(1)), ErrInvalidID))
+	assert.True(t, errors.Is(ls.Set("123456789", nil), ErrInvalidLiability))
+	assert.True(t, errors.Is(ls.SetBatch(map[string]*big.Int{"123456789": big.NewInt(1), "x": big.NewInt(2)}), ErrInvalidID))
+	_, err = ls.Get("123456789")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	id := "123456789"
+	assert.NoError(t, ls.Set(id, big.NewInt(100)))
+	assert.NoError(t, ls.Set("123456788", big.NewInt(200)))
+
+	_, proof, _, err := ls.ProveLiability(id)
+	assert.NoError(t, err)
+	raw, err := proof.MarshalBinary()
+	assert.NoError(t, err)
+
+	vRoot, wRoot := ls.Root()
+
+	_, err = proof.Verify(pp, "not an id", vRoot, wRoot, id2Path)
+	assert.True(t, errors.Is(err, ErrInvalidID))
+
+	// Every mutation is applied to a proof decoded anew
+	verify := func(mutate func(lp *LiabilityProof)) error {
+		var decoded LiabilityProof
+		assert.NoError(t, decoded.UnmarshalBinary(raw))
+		mutate(&decoded)
+		_, err := decoded.Verify(pp, id, vRoot, wRoot, id2Path)
+		return err
+	}
+
+	for name, mutate := range map[string]func(lp *LiabilityProof){
+		"truncated V":            func(lp *LiabilityProof) { lp.V = lp.V[:len(lp.V)-1] },
+		"missing vertex":         func(lp *LiabilityProof) { lp.V[1] = nil },
+		"truncated range proofs": func(lp *LiabilityProof) { lp.RangeProofs = lp.RangeProofs[1:] },
+		"empty range proof":      func(lp *LiabilityProof) { lp.RangeProofs[0] = &bp.RangeProof{} },
+		"missing sum":            func(lp *LiabilityProof) { lp.LiabilityProof.Sum = nil },
+		"missing sum argument":   func(lp *LiabilityProof) { lp.SumArgumentProof = nil },
+		"empty sum argument":     func(lp *LiabilityProof) { lp.SumArgumentProof = &sum.Proof{} },
+		"empty equality proof":   func(lp *LiabilityProof) { lp.EqualityProof = &poe.AggregatedProof{} },
+		"extra asset":            func(lp *LiabilityProof) { lp.AssetProofs = []TotalProof{lp.LiabilityProof} },
+		"missing digest proof":   func(lp *LiabilityProof) { lp.PointProofπ = nil },
+	} {
+		assert.True(t, errors.Is(verify(mutate), ErrMalformedProof), name)
+	}
+
+	for name, mutate := range map[string]func(lp *LiabilityProof){
+		"other liability": func(lp *LiabilityProof) { lp.LiabilityProof.Sum = big.NewInt(101) },
+		"other digest":    func(lp *LiabilityProof) { lp.Digests[0] = lp.Digests[1] },
+		"other root":      func(lp *LiabilityProof) { lp.V[0] = wRoot },
+	} {
+		err := verify(mutate)
+		assert.True(t, errors.Is(err, ErrInvalidProof), name)
+		assert.False(t, errors.Is(err, ErrMalformedProof), name)
+	}
+
+	absence, err := ls.ProveAbsence("987654321")
+	assert.NoError(t, err)
+	absence.V[0] = nil
+	assert.True(t, errors.Is(absence.Verify(pp, "987654321", vRoot, wRoot, id2Path), ErrMalformedProof))
+	assert.True(t, errors.Is(TotalProof{}.Verify(pp, vRoot), ErrMalformedProof))
+}
+
+func TestProveOverflowingSums(t *testing.T) {
+	fanout := uint16(7)
+	id2Path, pp, err := GenerateMultiAssetPublicParamsWithBits(fanout, Dense, 1, 15)
+	assert.NoError(t, err)
+
+	max := big.NewInt(1<<15 - 1)
+
+	// Set rejects the second liability, so write it to the tree directly,
+	// like a liability set persisted before the sums were checked
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+	assert.NoError(t, ls.Set("282475250", max))
+	assert.True(t, errors.Is(ls.Set("987654321", max), ErrInvalidLiability))
+	assert.NoError(t, ls.tree.Put("987654321", max))
+
+	defer func(parallelism bool) {
+		ParallelismEnabled = parallelism
+	}(ParallelismEnabled)
+
+	for _, parallelism := range []bool{true, false} {
+		ParallelismEnabled = parallelism
+
+		assert.NotPanics(t, func() {
+			_, _, _, err := ls.ProveLiability("282475250")
+			assert.True(t, errors.Is(err, ErrInvalidLiability), "parallelism: %v", parallelism)
+		})
+
+		assert.NotPanics(t, func() {
+			ls.ProveLiabilities([]string{"282475250", "987654321"}, 2, func(id string, _ *big.Int, _ LiabilityProof, err error) {
+				assert.True(t, errors.Is(err, ErrInvalidLiability), "parallelism: %v, id: %s", parallelism, id)
+			})
+		})
+	}
+}
+
+func TestConcurrentUpdatesAndProofs(t *testing.T) {
+	fanout := uint16(7)
+	id2Path, pp := generatePublicParams(t, fanout, Dense)
+
+	ls := NewLiabilitySet(pp, make(kv.MemDB), id2Path)
+	writerIDs := [][]string{{"282475250", "564950499"}, {"987654321", "123456789"}}
+	for _, ids := range writerIDs {
+		for _, id := range ids {
+			assert.NoError(t, ls.Set(id, big.NewInt(1)))
+		}
+	}
+	assert.NoError(t, ls.Snapshot(1))
+
+	updates := 20
+
+	// committed are the roots of the versions of the tree the writers committed.
+	// Writers take turns so that the root after every update is one of them,
+	// and are concurrent with the snapshots and the readers.
+	type root struct{ V, W *math.G1 }
+	var commitLock sync.Mutex
+	committed := make(map[string]root)
+	rootKey := func(V, W *math.G1) string {
+		return string(V.Bytes()) + string(W.Bytes())
+	}
+	commit := func(update func() error) {
+		commitLock.Lock()
+		defer commitLock.Unlock()
+		assert.NoError(t, update())
+		V, W := ls.Root()
+		committed[rootKey(V, W)] = root{V: V, W: W}
+	}
+	V, W := ls.Root()
+	committed[rootKey(V, W)] = root{V: V, W: W}
+
+	var writers, readers sync.WaitGroup
+	done := make(chan struct{})
+
+	// Every writer updates its own ids, and deletes and sets them again every few updates
+	for _, ids := range writerIDs {
+		writers.Add(1)
+		go func(ids []string) {
+			defer writers.Done()
+			for i := 1; i <= updates; i++ {
+				id := ids[i%len(ids)]
+				if i%5 == 0 {
+					commit(func() error { return ls.Delete(id) })
+				}
+				commit(func() error { return ls.Set(id, big.NewInt(int64(i))) })
+			}
+		}(ids)
+	}
+
+	writers.Add(1)
+	go func() {
+		defer writers.Done()
+		for epoch := uint64(2); epoch <= 4; epoch++ {
+			assert.NoError(t, ls.Snapshot(epoch))
+		}
+	}()
+
+	// repeat runs f until the writers are done, and at least once
+	repeat := func(f func()) {
+		defer readers.Done()
+		for {
+			f()
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}
+
+	// Every proof is of a single version of the tree, so it verifies against one of the committed roots.
+	// A proof may be of a version whose root is not recorded yet, so proofs are verified once the writers are done.
+	var proofsLock sync.Mutex
+	var verifications []func()
+	verifyLater := func(V, W *math.G1, verify func(V, W *math.G1) error) {
+		proofsLock.Lock()
+		defer proofsLock.Unlock()
+		verifications = append(verifications, func() {
+			r, exists := committed[rootKey(V, W)]
+			if !assert.True(t, exists, "proof of a root that was never committed") {
+				return
+			}
+			assert.NoError(t, verify(r.V, r.W))
+		})
+	}
+
+	readers.Add(3)
+	go repeat(func() {
+		liability, proof, _, err := ls.ProveLiability("987654321")
+		if errors.Is(err, ErrNotFound) {
+			return
+		}
+		assert.NoError(t, err)
+		assert.True(t, liability.Int64() >= 1 && liability.Int64() <= int64(updates))
+		verifyLater(proof.V[0], proof.W[0], func(V, W *math.G1) error {
+			_, err := proof.Verify(pp, "987654321", V, W, id2Path)
+			return err
+		})
+	})
+
+	go repeat(func() {
+		absence, err := ls.ProveAbsence("111111111")
+		assert.NoError(t, err)
+		verifyLater(absence.V[0], absence.W[0], func(V, W *math.G1) error {
+			return absence.Verify(pp, "111111111", V, W, id2Path)
+		})
+
+		bundle, err := ls.ProveEpochs("282475250", []uint64{1})
+		assert.NoError(t, err)
+		assert.NoError(t, bundle.Verify(pp, "282475250", ls.RootHistory()[:1], id2Path))
+	})
+
+	go repeat(func() {
+		_, err := ls.Get("282475250")
+		assert.True(t, err == nil || errors.Is(err, ErrNotFound))
+		ls.Root()
+		_, err = ls.ProveTot()
+		assert.NoError(t, err)
+		epochs := ls.Epochs()
+		_, err = ls.AtEpoch(epochs[len(epochs)-1])
+		assert.NoError(t, err)
+	})
+
+	writers.Wait()
+	close(done)
+	readers.Wait()
+
+	for _, verify := range verifications {
+		verify()
+	}
+
+	// The last update of every id is in the liability set
+	for _, ids := range writerIDs {
+		for j, id := range ids {
+			liability, err := ls.Get(id)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(updates-(updates-j)%len(ids)), liability.Int64())
+		}
+	}
+}
+
+func TestParallelBuild(t *testing.T) {
+	fanout := uint16(7)
+	id2Path, pp := generatePublicParams(t, fanout, Dense)
+	source := verkle.NewMasterKeys([]byte("master key"))
+
+	liabilities := map[string]*big.Int{
+		"282475250": big.NewInt(100),
+		"564950499": big.NewInt(200),
+		"987654321": big.NewInt(300),
+		"123456789": big.NewInt(400),
+	}
+
+	sequentialDB := make(kv.MemDB)
+	sequential := NewLiabilitySet(pp, sequentialDB, id2Path)
+	sequential.SetBlindingSource(source)
+	assert.NoError(t, sequential.SetBatch(liabilities))
+
+	parallelDB := make(kv.MemDB)
+	parallel := NewLiabilitySet(pp, parallelDB, id2Path)
+	parallel.SetBlindingSource(source)
+	parallel.SetParallelBuild(true)
+	assert.NoError(t, parallel.SetBatch(liabilities))
+
+	assert.Equal(t, sequentialDB, parallelDB)
+
+	V, _ := parallel.Root()
+	totProof, err := parallel.ProveTot()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), totProof.Sum.Int64())
+	assert.NoError(t, totProof.Verify(pp, V))
+}
+
+// generatePublicParams is GeneratePublicParams for a fanout the tree type supports
+func generatePublicParams(t *testing.T, fanout uint16, treeType TreeType) (func(string) ([]uint16, error), *PublicParams) {
+	id2Path, pp, err := GeneratePublicParams(fanout, treeType)
+	assert.NoError(t, err)
+	return id2Path, pp
+}
+
+func pathOf(t *testing.T, id2Path func(string) ([]uint16, error), id string) []uint16 {
+	path, err := id2Path(id)
+	assert.NoError(t, err)
+	return path
+}
+
+func balancesOf(balances ...int64) []*big.Int {
+	res := make([]*big.Int, len(balances))
+	for i, balance := range balances {
+		res[i] = big.NewInt(balance)
+	}
+	return res
+}
+
+func int64s(balances []*big.Int) []int64 {
+	res := make([]int64, len(balances))
+	for i, balance := range balances {
+		res[i] = balance.Int64()
+	}
+	return res
+}
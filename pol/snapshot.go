@@ -0,0 +1,573 @@
+package pol
+
+import (
+	"fmt"
+	"pol/common"
+	"pol/verkle"
+	"sort"
+	"sync"
+
+	math "github.com/IBM/mathlib"
+)
+
+// snapshotsKey is the DB key under which the epochs of the snapshots and their roots are persisted.
+// Keys of vertices are either empty or start with a dot, so they never collide with the keys of snapshots.
+const snapshotsKey = "snapshots"
+
+const (
+	absent byte = iota
+	present
+)
+
+// EpochRoot is the root of the liability set as of the end of an epoch, which is what gets published for the epoch.
+type EpochRoot struct {
+	Epoch uint64
+	V, W  *math.G1
+}
+
+// RetentionPolicy returns the oldest epoch to retain, given the epochs of the retained snapshots in ascending order.
+// A retention policy is not persisted along with the snapshots, so it must be set again every time a liability set is opened.
+type RetentionPolicy func(epochs []uint64) uint64
+
+// RetainAll retains the snapshots of all epochs.
+func RetainAll() RetentionPolicy {
+	return func(epochs []uint64) uint64 {
+		return epochs[0]
+	}
+}
+
+// RetainLast retains the snapshots of the last n epochs, and fails if n is not positive.
+func RetainLast(n int) (RetentionPolicy, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("at least one epoch must be retained, but %d were requested", n)
+	}
+	return func(epochs []uint64) uint64 {
+		if len(epochs) <= n {
+			return epochs[0]
+		}
+		return epochs[len(epochs)-n]
+	}, nil
+}
+
+// RetainWindow retains the snapshots of the epochs that are at most window epochs older than the latest epoch.
+func RetainWindow(window uint64) RetentionPolicy {
+	return func(epochs []uint64) uint64 {
+		latest := epochs[len(epochs)-1]
+		if latest < window {
+			return 0
+		}
+		return latest - window
+	}
+}
+
+type snapshot struct {
+	EpochRoot
+	// copies is the number of keys copied aside for the snapshot
+	copies int
+	// view is the liability set as of the epoch, opened on first use
+	view *LiabilitySet
+}
+
+// snapshotDB is a copy-on-write layer on top of the DB of a liability set.
+// Before a key is overwritten or deleted for the first time since the latest snapshot,
+// its previous value is copied aside for the latest snapshot.
+// The value of a key as of an epoch is therefore the first copy made for a snapshot of that epoch or a later one,
+// and if there is no such copy, the key has not changed since and its value is the current one.
+type snapshotDB struct {
+	DB        verkle.DB
+	snapshots []*snapshot
+	// copied are the keys copied aside for the latest snapshot
+	copied map[string]struct{}
+	// viewLock guards the opening of views by concurrent readers
+	viewLock sync.Mutex
+}
+
+func newSnapshotDB(db verkle.DB) *snapshotDB {
+	return &snapshotDB{
+		DB:     db,
+		copied: make(map[string]struct{}),
+	}
+}
+
+func copyKey(epoch uint64, key []byte) []byte {
+	return []byte(fmt.Sprintf("snapshot/%d/%s", epoch, key))
+}
+
+// indexKey is the key under which the key of the i'th copy made for the snapshot of the given epoch is stored
+func indexKey(epoch uint64, i int) []byte {
+	return []byte(fmt.Sprintf("snapshot/%d#%d", epoch, i))
+}
+
+func (s *snapshotDB) Get(key []byte) ([]byte, error) {
+	return s.DB.Get(key)
+}
+
+func (s *snapshotDB) Put(key []byte, val []byte) error {
+	b := s.NewBatch()
+	b.Put(key, val)
+	return b.Commit()
+}
+
+func (s *snapshotDB) Delete(key []byte) error {
+	b := s.NewBatch()
+	b.Delete(key)
+	return b.Commit()
+}
+
+// NewBatch returns a batch that copies the keys it overwrites or deletes aside for the latest snapshot,
+// so that the copies are committed along with the writes.
+func (s *snapshotDB) NewBatch() verkle.Batch {
+	return &snapshotBatch{
+		s:      s,
+		batch:  s.DB.NewBatch(),
+		copied: make(map[string]struct{}),
+	}
+}
+
+func (s *snapshotDB) Iterate(prefix []byte, f func(key, val []byte) error) error {
+	return s.DB.Iterate(prefix, f)
+}
+
+type snapshotBatch struct {
+	s     *snapshotDB
+	batch verkle.Batch
+	// copied are the keys copied aside by the batch, which are added to the keys copied for the latest snapshot once it is committed
+	copied map[string]struct{}
+	copies int
+	// err is the first error of reading a key to copy aside
+	err error
+}
+
+func (b *snapshotBatch) Put(key []byte, val []byte) {
+	b.preserve(key)
+	b.batch.Put(key, val)
+}
+
+func (b *snapshotBatch) Delete(key []byte) {
+	b.preserve(key)
+	b.batch.Delete(key)
+}
+
+func (b *snapshotBatch) Commit() error {
+	if b.err != nil {
+		return b.err
+	}
+
+	if err := b.batch.Commit(); err != nil {
+		return err
+	}
+
+	for key := range b.copied {
+		b.s.copied[key] = struct{}{}
+	}
+	if b.copies > 0 {
+		b.s.snapshots[len(b.s.snapshots)-1].copies += b.copies
+	}
+
+	return nil
+}
+
+// preserve copies the current value of the key aside for the latest snapshot, unless it was already copied.
+func (b *snapshotBatch) preserve(key []byte) {
+	s := b.s
+	if len(s.snapshots) == 0 || b.err != nil {
+		return
+	}
+
+	if _, exists := s.copied[string(key)]; exists {
+		return
+	}
+	if _, exists := b.copied[string(key)]; exists {
+		return
+	}
+
+	latest := s.snapshots[len(s.snapshots)-1]
+
+	// The key may have been copied before the liability set was opened
+	copied, err := s.DB.Get(copyKey(latest.Epoch, key))
+	if err != nil {
+		b.err = err
+		return
+	}
+
+	b.copied[string(key)] = struct{}{}
+
+	if len(copied) != 0 {
+		return
+	}
+
+	val, err := s.DB.Get(key)
+	if err != nil {
+		b.err = err
+		return
+	}
+
+	record := []byte{absent}
+	if len(val) != 0 {
+		record = append([]byte{present}, val...)
+	}
+
+	b.batch.Put(indexKey(latest.Epoch, latest.copies+b.copies), copyKey(latest.Epoch, key))
+	b.batch.Put(copyKey(latest.Epoch, key), record)
+	b.copies++
+}
+
+// get returns the value of the key as of the given epoch
+func (s *snapshotDB) get(epoch uint64, key []byte) ([]byte, error) {
+	for _, snap := range s.snapshots {
+		if snap.Epoch < epoch {
+			continue
+		}
+
+		record, err := s.DB.Get(copyKey(snap.Epoch, key))
+		if err != nil {
+			return nil, err
+		}
+		if len(record) == 0 {
+			continue
+		}
+
+		if record[0] == absent {
+			return nil, nil
+		}
+		return record[1:], nil
+	}
+
+	return s.DB.Get(key)
+}
+
+func (s *snapshotDB) find(epoch uint64) (*snapshot, bool) {
+	for _, snap := range s.snapshots {
+		if snap.Epoch == epoch {
+			return snap, true
+		}
+	}
+	return nil, false
+}
+
+// update persists the given snapshots in place of the current ones, except for the snapshots of the epochs older than the given epoch,
+// which are deleted along with the keys copied aside for them. The DB is updated in a single batch,
+// and the snapshots are replaced once it is committed.
+func (s *snapshotDB) update(snapshots []*snapshot, oldest uint64) error {
+	batch := s.DB.NewBatch()
+
+	for len(snapshots) > 0 && snapshots[0].Epoch < oldest {
+		pruned := snapshots[0]
+		for i := 0; i < pruned.copies; i++ {
+			key, err := s.DB.Get(indexKey(pruned.Epoch, i))
+			if err != nil {
+				return err
+			}
+			if len(key) != 0 {
+				batch.Delete(key)
+			}
+			batch.Delete(indexKey(pruned.Epoch, i))
+		}
+		snapshots = snapshots[1:]
+	}
+
+	e := common.NewEncoder()
+	e.Uint32(uint32(len(snapshots)))
+	for _, snap := range snapshots {
+		e.Uint64(snap.Epoch)
+		e.G1(snap.V)
+		e.G1(snap.W)
+	}
+
+	raw, err := e.Result()
+	if err != nil {
+		panic(err)
+	}
+
+	batch.Put([]byte(snapshotsKey), raw)
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+
+	s.snapshots = snapshots
+	if len(s.snapshots) == 0 {
+		s.copied = make(map[string]struct{})
+	}
+
+	return nil
+}
+
+// load loads the snapshots persisted in the DB, if there are any.
+func (s *snapshotDB) load() error {
+	raw, err := s.DB.Get([]byte(snapshotsKey))
+	if err != nil {
+		return err
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+
+	d := common.NewDecoder(raw)
+	// Every snapshot takes up an epoch and two group elements
+	s.snapshots = make([]*snapshot, d.Len(8+2*common.G1Size))
+	for i := range s.snapshots {
+		s.snapshots[i] = &snapshot{
+			EpochRoot: EpochRoot{
+				Epoch: d.Uint64(),
+				V:     d.G1(),
+				W:     d.G1(),
+			},
+		}
+	}
+	if err := d.Finish(); err != nil {
+		return fmt.Errorf("snapshots are malformed: %v", err)
+	}
+
+	for _, snap := range s.snapshots {
+		for {
+			key, err := s.DB.Get(indexKey(snap.Epoch, snap.copies))
+			if err != nil {
+				return err
+			}
+			if len(key) == 0 {
+				break
+			}
+			snap.copies++
+		}
+	}
+
+	return nil
+}
+
+// epochDB is a read-only view of a snapshotDB as of an epoch
+type epochDB struct {
+	snapshots *snapshotDB
+	epoch     uint64
+}
+
+func (e *epochDB) Get(key []byte) ([]byte, error) {
+	return e.snapshots.get(e.epoch, key)
+}
+
+func (e *epochDB) Put([]byte, []byte) error {
+	return e.readOnly()
+}
+
+func (e *epochDB) Delete([]byte) error {
+	return e.readOnly()
+}
+
+func (e *epochDB) NewBatch() verkle.Batch {
+	return &readOnlyBatch{err: e.readOnly()}
+}
+
+// Iterate iterates over the keys as of the epoch, which are the current keys and the keys copied aside
+// for the snapshot of the epoch or of later epochs.
+func (e *epochDB) Iterate(prefix []byte, f func(key, val []byte) error) error {
+	keys := make(map[string]struct{})
+
+	err := e.snapshots.DB.Iterate(prefix, func(key, _ []byte) error {
+		keys[string(key)] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, snap := range e.snapshots.snapshots {
+		if snap.Epoch < e.epoch {
+			continue
+		}
+		copies := copyKey(snap.Epoch, nil)
+		err := e.snapshots.DB.Iterate(copyKey(snap.Epoch, prefix), func(key, _ []byte) error {
+			keys[string(key[len(copies):])] = struct{}{}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	for _, key := range sorted {
+		val, err := e.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		if len(val) == 0 {
+			continue
+		}
+		if err := f([]byte(key), val); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *epochDB) readOnly() error {
+	return fmt.Errorf("snapshot of epoch %d is read-only", e.epoch)
+}
+
+// readOnlyBatch is a batch of a read-only DB, which fails to commit.
+type readOnlyBatch struct {
+	err error
+}
+
+func (b *readOnlyBatch) Put([]byte, []byte) {}
+
+func (b *readOnlyBatch) Delete([]byte) {}
+
+func (b *readOnlyBatch) Commit() error {
+	return b.err
+}
+
+// Snapshot freezes the liability set as of the given epoch and records its root as the root of the epoch.
+// Changes made to the liability set afterwards do not affect the snapshot, which can be accessed by AtEpoch
+// until it is pruned. Epochs must be increasing, and the retention policy is applied after every snapshot.
+func (ls *LiabilitySet) Snapshot(epoch uint64) error {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	if err := ls.checkSnapshot(epoch); err != nil {
+		return err
+	}
+
+	V, W := ls.root()
+	snapshots := append(append([]*snapshot(nil), ls.snapshots.snapshots...), &snapshot{
+		EpochRoot: EpochRoot{Epoch: epoch, V: V, W: W},
+	})
+
+	oldest := snapshots[0].Epoch
+	if ls.retention != nil {
+		oldest = ls.retention(epochsOf(snapshots))
+	}
+
+	if err := ls.snapshots.update(snapshots, oldest); err != nil {
+		return err
+	}
+	ls.snapshots.copied = make(map[string]struct{})
+	// Vertices created from now on are of the new epoch
+	ls.tree.Epoch = epoch
+
+	return nil
+}
+
+// checkSnapshot returns an error if a snapshot of the given epoch cannot be taken
+func (ls *LiabilitySet) checkSnapshot(epoch uint64) error {
+	if ls.snapshots == nil {
+		return fmt.Errorf("a snapshot cannot be taken of a snapshot")
+	}
+
+	if n := len(ls.snapshots.snapshots); n > 0 && epoch <= ls.snapshots.snapshots[n-1].Epoch {
+		return fmt.Errorf("epoch %d is not after the latest epoch %d", epoch, ls.snapshots.snapshots[n-1].Epoch)
+	}
+
+	if V, _ := ls.root(); V == nil {
+		return fmt.Errorf("cannot snapshot an empty liability set")
+	}
+
+	return nil
+}
+
+// SetRetentionPolicy sets the policy that decides which snapshots are pruned after every snapshot.
+// By default, the snapshots of all epochs are retained. The policy is not persisted,
+// so a liability set opened with OpenLiabilitySet retains all epochs until it is set again.
+func (ls *LiabilitySet) SetRetentionPolicy(policy RetentionPolicy) {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	ls.retention = policy
+}
+
+// PruneBefore prunes the snapshots of the epochs older than the given epoch.
+func (ls *LiabilitySet) PruneBefore(epoch uint64) error {
+	if ls.snapshots == nil {
+		return nil
+	}
+
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	return ls.snapshots.update(ls.snapshots.snapshots, epoch)
+}
+
+// Epochs returns the epochs of the retained snapshots in ascending order.
+func (ls *LiabilitySet) Epochs() []uint64 {
+	if ls.snapshots == nil {
+		return nil
+	}
+
+	ls.lock.RLock()
+	defer ls.lock.RUnlock()
+
+	return epochsOf(ls.snapshots.snapshots)
+}
+
+func epochsOf(snapshots []*snapshot) []uint64 {
+	epochs := make([]uint64, len(snapshots))
+	for i, snap := range snapshots {
+		epochs[i] = snap.Epoch
+	}
+	return epochs
+}
+
+// RootHistory returns the roots of the retained snapshots in ascending order of their epochs.
+func (ls *LiabilitySet) RootHistory() []EpochRoot {
+	if ls.snapshots == nil {
+		return nil
+	}
+
+	ls.lock.RLock()
+	defer ls.lock.RUnlock()
+
+	roots := make([]EpochRoot, len(ls.snapshots.snapshots))
+	for i, snap := range ls.snapshots.snapshots {
+		roots[i] = snap.EpochRoot
+	}
+	return roots
+}
+
+// AtEpoch returns a read-only view of the liability set as of the snapshot of the given epoch,
+// against which liabilities and totals can be proven.
+func (ls *LiabilitySet) AtEpoch(epoch uint64) (*LiabilitySet, error) {
+	if ls.snapshots == nil {
+		return nil, fmt.Errorf("a snapshot does not have snapshots")
+	}
+
+	ls.lock.RLock()
+	defer ls.lock.RUnlock()
+
+	return ls.atEpoch(epoch)
+}
+
+// atEpoch is like AtEpoch, but is invoked with the lock held for reading.
+func (ls *LiabilitySet) atEpoch(epoch uint64) (*LiabilitySet, error) {
+	snap, exists := ls.snapshots.find(epoch)
+	if !exists {
+		return nil, fmt.Errorf("snapshot of epoch %d %w", epoch, ErrNotFound)
+	}
+
+	ls.snapshots.viewLock.Lock()
+	defer ls.snapshots.viewLock.Unlock()
+
+	if snap.view != nil {
+		return snap.view, nil
+	}
+
+	db := &DBMemorizeRoot{DB: &epochDB{snapshots: ls.snapshots, epoch: epoch}}
+	tree, err := verkle.OpenMultiAssetVerkleTree(uint16(ls.pp.Fanout), ls.pp.assetSlots(), ls.tree.Tree.ID2Path, db)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening epoch %d: %v", epoch, err)
+	}
+	tree.PP = ls.pp.PPPP
+
+	snap.view = &LiabilitySet{
+		DB:   db,
+		pp:   ls.pp,
+		tree: tree,
+		lock: ls.lock,
+	}
+
+	return snap.view, nil
+}
@@ -0,0 +1,149 @@
+package pol
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"pol/kv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	fanout := uint16(7)
+	id2Path, pp := generatePublicParams(t, fanout, Dense)
+
+	db := make(kv.MemDB)
+	ls := NewLiabilitySet(pp, db, id2Path)
+
+	assert.EqualError(t, ls.Snapshot(1), "cannot snapshot an empty liability set")
+
+	assert.NoError(t, ls.Set("282475250", big.NewInt(100)))
+	assert.NoError(t, ls.Set("564950499", big.NewInt(200)))
+	assert.NoError(t, ls.Set("987654321", big.NewInt(300)))
+	assert.NoError(t, ls.Snapshot(1))
+
+	assert.NoError(t, ls.Set("282475250", big.NewInt(150)))
+	assert.NoError(t, ls.Delete("564950499"))
+	assert.NoError(t, ls.Set("123456789", big.NewInt(400)))
+	assert.NoError(t, ls.Snapshot(2))
+	assert.EqualError(t, ls.Snapshot(2), "epoch 2 is not after the latest epoch 2")
+
+	assert.NoError(t, ls.Set("987654321", big.NewInt(350)))
+
+	assert.Equal(t, []uint64{1, 2}, ls.Epochs())
+
+	expected := map[uint64]map[string]int64{
+		1: {"282475250": 100, "564950499": 200, "987654321": 300},
+		2: {"282475250": 150, "987654321": 300, "123456789": 400},
+	}
+	expectedTotals := map[uint64]int64{1: 600, 2: 850}
+
+	assertEpochs := func(ls *LiabilitySet) {
+		for i, root := range ls.RootHistory() {
+			view, err := ls.AtEpoch(root.Epoch)
+			assert.NoError(t, err)
+
+			V, W := view.Root()
+			assert.True(t, V.Equals(root.V), "root V of epoch %d", root.Epoch)
+			assert.True(t, W.Equals(root.W), "root W of epoch %d", root.Epoch)
+			assert.Equal(t, ls.Epochs()[i], root.Epoch)
+
+			for _, id := range []string{"282475250", "564950499", "987654321", "123456789"} {
+				liability, err := view.Get(id)
+				expectedLiability, expectedOK := expected[root.Epoch][id]
+				assert.Equal(t, expectedOK, err == nil, "%s in epoch %d", id, root.Epoch)
+				if err == nil {
+					assert.Equal(t, expectedLiability, liability.Int64(), "%s in epoch %d", id, root.Epoch)
+				}
+			}
+
+			tot, err := view.ProveTot()
+			assert.NoError(t, err)
+			assert.Equal(t, expectedTotals[root.Epoch], tot.Sum.Int64())
+			assert.NoError(t, tot.Verify(pp, root.V))
+		}
+	}
+
+	assertEpochs(ls)
+
+	liability, err := ls.Get("987654321")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(350), liability.Int64())
+
+	// An old balance can be proven against the root published for its epoch
+	view, err := ls.AtEpoch(1)
+	assert.NoError(t, err)
+	liability, proof, _, err := view.ProveLiability("564950499")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(200), liability.Int64())
+	root := ls.RootHistory()[0]
+	_, err = proof.Verify(pp, "564950499", root.V, root.W, id2Path)
+	assert.NoError(t, err)
+
+	assert.EqualError(t, view.Set("564950499", big.NewInt(1)), "a snapshot is read-only")
+	assert.Error(t, view.Delete("564950499"))
+	assert.Error(t, view.Snapshot(3))
+
+	_, err = ls.AtEpoch(3)
+	assert.EqualError(t, err, "snapshot of epoch 3 not found")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// The snapshots are persisted along with the liability set
+	opened, err := OpenLiabilitySet(pp, db, id2Path)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2}, opened.Epochs())
+	assertEpochs(opened)
+
+	// Only the last two epochs are retained, and the keys of pruned epochs are deleted
+	retainLast, err := RetainLast(2)
+	assert.NoError(t, err)
+	opened.SetRetentionPolicy(retainLast)
+	assert.NoError(t, opened.Set("123456789", big.NewInt(450)))
+	assert.NoError(t, opened.Snapshot(5))
+	expected[5] = map[string]int64{"282475250": 150, "987654321": 350, "123456789": 450}
+	expectedTotals[5] = 950
+
+	assert.Equal(t, []uint64{2, 5}, opened.Epochs())
+	assertEpochs(opened)
+	_, err = opened.AtEpoch(1)
+	assert.Error(t, err)
+
+	for key := range db {
+		assert.False(t, strings.HasPrefix(key, "snapshot/1/") || strings.HasPrefix(key, "snapshot/1#"), "key %s of a pruned epoch", key)
+	}
+
+	assert.NoError(t, opened.PruneBefore(5))
+	assert.Equal(t, []uint64{5}, opened.Epochs())
+	assertEpochs(opened)
+
+	// The retention policy is not persisted, so a reopened liability set retains all epochs until it is set again
+	reopened, err := OpenLiabilitySet(pp, db, id2Path)
+	assert.NoError(t, err)
+	for _, epoch := range []uint64{6, 7} {
+		assert.NoError(t, reopened.Set("123456789", big.NewInt(int64(epoch))))
+		assert.NoError(t, reopened.Snapshot(epoch))
+	}
+	assert.Equal(t, []uint64{5, 6, 7}, reopened.Epochs())
+}
+
+func TestRetentionPolicies(t *testing.T) {
+	epochs := []uint64{3, 4, 7, 9}
+
+	assert.Equal(t, uint64(3), RetainAll()(epochs))
+	assert.Equal(t, uint64(6), RetainWindow(3)(epochs))
+	assert.Equal(t, uint64(0), RetainWindow(10)(epochs))
+
+	for n, oldest := range map[int]uint64{1: 9, 2: 7, 10: 3} {
+		retainLast, err := RetainLast(n)
+		assert.NoError(t, err)
+		assert.Equal(t, oldest, retainLast(epochs), n)
+	}
+
+	for _, n := range []int{0, -1} {
+		_, err := RetainLast(n)
+		assert.EqualError(t, err, fmt.Sprintf("at least one epoch must be retained, but %d were requested", n))
+	}
+}
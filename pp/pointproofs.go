@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"pol/common"
+	"sync"
 
 	math "github.com/IBM/mathlib"
 )
@@ -20,6 +21,22 @@ type PP struct {
 	G1s    common.G1v
 	G2s    common.G2v
 	Gt     *math.Gt
+	tables *common.FixedBaseVec
+}
+
+// tablesLock guards the lazily built tables of all public parameters
+var tablesLock sync.Mutex
+
+// Tables returns the precomputed tables of G1s, which are built once and shared by the prover and the verifier.
+// If G1s is replaced, the tables are rebuilt.
+func (pp *PP) Tables() *common.FixedBaseVec {
+	tablesLock.Lock()
+	defer tablesLock.Unlock()
+
+	if pp.tables == nil || !pp.tables.Matches(pp.G1s) {
+		pp.tables = common.NewFixedBaseVec(pp.G1s)
+	}
+	return pp.tables
 }
 
 func NewPublicParams(N int) *PP {
@@ -81,12 +98,7 @@ func Commit(pp *PP, m common.Vec) *math.G1 {
 		panic(fmt.Sprintf("message should be of size %d but is of size %d", pp.N, len(m)))
 	}
 
-	var powersOfAlpha common.G1v
-	for i := 0; i < pp.N; i++ {
-		powersOfAlpha = append(powersOfAlpha, pp.G1s[i])
-	}
-
-	return powersOfAlpha.MulV(m).Sum()
+	return pp.Tables().MultiExp(0, m)
 }
 
 func Open(pp *PP, i int, m common.Vec) (mi *math.Zr, π *math.G1) {
@@ -96,24 +108,25 @@ func Open(pp *PP, i int, m common.Vec) (mi *math.Zr, π *math.G1) {
 
 	shift := pp.N - i
 
-	var elements common.G1v
-	var exponents common.Vec
-	for j := 1; j <= pp.N; j++ {
-		if j == i+1 {
-			continue
-		}
-		index := shift + j - 1
-		elements = append(elements, pp.G1s[index])
-		exponents = append(exponents, m[j-1])
-	}
+	// π = Σ_{j≠i} m[j]·G1s[shift+j], so m[i] is zeroed out
+	exponents := make(common.Vec, pp.N)
+	copy(exponents, m)
+	exponents[i] = common.IntToZr(0)
 
-	π = elements.MulV(exponents).Sum()
+	π = pp.Tables().MultiExp(shift, exponents)
 	mi = m[i]
 
 	return
 }
 
 func Verify(pp *PP, mi *math.Zr, π *math.G1, C *math.G1, i int) error {
+	if i < 0 || i >= pp.N {
+		return fmt.Errorf("%w: can only verify an index in [0,%d] but got %d", common.ErrMalformedProof, pp.N-1, i)
+	}
+	if mi == nil || π == nil || C == nil {
+		return fmt.Errorf("%w: missing element, proof or commitment", common.ErrMalformedProof)
+	}
+
 	left := common.G1v{C}.InnerProd(common.G2v{pp.G2s[pp.N-i-1]})
 	right := common.G1v{π}.InnerProd(common.G2v{c.GenG2})
 	right.Mul(pp.Gt.Exp(mi))
@@ -124,27 +137,46 @@ func Verify(pp *PP, mi *math.Zr, π *math.G1, C *math.G1, i int) error {
 	return fmt.Errorf("%v is not an element in index %d in %v", mi, i, C)
 }
 
+// Update changes the commitment C to m in place, so that it commits to mi instead of m[i] in index i.
 func Update(pp *PP, C *math.G1, m common.Vec, mi *math.Zr, i int) {
-	prevG := pp.G1s[i].Mul(m[i])
-	nextG := pp.G1s[i].Mul(mi)
-	C.Sub(prevG)
-	C.Add(nextG)
+	C.Add(pp.Tables().Mul(i, c.ModSub(mi, m[i], c.GroupOrder)))
 }
 
+// MaxAggregated is the maximum number of commitments whose proofs can be aggregated,
+// since RO hashes the index of a commitment into a single byte.
+const MaxAggregated = 256
+
 func Aggregate(pp *PP, commitments common.G1v, proofs []*math.G1, RO func(*PP, []*math.G1, int) *math.Zr) *math.G1 {
 	if len(proofs) != len(commitments) {
 		panic(fmt.Sprintf("cannot aggregate %d proofs corresponding to %d commitments", len(proofs), len(commitments)))
 	}
-	var π common.G1v
-
+	if len(commitments) > MaxAggregated {
+		panic(fmt.Sprintf("cannot aggregate proofs of %d commitments, at most %d are supported", len(commitments), MaxAggregated))
+	}
+	exponents := make(common.Vec, len(proofs))
 	for j := 0; j < len(proofs); j++ {
-		π = append(π, proofs[j].Mul(RO(pp, commitments, j)))
+		exponents[j] = RO(pp, commitments, j)
 	}
 
-	return π.Sum()
+	return common.MultiExp(proofs, exponents)
 }
 
 func VerifyAggregation(pp *PP, indices []int, commitments common.G1v, π *math.G1, Σ *math.Zr, RO func(*PP, []*math.G1, int) *math.Zr) error {
+	if len(indices) != len(commitments) {
+		return fmt.Errorf("%w: got %d indices for %d commitments", common.ErrMalformedProof, len(indices), len(commitments))
+	}
+	if len(commitments) > MaxAggregated {
+		return fmt.Errorf("%w: got %d commitments, at most %d can be aggregated", common.ErrMalformedProof, len(commitments), MaxAggregated)
+	}
+	for _, i := range indices {
+		if i < 0 || i >= pp.N {
+			return fmt.Errorf("%w: can only verify an index in [0,%d] but got %d", common.ErrMalformedProof, pp.N-1, i)
+		}
+	}
+	if π == nil || Σ == nil || commitments.HasNil() {
+		return fmt.Errorf("%w: missing proof, aggregated value or commitment", common.ErrMalformedProof)
+	}
+
 	var exponents []*math.Zr
 	for i := 0; i < len(indices); i++ {
 		exponents = append(exponents, RO(pp, commitments, i))
@@ -178,3 +210,117 @@ func RO(pp *PP, cs []*math.G1, i int) *math.Zr {
 	result := common.FieldElementFromBytes(digest)
 	return result
 }
+
+func (pp *PP) MarshalBinary() ([]byte, error) {
+	e := common.NewEncoder()
+	e.Uint32(uint32(pp.N))
+	e.G1v(pp.G1s)
+	e.G2v(pp.G2s)
+	e.Gt(pp.Gt)
+	return e.Result()
+}
+
+// UnmarshalBinary decodes the public parameters, recomputes their digest
+// and makes sure they have the structure produced by NewPublicParams.
+func (pp *PP) UnmarshalBinary(b []byte) error {
+	d := common.NewDecoder(b)
+	N := int(d.Uint32())
+	G1s := d.G1v()
+	G2s := d.G2v()
+	Gt := d.Gt()
+	if err := d.Finish(); err != nil {
+		return err
+	}
+
+	loaded := &PP{N: N, G1s: G1s, G2s: G2s, Gt: Gt}
+	if err := loaded.Validate(); err != nil {
+		return err
+	}
+	loaded.SetupDigest()
+
+	*pp = *loaded
+	return nil
+}
+
+// Validate checks that the public parameters have the structure the soundness of Verify depends on:
+// G1s and G2s are consecutive powers of the same α in the exponent, except for the generator
+// that replaces g1^{α^{N+1}} in index N, and Gt = e(g1, g2)^{α^{N+1}}.
+// The consecutive powers are checked with a randomized linear combination of the pairing equations,
+// so a malformed element is detected except with negligible probability.
+func (pp *PP) Validate() error {
+	N := pp.N
+	if N < 2 {
+		return fmt.Errorf("N should be at least 2 but is %d", N)
+	}
+	if len(pp.G1s) != 2*N {
+		return fmt.Errorf("expected %d elements in G1 but got %d", 2*N, len(pp.G1s))
+	}
+	if len(pp.G2s) != N {
+		return fmt.Errorf("expected %d elements in G2 but got %d", N, len(pp.G2s))
+	}
+	if pp.Gt == nil {
+		return fmt.Errorf("Gt element is missing")
+	}
+
+	g1, g2 := c.GenG1, c.GenG2
+
+	if pp.G1s[0].IsInfinity() {
+		return fmt.Errorf("G1 element in index 0 is the identity")
+	}
+	if !pp.G1s[N].Equals(g1) {
+		return fmt.Errorf("G1 element in index %d is not the generator", N)
+	}
+
+	// Each element in G2 is the previous one raised to α, where g1^α is the first element in G1:
+	// e(g1, G2s[i+1]) = e(G1s[0], G2s[i]) with g2 preceding G2s[0].
+	prevG2s := append(common.G2v{g2}, pp.G2s[:N-1]...)
+	s := randVec(N)
+	left := pp.G2s.Mulv(s).Sum()
+	right := prevG2s.Mulv(s).Sum()
+	if !samePairing(g1, left, pp.G1s[0], right) {
+		return fmt.Errorf("G2 elements are not consecutive powers of α")
+	}
+
+	// Each element in G1 is the previous one raised to α:
+	// e(G1s[i+1], g2) = e(G1s[i], G2s[0]) with g1 preceding G1s[0], except for
+	// g1^{α^{N+2}} that is linked to g1^{α^N} as g1^{α^{N+1}} is not published:
+	// e(G1s[N+1], g2) = e(G1s[N-1], G2s[1]).
+	var next, prev common.G1v
+	next = append(next, pp.G1s[:N]...)
+	prev = append(prev, g1)
+	prev = append(prev, pp.G1s[:N-1]...)
+	next = append(next, pp.G1s[N+2:]...)
+	prev = append(prev, pp.G1s[N+1:2*N-1]...)
+
+	r := randVec(len(next) + 1)
+	X := common.MultiExp(next, r[1:])
+	X.Add(pp.G1s[N+1].Mul(r[0]))
+	Y := common.MultiExp(prev, r[1:])
+	Z := pp.G1s[N-1].Mul(r[0])
+
+	negated := common.G1v{Y, Z}.Neg()
+	product := common.G1v{X, negated[0], negated[1]}.InnerProd(common.G2v{g2, pp.G2s[0], pp.G2s[1]})
+	if !product.IsUnity() {
+		return fmt.Errorf("G1 elements are not consecutive powers of α")
+	}
+
+	gt := common.G1v{pp.G1s[0]}.InnerProd(common.G2v{pp.G2s[N-1]})
+	if !gt.Equals(pp.Gt) {
+		return fmt.Errorf("Gt element is not e(g1, g2)^{α^%d}", N+1)
+	}
+
+	return nil
+}
+
+func randVec(n int) common.Vec {
+	v := make(common.Vec, n)
+	for i := 0; i < n; i++ {
+		v[i] = c.NewRandomZr(rand.Reader)
+	}
+	return v
+}
+
+// samePairing returns whether e(a, b) = e(x, y)
+func samePairing(a *math.G1, b *math.G2, x *math.G1, y *math.G2) bool {
+	return common.G1v{a, common.G1v{x}.Neg()[0]}.InnerProd(common.G2v{b, y}).IsUnity()
+}
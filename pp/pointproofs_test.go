@@ -2,6 +2,7 @@ package pp
 
 import (
 	"crypto/rand"
+	"errors"
 	"pol/common"
 	"testing"
 
@@ -86,3 +87,138 @@ func TestAggregation(t *testing.T) {
 		assert.NoError(t, err)
 	}
 }
+
+func TestAggregationLimit(t *testing.T) {
+	N := 8
+	pp := NewPublicParams(N)
+
+	var m common.Vec
+	for i := 0; i < N; i++ {
+		m = append(m, c.NewRandomZr(rand.Reader))
+	}
+	C := Commit(pp, m)
+	mi, π := Open(pp, 0, m)
+
+	aggregate := func(n int) (common.G1v, []*math.G1, []int, *math.Zr) {
+		commitments := make(common.G1v, n)
+		proofs := make([]*math.G1, n)
+		for i := range commitments {
+			commitments[i], proofs[i] = C, π
+		}
+		Σ := c.NewZrFromInt(0)
+		for i := range commitments {
+			Σ = Σ.Plus(mi.Mul(RO(pp, commitments, i)))
+		}
+		return commitments, proofs, make([]int, n), Σ
+	}
+
+	commitments, proofs, indices, Σ := aggregate(MaxAggregated)
+	assert.NoError(t, VerifyAggregation(pp, indices, commitments, Aggregate(pp, commitments, proofs, RO), Σ, RO))
+
+	// RO cannot tell apart the indices of more commitments, so their proofs could cancel each other out
+	commitments, proofs, indices, Σ = aggregate(MaxAggregated + 1)
+	assert.Panics(t, func() { Aggregate(pp, commitments, proofs, RO) })
+	err := VerifyAggregation(pp, indices, commitments, π, Σ, RO)
+	assert.EqualError(t, err, "malformed proof: got 257 commitments, at most 256 can be aggregated")
+	assert.True(t, errors.Is(err, common.ErrMalformedProof))
+}
+
+func TestPublicParamsEncoding(t *testing.T) {
+	N := 9
+	pp := NewPublicParams(N)
+
+	raw, err := pp.MarshalBinary()
+	assert.NoError(t, err)
+
+	var decoded PP
+	assert.NoError(t, decoded.UnmarshalBinary(raw))
+	assert.Equal(t, pp.Digest, decoded.Digest)
+	assert.Equal(t, N, decoded.N)
+
+	raw2, err := decoded.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, raw, raw2)
+
+	pp.G1s[3], pp.G1s[4] = pp.G1s[4], pp.G1s[3]
+	raw, err = pp.MarshalBinary()
+	assert.NoError(t, err)
+	assert.EqualError(t, decoded.UnmarshalBinary(raw), "G1 elements are not consecutive powers of α")
+}
+
+func TestValidate(t *testing.T) {
+	N := 9
+	pp := NewPublicParams(N)
+	assert.NoError(t, pp.Validate())
+
+	for _, tst := range []struct {
+		name   string
+		tamper func(pp *PP)
+		err    string
+	}{
+		{
+			name: "G1 element",
+			tamper: func(pp *PP) {
+				pp.G1s[3] = pp.G1s[2]
+			},
+			err: "G1 elements are not consecutive powers of α",
+		},
+		{
+			name: "G1 element after the generator",
+			tamper: func(pp *PP) {
+				pp.G1s[N+1] = pp.G1s[N+1].Mul(c.NewZrFromInt(2))
+			},
+			err: "G1 elements are not consecutive powers of α",
+		},
+		{
+			name: "last G1 element",
+			tamper: func(pp *PP) {
+				pp.G1s[2*N-1] = pp.G1s[2*N-1].Mul(c.NewZrFromInt(2))
+			},
+			err: "G1 elements are not consecutive powers of α",
+		},
+		{
+			name: "different α in G2",
+			tamper: func(pp *PP) {
+				pp.G2s = NewPublicParams(N).G2s
+			},
+			err: "G2 elements are not consecutive powers of α",
+		},
+		{
+			name: "generator",
+			tamper: func(pp *PP) {
+				pp.G1s[N] = pp.G1s[0]
+			},
+			err: "G1 element in index 9 is not the generator",
+		},
+		{
+			name: "G2 element",
+			tamper: func(pp *PP) {
+				pp.G2s[N-1] = pp.G2s[N-2]
+			},
+			err: "G2 elements are not consecutive powers of α",
+		},
+		{
+			name: "Gt element",
+			tamper: func(pp *PP) {
+				pp.Gt = common.G1v{pp.G1s[0]}.InnerProd(common.G2v{pp.G2s[0]})
+			},
+			err: "Gt element is not e(g1, g2)^{α^10}",
+		},
+		{
+			name: "size",
+			tamper: func(pp *PP) {
+				pp.G2s = pp.G2s[:N-1]
+			},
+			err: "expected 9 elements in G2 but got 8",
+		},
+	} {
+		t.Run(tst.name, func(t *testing.T) {
+			tampered := *pp
+			tampered.G1s = append(common.G1v{}, pp.G1s...)
+			tampered.G2s = append(common.G2v{}, pp.G2s...)
+			tst.tamper(&tampered)
+
+			assert.EqualError(t, tampered.Validate(), tst.err)
+		})
+	}
+}
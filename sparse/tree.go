@@ -9,20 +9,34 @@ import (
 	"io"
 	"math/big"
 	"pol/common"
+	"runtime"
 	"strconv"
+	"sync"
 )
 
 type Tree struct {
-	ID2Path           func(string) []uint16
+	// ID2Path maps an id to the indices of the vertices along its path, from the root down to its leaf.
+	// It returns an error wrapping common.ErrInvalidID if the id cannot be mapped.
+	ID2Path           func(string) ([]uint16, error)
 	UpdateInnerVertex func(key string, node interface{}, descendants []interface{}, descendantsLeaves bool, indexChanged int) interface{}
-	FanOut            int
-	Root              *Vertex
+	// RebuildInnerVertex is used by PutBatch to recompute an inner vertex from all its descendants at once.
+	// It may be invoked concurrently for different vertices in the same layer.
+	RebuildInnerVertex func(key string, node interface{}, descendants []interface{}, descendantsLeaves bool) interface{}
+	// RemoveVertex is invoked (if not nil) for every inner vertex that is pruned from the tree by Delete
+	RemoveVertex func(key string, node interface{})
+	FanOut       int
+	Root         *Vertex
 }
 
-func (t *Tree) Get(id string) (interface{}, []interface{}, bool) {
-	path := t.ID2Path(id)
+// Get returns the data of the leaf of the given id, and the vertices along its path,
+// or an error wrapping common.ErrNotFound if the id is not in the tree.
+func (t *Tree) Get(id string) (interface{}, []interface{}, error) {
+	path, err := t.ID2Path(id)
+	if err != nil {
+		return nil, nil, err
+	}
 	if t.Root == nil {
-		return nil, nil, false
+		return nil, nil, notFound(id)
 	}
 
 	var verticesAlongThePath []interface{}
@@ -33,14 +47,48 @@ func (t *Tree) Get(id string) (interface{}, []interface{}, bool) {
 		verticesAlongThePath = append(verticesAlongThePath, v)
 		v, exists = v.Descendants[p]
 		if !exists {
-			return nil, nil, false
+			return nil, nil, notFound(id)
+		}
+	}
+	return v.Data, verticesAlongThePath, nil
+}
+
+func notFound(id string) error {
+	return fmt.Errorf("%s %w in the tree", id, common.ErrNotFound)
+}
+
+// GetPrefix returns the vertices along the path of the given id, from the root down to the deepest
+// vertex that is in the tree, and whether the leaf of the id itself is in the tree.
+// If the leaf is in the tree, the returned vertices are the ones returned by Get.
+func (t *Tree) GetPrefix(id string) ([]interface{}, bool, error) {
+	path, err := t.ID2Path(id)
+	if err != nil {
+		return nil, false, err
+	}
+	if t.Root == nil {
+		return nil, false, nil
+	}
+
+	var verticesAlongThePath []interface{}
+
+	v := t.Root
+	for _, p := range path {
+		verticesAlongThePath = append(verticesAlongThePath, v)
+		u, exists := v.Descendants[p]
+		if !exists {
+			return verticesAlongThePath, false, nil
 		}
+		v = u
 	}
-	return v.Data, verticesAlongThePath, true
+
+	return verticesAlongThePath, true, nil
 }
 
-func (t *Tree) Put(id string, data interface{}) {
-	path := t.ID2Path(id)
+func (t *Tree) Put(id string, data interface{}) error {
+	path, err := t.ID2Path(id)
+	if err != nil {
+		return err
+	}
 
 	if t.Root == nil {
 		t.Root = &Vertex{
@@ -79,6 +127,261 @@ func (t *Tree) Put(id string, data interface{}) {
 		descendantsLeaves = false
 		i--
 	}
+
+	return nil
+}
+
+// PutBatch puts all the given data in the tree, and then recomputes every inner vertex along their paths
+// exactly once with RebuildInnerVertex, layer by layer from the bottom up.
+// Vertices in the same layer are recomputed in parallel.
+// If any of the ids cannot be mapped to a path, nothing is put in the tree.
+func (t *Tree) PutBatch(data map[string]interface{}) error {
+	paths, err := t.paths(data)
+	if err != nil || len(data) == 0 {
+		return err
+	}
+
+	touched := t.insert(data, paths)
+	for depth := len(touched) - 1; depth >= 0; depth-- {
+		t.rebuildLayer(touched[depth])
+	}
+
+	return nil
+}
+
+// PutBatchBySubtree is like PutBatch, but splits the ids by the first index of their paths,
+// and recomputes the subtree of every descendant of the root in its own goroutine, without waiting for the other subtrees.
+// RebuildInnerVertex is invoked concurrently for vertices in different subtrees, and one vertex at a time within a subtree.
+// Once the subtree in an index of the root is recomputed, subtreeDone (if not nil) is invoked with the index in the goroutine
+// of the subtree. The root is recomputed last, after all the subtrees are done.
+func (t *Tree) PutBatchBySubtree(data map[string]interface{}, subtreeDone func(index uint16)) error {
+	paths, err := t.paths(data)
+	if err != nil || len(data) == 0 {
+		return err
+	}
+
+	subtrees := make(map[uint16]map[string]interface{})
+	for id, leafData := range data {
+		index := paths[id][0]
+		if subtrees[index] == nil {
+			subtrees[index] = make(map[string]interface{})
+		}
+		subtrees[index][id] = leafData
+	}
+
+	// The vertices are inserted by a single goroutine, as the subtrees share the root
+	indices := make(chan uint16, len(subtrees))
+	touched := make(map[uint16][]map[*Vertex]bool, len(subtrees))
+	root := make(map[*Vertex]bool, 1)
+	for index, subtreeData := range subtrees {
+		touched[index] = t.insert(subtreeData, paths)
+		for v, descendantsLeaves := range touched[index][0] {
+			root[v] = descendantsLeaves
+		}
+		indices <- index
+	}
+	close(indices)
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(subtrees) {
+		workers = len(subtrees)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				layers := touched[index]
+				for depth := len(layers) - 1; depth > 0; depth-- {
+					for v, descendantsLeaves := range layers[depth] {
+						v.Data = t.RebuildInnerVertex(v.key, v.Data, v.rawData(t.FanOut), descendantsLeaves)
+					}
+				}
+				if subtreeDone != nil {
+					subtreeDone(index)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	t.rebuildLayer(root)
+
+	return nil
+}
+
+// paths maps every id of the given data to its path, and returns an error if any of them cannot be mapped.
+func (t *Tree) paths(data map[string]interface{}) (map[string][]uint16, error) {
+	paths := make(map[string][]uint16, len(data))
+	for id := range data {
+		path, err := t.ID2Path(id)
+		if err != nil {
+			return nil, err
+		}
+		paths[id] = path
+	}
+	return paths, nil
+}
+
+// insert puts the given data in the leaves of their paths without recomputing any inner vertex,
+// and returns the inner vertices along the paths by their depth, and whether their descendants are leaves.
+func (t *Tree) insert(data map[string]interface{}, paths map[string][]uint16) []map[*Vertex]bool {
+	if t.Root == nil {
+		t.Root = &Vertex{
+			Descendants: make(map[uint16]*Vertex),
+		}
+	}
+
+	// touched[d] holds the inner vertices in depth d that need to be recomputed,
+	// and whether their descendants are leaves.
+	var touched []map[*Vertex]bool
+
+	for id, leafData := range data {
+		path := paths[id]
+
+		for len(touched) < len(path) {
+			touched = append(touched, make(map[*Vertex]bool))
+		}
+
+		v := t.Root
+		var pathString string
+		for depth, p := range path {
+			touched[depth][v] = depth == len(path)-1
+			pathString = fmt.Sprintf("%s.%d", pathString, p)
+			if v.Descendants[p] == nil {
+				v.Descendants[p] = &Vertex{
+					key:         pathString,
+					Parent:      v,
+					Descendants: make(map[uint16]*Vertex),
+				}
+			}
+			v = v.Descendants[p]
+		}
+
+		v.Data = leafData
+	}
+
+	return touched
+}
+
+// rebuildLayer recomputes the given vertices of a layer in parallel.
+func (t *Tree) rebuildLayer(layer map[*Vertex]bool) {
+	vertices := make(chan *Vertex, len(layer))
+	for v := range layer {
+		vertices <- v
+	}
+	close(vertices)
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(layer) {
+		workers = len(layer)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for v := range vertices {
+				descendantsLeaves := layer[v]
+				v.Data = t.RebuildInnerVertex(v.key, v.Data, v.rawData(t.FanOut), descendantsLeaves)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Delete removes the leaf of the given id along with every inner vertex that is left without descendants,
+// and updates the remaining vertices along the path. The descendant at the changed index of the first
+// updated vertex is nil. Returns an error wrapping common.ErrNotFound if the id is not in the tree.
+func (t *Tree) Delete(id string) error {
+	path, err := t.ID2Path(id)
+	if err != nil {
+		return err
+	}
+	if t.Root == nil {
+		return notFound(id)
+	}
+
+	v := t.Root
+	for _, p := range path {
+		v = v.Descendants[p]
+		if v == nil {
+			return notFound(id)
+		}
+	}
+
+	i := len(path) - 1
+	v = v.Parent
+	delete(v.Descendants, path[i])
+
+	descendantsLeaves := true
+	for len(v.Descendants) == 0 {
+		if t.RemoveVertex != nil {
+			t.RemoveVertex(v.key, v.Data)
+		}
+		if v.Parent == nil {
+			t.Root = nil
+			return nil
+		}
+		v = v.Parent
+		i--
+		delete(v.Descendants, path[i])
+		descendantsLeaves = false
+	}
+
+	for v != nil {
+		v.Data = t.UpdateInnerVertex(v.key, v.Data, v.rawData(t.FanOut), descendantsLeaves, int(path[i]))
+		v = v.Parent
+		descendantsLeaves = false
+		i--
+	}
+
+	return nil
+}
+
+// Rebuild reconstructs the topology of the tree starting from the data of its root, without invoking UpdateInnerVertex.
+// The given descendants function returns the data of the descendants of the vertex with the given key and data,
+// and an empty map if the vertex is a leaf.
+func (t *Tree) Rebuild(rootData interface{}, descendants func(key string, data interface{}) (map[uint16]interface{}, error)) error {
+	root := &Vertex{
+		Data:        rootData,
+		Descendants: make(map[uint16]*Vertex),
+	}
+
+	if err := t.rebuild(root, descendants); err != nil {
+		return err
+	}
+
+	t.Root = root
+	return nil
+}
+
+func (t *Tree) rebuild(v *Vertex, descendants func(key string, data interface{}) (map[uint16]interface{}, error)) error {
+	data, err := descendants(v.key, v.Data)
+	if err != nil {
+		return err
+	}
+
+	for at, d := range data {
+		if int(at) >= t.FanOut {
+			return fmt.Errorf("vertex %s has a descendant at %d but the fanout is %d", v.key, at, t.FanOut)
+		}
+		u := &Vertex{
+			key:         fmt.Sprintf("%s.%d", v.key, at),
+			Data:        d,
+			Parent:      v,
+			Descendants: make(map[uint16]*Vertex),
+		}
+		v.AddDescendant(u, at)
+		if err := t.rebuild(u, descendants); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // Vertex defines a vertex of a graph
@@ -128,34 +431,35 @@ func (v *Vertex) rawData(size int) []interface{} {
 	return res
 }
 
-func DigitPath(fanout uint16) func(string) []uint16 {
+// DigitPath returns the mapping of ids of nine decimal digits to paths in a tree of the given fanout.
+func DigitPath(fanout uint16) (func(string) ([]uint16, error), error) {
 	if !common.IsPowerOfTwo(fanout + 1) {
-		panic(fmt.Sprintf("fanout %d+1 is not a power of two", fanout))
+		return nil, fmt.Errorf("%w: %d+1 is not a power of two", common.ErrUnsupportedFanout, fanout)
 	}
 
-	return func(s string) []uint16 {
+	return func(s string) ([]uint16, error) {
 		if len(s) != 9 {
-			panic(fmt.Sprintf("%s is not a 9 digit decimal number", s))
+			return nil, fmt.Errorf("%w: %q is not a 9 digit decimal number", common.ErrInvalidID, s)
 		}
-		num, err := strconv.ParseInt(s, 10, 32)
+		num, err := strconv.ParseUint(s, 10, 32)
 		if err != nil {
-			panic(fmt.Sprintf("%s is not a valid decimal number", s))
+			return nil, fmt.Errorf("%w: %q is not a valid decimal number", common.ErrInvalidID, s)
 		}
 
 		var res []uint16
 
 		for num > 0 {
-			res = append(res, uint16(num%int64(fanout)))
-			num /= int64(fanout)
+			res = append(res, uint16(num%uint64(fanout)))
+			num /= uint64(fanout)
 		}
 
-		pathLen := DigitPathLen(fanout)
-		if len(res) != pathLen {
+		// All paths are of the same length, or else the leaf of one id could be an inner vertex along the path of another
+		for len(res) < DigitPathLen(fanout) {
 			res = append(res, 0)
 		}
 
-		return res
-	}
+		return res, nil
+	}, nil
 }
 
 func DigitPathLen(fanout uint16) int {
@@ -191,27 +495,32 @@ var (
 	}
 )
 
-func HexId2PathForFanOut(fanout uint16) func(string) []uint16 {
+// HexId2PathForFanOut returns the mapping of hexadecimal ids to paths in a tree of the given fanout,
+// which must be one of the fanouts in ExpectedHexPathLengthByFanOut.
+func HexId2PathForFanOut(fanout uint16) (func(string) ([]uint16, error), error) {
 	if !common.IsPowerOfTwo(fanout + 1) {
-		panic(fmt.Sprintf("fanout %d+1 is not a power of two", fanout))
+		return nil, fmt.Errorf("%w: %d+1 is not a power of two", common.ErrUnsupportedFanout, fanout)
 	}
 
 	_, exists := ExpectedHexPathLengthByFanOut[fanout]
 	if !exists {
-		panic(fmt.Sprintf("a fanout of %d is not supported!", fanout))
+		return nil, fmt.Errorf("%w: a fanout of %d is not supported for hexadecimal ids", common.ErrUnsupportedFanout, fanout)
 	}
 
-	return func(s string) []uint16 {
+	return func(s string) ([]uint16, error) {
 		expectedPathLen := ExpectedHexPathLengthByFanOut[fanout]
 
 		for {
-			path := convertPathWithFanout(s, fanout)
+			path, err := convertPathWithFanout(s, fanout)
+			if err != nil {
+				return nil, err
+			}
 			if len(path) == expectedPathLen {
-				return path
+				return path, nil
 			}
 			s = hash(s)
 		}
-	}
+	}, nil
 }
 
 func hash(s string) string {
@@ -220,10 +529,10 @@ func hash(s string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-func convertPathWithFanout(s string, fanOut uint16) []uint16 {
+func convertPathWithFanout(s string, fanOut uint16) ([]uint16, error) {
 	n, ok := big.NewInt(0).SetString(s, 16)
-	if !ok {
-		panic(fmt.Sprintf("failed parsing %s as a hexadecimal number", s))
+	if !ok || n.Sign() < 0 {
+		return nil, fmt.Errorf("%w: failed parsing %q as a hexadecimal number", common.ErrInvalidID, s)
 	}
 
 	var res []uint16
@@ -235,5 +544,5 @@ func convertPathWithFanout(s string, fanOut uint16) []uint16 {
 		n.Div(n, fo)
 	}
 
-	return res
+	return res, nil
 }
@@ -3,21 +3,39 @@ package sparse
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"pol/common"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// binaryPath maps ids of binary digits to paths in a tree of a fanout of 2
+func binaryPath(id string) ([]uint16, error) {
+	var res []uint16
+	for i := 0; i < len(id); i++ {
+		if id[i] != '0' && id[i] != '1' {
+			return nil, fmt.Errorf("%w: %q is not a binary number", common.ErrInvalidID, id)
+		}
+		res = append(res, uint16(id[i]-'0'))
+	}
+	return res, nil
+}
+
+func digitPath(t *testing.T, fanout uint16) func(string) ([]uint16, error) {
+	id2Path, err := DigitPath(fanout)
+	assert.NoError(t, err)
+	return id2Path
+}
+
 func TestSparseBinarySummationTree(t *testing.T) {
 	tree := Tree{
-		FanOut: 2,
-		ID2Path: func(id string) []uint16 {
-			var res []uint16
-			for i := 0; i < len(id); i++ {
-				res = append(res, uint16(id[i]-'0'))
-			}
-			return res
-		},
+		FanOut:  2,
+		ID2Path: binaryPath,
 		UpdateInnerVertex: func(key string, node interface{}, descendants []interface{}, _ bool, _ int) interface{} {
 			var sum int
 			for _, n := range descendants {
@@ -64,7 +82,7 @@ func TestSparseBinarySummationTree(t *testing.T) {
 		},
 	} {
 		t.Run(tst.string, func(t *testing.T) {
-			tree.Put(tst.string, tst.int)
+			assert.NoError(t, tree.Put(tst.string, tst.int))
 			assert.Equal(t, expectedIndices[tst.string], indices)
 			indices = nil
 			assert.True(t, leaves[0])
@@ -88,8 +106,8 @@ func TestSparseBinarySummationTree(t *testing.T) {
 		{"", 14},
 	} {
 		t.Run(tst.string, func(t *testing.T) {
-			val, _, ok := tree.Get(tst.string)
-			assert.True(t, ok)
+			val, _, err := tree.Get(tst.string)
+			assert.NoError(t, err)
 			assert.Equal(t, tst.int, val.(int))
 		})
 	}
@@ -98,7 +116,7 @@ func TestSparseBinarySummationTree(t *testing.T) {
 func TestSSNSummationTree(t *testing.T) {
 	tree := Tree{
 		FanOut:  7,
-		ID2Path: DigitPath(7),
+		ID2Path: digitPath(t, 7),
 		UpdateInnerVertex: func(key string, node interface{}, descendants []interface{}, _ bool, _ int) interface{} {
 			var sum int
 			for _, n := range descendants {
@@ -127,7 +145,7 @@ func TestSSNSummationTree(t *testing.T) {
 	} {
 		tst := tst
 		t.Run(tst.string, func(t *testing.T) {
-			tree.Put(tst.string, tst.int)
+			assert.NoError(t, tree.Put(tst.string, tst.int))
 		})
 	}
 
@@ -154,19 +172,24 @@ func TestSSNSummationTree(t *testing.T) {
 	} {
 		tst := tst
 		t.Run(tst.string, func(t *testing.T) {
-			val, _, ok := tree.Get(tst.string)
-			if ok {
+			val, _, err := tree.Get(tst.string)
+			if err == nil {
 				assert.Equalf(t, tst.int, val, "%d is empty", tst.int)
+			} else {
+				assert.True(t, errors.Is(err, common.ErrNotFound))
 			}
-			assert.Equal(t, tst.bool, ok)
+			assert.Equal(t, tst.bool, err == nil)
 		})
 	}
 }
 
 func TestSparsePowerTwoSummationTree(t *testing.T) {
+	hexPath, err := HexId2PathForFanOut(7)
+	assert.NoError(t, err)
+
 	tree := Tree{
 		FanOut:  7,
-		ID2Path: HexId2PathForFanOut(7),
+		ID2Path: hexPath,
 		UpdateInnerVertex: func(key string, node interface{}, descendants []interface{}, _ bool, _ int) interface{} {
 			var sum int
 			for _, n := range descendants {
@@ -197,7 +220,7 @@ func TestSparsePowerTwoSummationTree(t *testing.T) {
 	} {
 		tst := tst
 		t.Run(tst.string, func(t *testing.T) {
-			tree.Put(tst.string, tst.int)
+			assert.NoError(t, tree.Put(tst.string, tst.int))
 		})
 	}
 
@@ -224,11 +247,13 @@ func TestSparsePowerTwoSummationTree(t *testing.T) {
 	} {
 		tst := tst
 		t.Run(tst.string, func(t *testing.T) {
-			val, _, ok := tree.Get(tst.string)
-			if ok {
+			val, _, err := tree.Get(tst.string)
+			if err == nil {
 				assert.Equalf(t, tst.int, val, "%d is empty", tst.int)
+			} else {
+				assert.True(t, errors.Is(err, common.ErrNotFound))
 			}
-			assert.Equal(t, tst.bool, ok)
+			assert.Equal(t, tst.bool, err == nil)
 		})
 	}
 }
@@ -236,7 +261,8 @@ func TestSparsePowerTwoSummationTree(t *testing.T) {
 func TestHexPathIsInCorrectLength(t *testing.T) {
 
 	for fanout := range ExpectedHexPathLengthByFanOut {
-		id2Path := HexId2PathForFanOut(fanout)
+		id2Path, err := HexId2PathForFanOut(fanout)
+		assert.NoError(t, err)
 
 		pathLengths := make(map[int]int)
 
@@ -247,10 +273,352 @@ func TestHexPathIsInCorrectLength(t *testing.T) {
 
 			s := hex.EncodeToString(buff)
 
-			pathLengths[len(id2Path(s))]++
+			path, err := id2Path(s)
+			assert.NoError(t, err)
+			pathLengths[len(path)]++
 		}
 
 		assert.Len(t, pathLengths, 1, pathLengths)
 	}
 
 }
+
+func TestRebuild(t *testing.T) {
+	tree := Tree{
+		FanOut:  2,
+		ID2Path: binaryPath,
+	}
+
+	// Inner vertices hold their key, and leaves hold their value
+	stored := map[string]map[uint16]interface{}{
+		"":   {0: ".0", 1: ".1"},
+		".0": {1: 4},
+		".1": {0: 3, 1: 2},
+	}
+
+	err := tree.Rebuild("", func(key string, data interface{}) (map[uint16]interface{}, error) {
+		if _, isLeaf := data.(int); isLeaf {
+			return nil, nil
+		}
+		assert.Equal(t, key, data)
+		return stored[key], nil
+	})
+	assert.NoError(t, err)
+
+	for _, tst := range []struct {
+		string
+		int
+	}{
+		{"01", 4},
+		{"10", 3},
+		{"11", 2},
+	} {
+		val, path, err := tree.Get(tst.string)
+		assert.NoError(t, err)
+		assert.Equal(t, tst.int, val)
+		assert.Len(t, path, 2)
+	}
+
+	_, _, err = tree.Get("00")
+	assert.True(t, errors.Is(err, common.ErrNotFound))
+
+	stored[".0"] = map[uint16]interface{}{2: 1}
+	err = tree.Rebuild("", func(key string, data interface{}) (map[uint16]interface{}, error) {
+		if _, isLeaf := data.(int); isLeaf {
+			return nil, nil
+		}
+		return stored[key], nil
+	})
+	assert.EqualError(t, err, "vertex .0 has a descendant at 2 but the fanout is 2")
+}
+
+func TestDelete(t *testing.T) {
+	var removed []string
+	tree := Tree{
+		FanOut:  2,
+		ID2Path: binaryPath,
+		UpdateInnerVertex: func(key string, node interface{}, descendants []interface{}, _ bool, _ int) interface{} {
+			var sum int
+			for _, n := range descendants {
+				if n != nil {
+					sum += n.(int)
+				}
+			}
+			return sum
+		},
+		RemoveVertex: func(key string, _ interface{}) {
+			removed = append(removed, key)
+		},
+	}
+
+	assert.NoError(t, tree.Put("000", 5))
+	assert.NoError(t, tree.Put("001", 4))
+	assert.NoError(t, tree.Put("110", 3))
+
+	assert.True(t, errors.Is(tree.Delete("111"), common.ErrNotFound))
+	assert.True(t, errors.Is(tree.Delete("010"), common.ErrNotFound))
+	assert.True(t, errors.Is(tree.Delete("012"), common.ErrInvalidID))
+
+	assert.NoError(t, tree.Delete("001"))
+	assert.Empty(t, removed)
+	sum, _, _ := tree.Get("")
+	assert.Equal(t, 8, sum)
+	_, _, err := tree.Get("001")
+	assert.True(t, errors.Is(err, common.ErrNotFound))
+
+	assert.NoError(t, tree.Delete("110"))
+	assert.Equal(t, []string{".1.1", ".1"}, removed)
+	sum, _, _ = tree.Get("")
+	assert.Equal(t, 5, sum)
+	_, _, err = tree.Get("1")
+	assert.True(t, errors.Is(err, common.ErrNotFound))
+
+	removed = nil
+	assert.NoError(t, tree.Delete("000"))
+	assert.Equal(t, []string{".0.0", ".0", ""}, removed)
+	assert.Nil(t, tree.Root)
+	assert.True(t, errors.Is(tree.Delete("000"), common.ErrNotFound))
+
+	assert.NoError(t, tree.Put("000", 1))
+	sum, _, _ = tree.Get("")
+	assert.Equal(t, 1, sum)
+}
+
+func TestPutBatch(t *testing.T) {
+	sum := func(key string, node interface{}, descendants []interface{}, _ bool) interface{} {
+		var sum int
+		for _, n := range descendants {
+			if n != nil {
+				sum += n.(int)
+			}
+		}
+		return sum
+	}
+
+	var rebuilt sync.Map
+	batched := Tree{
+		FanOut:  7,
+		ID2Path: digitPath(t, 7),
+		RebuildInnerVertex: func(key string, node interface{}, descendants []interface{}, descendantsLeaves bool) interface{} {
+			_, loaded := rebuilt.LoadOrStore(key, struct{}{})
+			assert.False(t, loaded, "%s was rebuilt twice", key)
+			return sum(key, node, descendants, descendantsLeaves)
+		},
+	}
+
+	sequential := Tree{
+		FanOut:  7,
+		ID2Path: digitPath(t, 7),
+		UpdateInnerVertex: func(key string, node interface{}, descendants []interface{}, descendantsLeaves bool, _ int) interface{} {
+			return sum(key, node, descendants, descendantsLeaves)
+		},
+	}
+
+	data := map[string]interface{}{
+		"123456789": 5,
+		"123456780": 4,
+		"123456700": 3,
+		"923456000": 2,
+	}
+
+	assert.NoError(t, batched.PutBatch(data))
+	for id, n := range data {
+		assert.NoError(t, sequential.Put(id, n))
+	}
+
+	for id, n := range data {
+		val, path, err := batched.Get(id)
+		assert.NoError(t, err)
+		assert.Equal(t, n, val)
+		_, expectedPath, _ := sequential.Get(id)
+		assert.Equal(t, expectedPath, path)
+	}
+
+	assert.Equal(t, 14, batched.Root.Data)
+	assert.Equal(t, sequential.Root.Data, batched.Root.Data)
+
+	// A second batch only rebuilds the vertices along its paths
+	rebuilt = sync.Map{}
+	assert.NoError(t, batched.PutBatch(map[string]interface{}{"123456789": 6}))
+	assert.Equal(t, 15, batched.Root.Data)
+
+	var count int
+	rebuilt.Range(func(_, _ interface{}) bool {
+		count++
+		return true
+	})
+	assert.Equal(t, DigitPathLen(7), count)
+
+	// A batch with an invalid id is rejected as a whole
+	rebuilt = sync.Map{}
+	err := batched.PutBatch(map[string]interface{}{"123456788": 1, "12345678": 1})
+	assert.True(t, errors.Is(err, common.ErrInvalidID))
+	assert.Equal(t, 15, batched.Root.Data)
+	_, _, err = batched.Get("123456788")
+	assert.True(t, errors.Is(err, common.ErrNotFound))
+}
+
+func TestPutBatchBySubtree(t *testing.T) {
+	data := map[string]interface{}{
+		"123456789": 5,
+		"123456780": 4,
+		"123456700": 3,
+		"923456000": 2,
+	}
+
+	subtrees := make(map[uint16]struct{})
+	for id := range data {
+		path, err := digitPath(t, 7)(id)
+		assert.NoError(t, err)
+		subtrees[path[0]] = struct{}{}
+	}
+
+	var lock sync.Mutex
+	rebuilt := make(map[string]struct{})
+	done := make(map[uint16]struct{})
+
+	bySubtree := Tree{
+		FanOut:  7,
+		ID2Path: digitPath(t, 7),
+		RebuildInnerVertex: func(key string, node interface{}, descendants []interface{}, descendantsLeaves bool) interface{} {
+			lock.Lock()
+			defer lock.Unlock()
+
+			_, exists := rebuilt[key]
+			assert.False(t, exists, "%s was rebuilt twice", key)
+			rebuilt[key] = struct{}{}
+
+			// The root is rebuilt after all the subtrees are done, and no vertex of a subtree is rebuilt after it is done
+			if key == "" {
+				assert.Len(t, done, len(subtrees))
+			} else {
+				index, err := strconv.Atoi(strings.Split(key, ".")[1])
+				assert.NoError(t, err)
+				assert.NotContains(t, done, uint16(index))
+			}
+
+			var sum int
+			for _, n := range descendants {
+				if n != nil {
+					sum += n.(int)
+				}
+			}
+			return sum
+		},
+	}
+	batched := bySubtree
+	batched.RebuildInnerVertex = func(key string, node interface{}, descendants []interface{}, descendantsLeaves bool) interface{} {
+		var sum int
+		for _, n := range descendants {
+			if n != nil {
+				sum += n.(int)
+			}
+		}
+		return sum
+	}
+
+	assert.NoError(t, bySubtree.PutBatchBySubtree(data, func(index uint16) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		assert.NotContains(t, done, index)
+		done[index] = struct{}{}
+	}))
+	assert.Len(t, done, len(subtrees))
+	assert.NoError(t, batched.PutBatch(data))
+
+	for id, n := range data {
+		val, path, err := bySubtree.Get(id)
+		assert.NoError(t, err)
+		assert.Equal(t, n, val)
+		_, expectedPath, _ := batched.Get(id)
+		assert.Equal(t, len(expectedPath), len(path))
+		for i := range path {
+			assert.Equal(t, expectedPath[i].(*Vertex).Data, path[i].(*Vertex).Data)
+		}
+	}
+	assert.Equal(t, 14, bySubtree.Root.Data)
+
+	// A batch with an invalid id is rejected as a whole
+	err := bySubtree.PutBatchBySubtree(map[string]interface{}{"123456788": 1, "12345678": 1}, nil)
+	assert.True(t, errors.Is(err, common.ErrInvalidID))
+	assert.Equal(t, 14, bySubtree.Root.Data)
+}
+
+func TestGetPrefix(t *testing.T) {
+	tree := Tree{
+		FanOut:  2,
+		ID2Path: binaryPath,
+		UpdateInnerVertex: func(key string, node interface{}, descendants []interface{}, _ bool, _ int) interface{} {
+			return key
+		},
+	}
+
+	path, ok, err := tree.GetPrefix("000")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Empty(t, path)
+
+	assert.NoError(t, tree.Put("000", 5))
+	assert.NoError(t, tree.Put("110", 3))
+
+	_, expected, _ := tree.Get("000")
+	path, ok, _ = tree.GetPrefix("000")
+	assert.True(t, ok)
+	assert.Equal(t, expected, path)
+
+	path, ok, _ = tree.GetPrefix("001")
+	assert.False(t, ok)
+	assert.Equal(t, expected, path)
+
+	path, ok, _ = tree.GetPrefix("011")
+	assert.False(t, ok)
+	assert.Equal(t, expected[:2], path)
+
+	path, ok, _ = tree.GetPrefix("100")
+	assert.False(t, ok)
+	assert.Len(t, path, 2)
+	assert.Equal(t, ".1", path[1].(*Vertex).Data)
+
+	_, _, err = tree.GetPrefix("002")
+	assert.True(t, errors.Is(err, common.ErrInvalidID))
+}
+
+func TestDigitPath(t *testing.T) {
+	_, err := DigitPath(6)
+	assert.True(t, errors.Is(err, common.ErrUnsupportedFanout))
+	_, err = HexId2PathForFanOut(6)
+	assert.True(t, errors.Is(err, common.ErrUnsupportedFanout))
+	_, err = HexId2PathForFanOut(1)
+	assert.True(t, errors.Is(err, common.ErrUnsupportedFanout))
+
+	id2Path := digitPath(t, 7)
+	for _, id := range []string{"", "12345678", "1234567890", "12345678a", "-12345678", "+12345678"} {
+		_, err := id2Path(id)
+		assert.True(t, errors.Is(err, common.ErrInvalidID), id)
+	}
+
+	// Small numbers are padded with zeros, so all paths are of the same length
+	for _, id := range []string{"000000000", "000000001", "123456789", "999999999"} {
+		path, err := id2Path(id)
+		assert.NoError(t, err)
+		assert.Len(t, path, DigitPathLen(7), id)
+	}
+
+	// Only ids of fewer than DigitPathLen-1 digits in base fanout are padded with more than a single zero,
+	// so the paths of larger ids are the same as when a single zero was padded
+	for id, expected := range map[string][]uint16{
+		"000000001": {1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		"040353606": {6, 6, 6, 6, 6, 6, 6, 6, 6, 0, 0},
+		"123456789": {1, 2, 2, 6, 3, 2, 6, 2, 0, 3, 0},
+	} {
+		path, err := id2Path(id)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, path, id)
+	}
+
+	hexPath, err := HexId2PathForFanOut(7)
+	assert.NoError(t, err)
+	_, err = hexPath("not hex")
+	assert.True(t, errors.Is(err, common.ErrInvalidID))
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"bytes"
+
+	"pol/kv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// bucket is the bucket of the Bolt database that holds all the keys
+var bucket = []byte("pol")
+
+// boltKey prefixes the key with a byte, as Bolt does not permit empty keys, such as the key of the root
+func boltKey(key []byte) []byte {
+	return append([]byte{0}, key...)
+}
+
+// Bolt is a DB persisted to a BoltDB file.
+// Batches are committed as a single transaction, whose pages only become reachable once the meta page that points to them is written.
+type Bolt struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens the BoltDB file in the given path, and creates it if it does not exist.
+func OpenBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Bolt{db: db}, nil
+}
+
+func (b *Bolt) Get(key []byte) ([]byte, error) {
+	var val []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		// The value is only valid while the transaction is open
+		if v := tx.Bucket(bucket).Get(boltKey(key)); v != nil {
+			val = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return val, err
+}
+
+func (b *Bolt) Put(key []byte, val []byte) error {
+	batch := b.NewBatch()
+	batch.Put(key, val)
+	return batch.Commit()
+}
+
+func (b *Bolt) Delete(key []byte) error {
+	batch := b.NewBatch()
+	batch.Delete(key)
+	return batch.Commit()
+}
+
+func (b *Bolt) NewBatch() kv.Batch {
+	return &boltBatch{db: b.db}
+}
+
+func (b *Bolt) Iterate(prefix []byte, f func(key, val []byte) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		prefix := boltKey(prefix)
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			// The keys and values are only valid while the transaction is open
+			if err := f(append([]byte(nil), k[1:]...), append([]byte(nil), v...)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes the database, after which it can no longer be used.
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+// boltBatch buffers the writes of a batch, as a Bolt transaction holds the lock of the database until it ends
+type boltBatch struct {
+	db     *bolt.DB
+	writes []write
+}
+
+// write is a write of a batch, which deletes the key if the value is nil
+type write struct {
+	key, val []byte
+}
+
+func (b *boltBatch) Put(key []byte, val []byte) {
+	b.writes = append(b.writes, write{key: key, val: val})
+}
+
+func (b *boltBatch) Delete(key []byte) {
+	b.writes = append(b.writes, write{key: key})
+}
+
+func (b *boltBatch) Commit() error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(bucket)
+		for _, w := range b.writes {
+			var err error
+			if w.val == nil {
+				err = bkt.Delete(boltKey(w.key))
+			} else {
+				err = bkt.Put(boltKey(w.key), w.val)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
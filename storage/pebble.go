@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"errors"
+
+	"pol/kv"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Pebble is a DB persisted to a Pebble database in a directory.
+// Batches are appended to the write-ahead log of the database as a single record, which is either replayed in full after a crash or not at all.
+type Pebble struct {
+	db *pebble.DB
+}
+
+// OpenPebble opens the Pebble database in the given directory, and creates it if it does not exist.
+func OpenPebble(dir string) (*Pebble, error) {
+	db, err := pebble.Open(dir, &pebble.Options{})
+	if err != nil {
+		return nil, err
+	}
+	return &Pebble{db: db}, nil
+}
+
+func (p *Pebble) Get(key []byte) ([]byte, error) {
+	val, closer, err := p.db.Get(key)
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	// The value is only valid until the closer is closed
+	return append([]byte(nil), val...), nil
+}
+
+func (p *Pebble) Put(key []byte, val []byte) error {
+	return p.db.Set(key, val, pebble.Sync)
+}
+
+func (p *Pebble) Delete(key []byte) error {
+	return p.db.Delete(key, pebble.Sync)
+}
+
+func (p *Pebble) NewBatch() kv.Batch {
+	return &pebbleBatch{batch: p.db.NewBatch()}
+}
+
+func (p *Pebble) Iterate(prefix []byte, f func(key, val []byte) error) error {
+	it := p.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixEnd(prefix),
+	})
+
+	for it.First(); it.Valid(); it.Next() {
+		// The iterator reuses the buffers of the keys and values it returns
+		key := append([]byte(nil), it.Key()...)
+		val := append([]byte(nil), it.Value()...)
+		if err := f(key, val); err != nil {
+			it.Close()
+			return err
+		}
+	}
+
+	return it.Close()
+}
+
+// Close closes the database, after which it can no longer be used.
+func (p *Pebble) Close() error {
+	return p.db.Close()
+}
+
+type pebbleBatch struct {
+	batch *pebble.Batch
+	// err is the first error of adding a write to the batch
+	err error
+}
+
+func (b *pebbleBatch) Put(key []byte, val []byte) {
+	if err := b.batch.Set(key, val, nil); err != nil && b.err == nil {
+		b.err = err
+	}
+}
+
+func (b *pebbleBatch) Delete(key []byte) {
+	if err := b.batch.Delete(key, nil); err != nil && b.err == nil {
+		b.err = err
+	}
+}
+
+func (b *pebbleBatch) Commit() error {
+	defer b.batch.Close()
+
+	if b.err != nil {
+		return b.err
+	}
+	return b.batch.Commit(pebble.Sync)
+}
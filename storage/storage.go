@@ -0,0 +1,17 @@
+// Package storage provides DBs that persist the vertices of liability sets to disk.
+// Every batch is committed as a single write that is synced to disk before the commit returns,
+// so a liability set opened after a crash is as of the last update of the tree that was committed.
+package storage
+
+// prefixEnd returns the smallest key that is greater than every key with the given prefix,
+// or nil if there is no such key.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	mathrand "math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"pol/kv"
+	"pol/pol"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type closableDB interface {
+	kv.DB
+	io.Closer
+}
+
+var backends = map[string]func(dir string) (closableDB, error){
+	"pebble": func(dir string) (closableDB, error) {
+		return OpenPebble(filepath.Join(dir, "pebble"))
+	},
+	"bolt": func(dir string) (closableDB, error) {
+		return OpenBolt(filepath.Join(dir, "bolt.db"))
+	},
+}
+
+func TestBackends(t *testing.T) {
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			db, err := open(dir)
+			assert.NoError(t, err)
+
+			// The root of a tree is stored under the empty key
+			batch := db.NewBatch()
+			batch.Put([]byte(""), []byte{1})
+			batch.Put([]byte(".0"), []byte{2})
+			batch.Put([]byte(".1"), []byte{3})
+			batch.Put([]byte(".1.0"), []byte{4})
+			batch.Delete([]byte(".0"))
+			assert.NoError(t, batch.Commit())
+			assert.NoError(t, db.Put([]byte("snapshots"), []byte{5}))
+			assert.NoError(t, db.Delete([]byte(".1.0")))
+			assert.NoError(t, db.Close())
+
+			// Everything that was committed survives reopening the DB
+			db, err = open(dir)
+			assert.NoError(t, err)
+			defer db.Close()
+
+			for key, expected := range map[string][]byte{"": {1}, ".0": nil, ".1": {3}, ".1.0": nil, "snapshots": {5}} {
+				val, err := db.Get([]byte(key))
+				assert.NoError(t, err)
+				assert.Equal(t, expected, val, key)
+			}
+
+			var keys []string
+			assert.NoError(t, db.Iterate(nil, func(key, _ []byte) error {
+				keys = append(keys, string(key))
+				return nil
+			}))
+			assert.Equal(t, []string{"", ".1", "snapshots"}, keys)
+
+			keys = nil
+			assert.NoError(t, db.Iterate([]byte("."), func(key, _ []byte) error {
+				keys = append(keys, string(key))
+				return nil
+			}))
+			assert.Equal(t, []string{".1"}, keys)
+
+			stop := errors.New("stop")
+			assert.Equal(t, stop, db.Iterate(nil, func(_, _ []byte) error {
+				return stop
+			}))
+		})
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Nil(t, prefixEnd(nil))
+	assert.Nil(t, prefixEnd([]byte{0xff, 0xff}))
+	assert.Equal(t, []byte("snapshot0"), prefixEnd([]byte("snapshot/")))
+	assert.Equal(t, []byte{1, 3}, prefixEnd([]byte{1, 2, 0xff}))
+}
+
+// crashWriterEnv holds the backend and directory of the writer that TestCrashWriter runs in a separate process
+const crashWriterEnv = "POL_CRASH_WRITER"
+
+const fanout = 7
+
+// crashID returns the i'th id set by the writer, and its liability is i+1
+func crashID(i int) string {
+	return fmt.Sprintf("%09d", (i*7919)%1000000000)
+}
+
+// TestCrashConsistency kills processes that set liabilities in the middle of their writes,
+// and checks that the liability set is opened as of the last update that was committed before every crash.
+func TestCrashConsistency(t *testing.T) {
+	if testing.Short() {
+		t.Skip("crashes processes")
+	}
+
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			_, pp, err := pol.GeneratePublicParams(fanout, pol.Dense)
+			assert.NoError(t, err)
+			f, err := os.Create(filepath.Join(dir, "pp"))
+			assert.NoError(t, err)
+			_, err = pp.WriteTo(f)
+			assert.NoError(t, err)
+			assert.NoError(t, f.Close())
+
+			var count int
+			for crash := 0; crash < 3; crash++ {
+				reported := crashWriter(t, name, dir, 3+mathrand.Intn(5))
+				assert.True(t, reported > count, "the writer made no progress")
+
+				db, err := open(dir)
+				assert.NoError(t, err)
+
+				id2Path, pp := readPublicParams(t, dir)
+				ls, err := pol.OpenLiabilitySet(pp, db, id2Path)
+				assert.NoError(t, err)
+
+				// The update in progress when the writer was killed was either committed in full or not at all
+				count = countLiabilities(t, ls)
+				assert.True(t, count == reported || count == reported+1, "%d liabilities after %d were reported", count, reported)
+
+				assertConsistent(t, ls, pp, id2Path, count)
+				assert.NoError(t, db.Close())
+			}
+		})
+	}
+}
+
+// crashWriter starts a writer that sets liabilities in a separate process, and kills it once it reports
+// that the given number of updates were committed and a random time has passed. Returns the number of liabilities it reported.
+func crashWriter(t *testing.T, backend, dir string, updates int) int {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCrashWriter$")
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s:%s", crashWriterEnv, backend, dir))
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	assert.NoError(t, err)
+	assert.NoError(t, cmd.Start())
+
+	var reported int
+	scanner := bufio.NewScanner(stdout)
+	for i := 0; i < updates && scanner.Scan(); i++ {
+		reported, err = strconv.Atoi(scanner.Text())
+		assert.NoError(t, err)
+	}
+
+	// An update takes a few milliseconds, so the writer is likely to be killed in the middle of one
+	time.Sleep(time.Duration(mathrand.Intn(5000)) * time.Microsecond)
+	assert.NoError(t, cmd.Process.Kill())
+	cmd.Wait()
+
+	return reported
+}
+
+// TestCrashWriter sets liabilities until it is killed, and reports the number of liabilities after every update.
+// It only runs in the processes started by TestCrashConsistency.
+func TestCrashWriter(t *testing.T) {
+	env := os.Getenv(crashWriterEnv)
+	if env == "" {
+		t.Skip("only runs in a writer process")
+	}
+
+	backend, dir, _ := strings.Cut(env, ":")
+	db, err := backends[backend](dir)
+	if err != nil {
+		panic(err)
+	}
+
+	id2Path, pp := readPublicParams(t, dir)
+	ls, err := pol.OpenLiabilitySet(pp, db, id2Path)
+	if err != nil {
+		panic(err)
+	}
+
+	for i := countLiabilities(t, ls); ; i++ {
+		if err := ls.Set(crashID(i), big.NewInt(int64(i+1))); err != nil {
+			panic(err)
+		}
+		fmt.Println(i + 1)
+	}
+}
+
+func readPublicParams(t *testing.T, dir string) (func(string) ([]uint16, error), *pol.PublicParams) {
+	f, err := os.Open(filepath.Join(dir, "pp"))
+	assert.NoError(t, err)
+	defer f.Close()
+
+	id2Path, pp, err := pol.ReadPublicParams(f)
+	assert.NoError(t, err)
+	return id2Path, pp
+}
+
+// countLiabilities returns the number of ids set by the writer that are in the liability set
+func countLiabilities(t *testing.T, ls *pol.LiabilitySet) int {
+	var count int
+	for {
+		_, err := ls.Get(crashID(count))
+		if errors.Is(err, pol.ErrNotFound) {
+			return count
+		}
+		assert.NoError(t, err)
+		count++
+	}
+}
+
+// assertConsistent checks that the root commits to the liabilities of the given number of ids,
+// and that the vertices along the path of the last of them open to that root.
+func assertConsistent(t *testing.T, ls *pol.LiabilitySet, pp *pol.PublicParams, id2Path func(string) ([]uint16, error), count int) {
+	V, W := ls.Root()
+	assert.NotNil(t, V)
+
+	total, err := ls.ProveTot()
+	assert.NoError(t, err)
+	assert.NoError(t, total.Verify(pp, V))
+	assert.Equal(t, int64(count*(count+1)/2), total.Sum.Int64())
+
+	last := crashID(count - 1)
+	liability, proof, _, err := ls.ProveLiability(last)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(count), liability.Int64())
+	_, err = proof.Verify(pp, last, V, W, id2Path)
+	assert.NoError(t, err)
+}
@@ -2,6 +2,8 @@ package sum
 
 import (
 	"crypto/sha256"
+	"encoding/json"
+	"fmt"
 	"pol/bp"
 	"pol/common"
 
@@ -21,6 +23,9 @@ type PP struct {
 	H      common.G1v
 	b      common.Vec
 	B      *math.G1
+	// Assets is the number of consecutive blocks of equal size the vectors are made of,
+	// where the last entry of every block is the sum of the rest of the entries in the block.
+	Assets int
 }
 
 func (pp *PP) Size() int {
@@ -28,21 +33,44 @@ func (pp *PP) Size() int {
 }
 
 func NewPublicParams(n int) *PP {
+	return NewMultiAssetPublicParams(n, 1)
+}
+
+// NewMultiAssetPublicParams creates public parameters for vectors of size n made of the given number of blocks,
+// each with a sum of its own.
+func NewMultiAssetPublicParams(n, assets int) *PP {
+	if assets < 1 || n%assets != 0 {
+		panic(fmt.Sprintf("cannot split vectors of size %d into %d blocks", n, assets))
+	}
+
 	pp := &PP{
-		Gs: common.RandGenVec(n, "sum argument Gs"),
-		U:  common.RandGenVec(1, "IPA u")[0],
-		G:  common.RandGenVec(1, "sum argument G")[0],
-		F:  common.RandGenVec(1, "sum argument F")[0],
-		H:  common.RandGenVec(n, "sum argument H"),
-		b:  make([]*math.Zr, n),
+		Assets: assets,
+		Gs:     common.RandGenVec(n, "sum argument Gs"),
+		U:      common.RandGenVec(1, "IPA u")[0],
+		G:      common.RandGenVec(1, "sum argument G")[0],
+		F:      common.RandGenVec(1, "sum argument F")[0],
+		H:      common.RandGenVec(n, "sum argument H"),
 	}
 
+	pp.setup()
+
+	return pp
+}
+
+// setup derives b, B and the digest from the generators
+func (pp *PP) setup() {
+	n := len(pp.H)
+	pp.b = make([]*math.Zr, n)
+
+	block := n / pp.Assets
 	for i := 0; i < n; i++ {
 		pp.b[i] = curve.NewZrFromInt(1)
+		if i%block == block-1 {
+			pp.b[i] = negZr(curve.NewZrFromInt(1))
+		}
 	}
-	pp.b[n-1] = negZr(curve.NewZrFromInt(1))
 
-	pp.B = pp.H.MulV(pp.b).Sum()
+	pp.B = common.MultiExp(pp.H, pp.b)
 
 	h := sha256.New()
 	for i := 0; i < n; i++ {
@@ -51,9 +79,74 @@ func NewPublicParams(n int) *PP {
 	h.Write(pp.B.Bytes())
 	h.Write(pp.G.Bytes())
 	h.Write(pp.F.Bytes())
+	h.Write([]byte{byte(pp.Assets >> 8), byte(pp.Assets)})
 	pp.Digest = h.Sum(nil)
+}
 
-	return pp
+// RecomputeDigest recomputes the digest after the generators have been replaced
+func (pp *PP) RecomputeDigest() {
+	pp.setup()
+}
+
+// coefficients returns the vector b such that <v, b> = 0 for the vector v committed in V, and B = H·b.
+// When there are several blocks, the sums of the blocks are weighted by the powers of a challenge derived from V,
+// so a vector whose inner product with b is zero has the right sum in every block with overwhelming probability.
+func (pp *PP) coefficients(V *math.G1) (common.Vec, *math.G1) {
+	if pp.Assets <= 1 {
+		return pp.b, pp.B
+	}
+
+	h := sha256.New()
+	h.Write(pp.Digest)
+	h.Write(V.Bytes())
+	τ := common.FieldElementFromBytes(h.Sum(nil))
+
+	block := len(pp.b) / pp.Assets
+	weights := common.PowerSeries(pp.Assets, τ)
+
+	b := make(common.Vec, len(pp.b))
+	for i := range b {
+		b[i] = pp.b[i].Mul(weights[i/block])
+		b[i].Mod(GroupOrder)
+	}
+
+	return b, common.MultiExp(pp.H, b)
+}
+
+func (pp *PP) MarshalBinary() ([]byte, error) {
+	e := common.NewEncoder()
+	e.G1(pp.U)
+	e.G1v(pp.Gs)
+	e.G1(pp.G)
+	e.G1(pp.F)
+	e.G1v(pp.H)
+	e.Uint32(uint32(pp.Assets))
+	return e.Result()
+}
+
+func (pp *PP) UnmarshalBinary(b []byte) error {
+	d := common.NewDecoder(b)
+	loaded := &PP{
+		U:  d.G1(),
+		Gs: d.G1v(),
+		G:  d.G1(),
+		F:  d.G1(),
+		H:  d.G1v(),
+	}
+	loaded.Assets = int(d.Uint32())
+	if err := d.Finish(); err != nil {
+		return err
+	}
+	if len(loaded.H) == 0 || len(loaded.H) != len(loaded.Gs) {
+		return fmt.Errorf("expected Gs and H of the same non-zero size but got %d and %d", len(loaded.Gs), len(loaded.H))
+	}
+	if loaded.Assets < 1 || len(loaded.H)%loaded.Assets != 0 {
+		return fmt.Errorf("cannot split vectors of size %d into %d blocks", len(loaded.H), loaded.Assets)
+	}
+
+	loaded.setup()
+	*pp = *loaded
+	return nil
 }
 
 type Argument struct {
@@ -81,7 +174,7 @@ func NewAggregatedArgument(pp *PP, V common.G1v, v []common.Vec, r common.Vec) *
 
 	rAggr := r.InnerProd(t)
 
-	VAggr := V.MulV(t).Sum()
+	VAggr := common.MultiExp(V, t)
 
 	_, proof := NewArgument(pp, VAggr, vAggr, rAggr)
 	return proof
@@ -106,9 +199,66 @@ func (proof *Proof) Size() int {
 	return len(proof.c.Bytes()) + len(proof.W.Bytes()) + len(proof.ρ.Bytes())
 }
 
+func (proof *Proof) MarshalBinary() ([]byte, error) {
+	e := common.NewEncoder()
+	e.G1(proof.W)
+	e.Zr(proof.c)
+	e.Zr(proof.ρ)
+	e.Marshaler(proof.π)
+	return e.Result()
+}
+
+func (proof *Proof) UnmarshalBinary(b []byte) error {
+	d := common.NewDecoder(b)
+	proof.W = d.G1()
+	proof.c = d.Zr()
+	proof.ρ = d.Zr()
+	proof.π = &bp.InnerProductProof{}
+	d.Unmarshaler(proof.π)
+	return d.Finish()
+}
+
+type proofJSON struct {
+	W                 string          `json:"w"`
+	C                 string          `json:"c"`
+	Rho               string          `json:"rho"`
+	InnerProductProof json.RawMessage `json:"innerProductProof"`
+}
+
+func (proof *Proof) MarshalJSON() ([]byte, error) {
+	var e common.HexEncoder
+	raw := proofJSON{
+		W:   e.G1(proof.W),
+		C:   e.Zr(proof.c),
+		Rho: e.Zr(proof.ρ),
+	}
+	raw.InnerProductProof = e.JSON(proof.π)
+	if err := e.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+func (proof *Proof) UnmarshalJSON(b []byte) error {
+	var raw proofJSON
+	if err := common.UnmarshalJSONStrict(b, &raw); err != nil {
+		return err
+	}
+	var d common.HexDecoder
+	proof.W = d.G1(raw.W)
+	proof.c = d.Zr(raw.C)
+	proof.ρ = d.Zr(raw.Rho)
+	proof.π = &bp.InnerProductProof{}
+	d.JSON(raw.InnerProductProof, proof.π)
+	return d.Err()
+}
+
 func (proof *Proof) VerifyAggregated(pp *PP, V common.G1v) error {
+	if len(V) == 0 || V.HasNil() {
+		return fmt.Errorf("%w: commitments are missing", common.ErrMalformedProof)
+	}
 	t := createHVZKChallenge(V, len(V))
-	VAggr := V.MulV(t).Sum()
+	VAggr := common.MultiExp(V, t)
 
 	return proof.Verify(pp, &Argument{
 		V: VAggr,
@@ -118,17 +268,19 @@ func (proof *Proof) VerifyAggregated(pp *PP, V common.G1v) error {
 func NewCommitment(pp *PP, v common.Vec, r *math.Zr) *Argument {
 	G := pp.Gs
 	V := pp.F.Mul(r)
-	V.Add(G.MulV(v).Sum())
-
-	// Sanity check of the sum argument
-	sum := v[0].Copy()
-	n := len(v)
-	for i := 1; i < n-1; i++ {
-		sum = sum.Plus(v[i])
-	}
+	V.Add(common.MultiExp(G, v))
+
+	// Sanity check of the sum argument in every block
+	n := len(v) / pp.Assets
+	for block := v; len(block) > 0; block = block[n:] {
+		sum := block[0].Copy()
+		for i := 1; i < n-1; i++ {
+			sum = sum.Plus(block[i])
+		}
 
-	if !v[n-1].Equals(sum) {
-		panic("v[n-1] != Σv[j] j: 0->n-2")
+		if !block[n-1].Equals(sum) {
+			panic("v[n-1] != Σv[j] j: 0->n-2")
+		}
 	}
 
 	return &Argument{V: V}
@@ -140,9 +292,11 @@ func NewArgument(pp *PP, V *math.G1, v common.Vec, r *math.Zr) (*Argument, *Proo
 	w, rPrime := common.RandVec(n), common.RandVec(1)[0]
 
 	W := pp.F.Mul(rPrime)
-	W.Add(pp.Gs.MulV(w).Sum())
+	W.Add(common.MultiExp(pp.Gs, w))
+
+	b, B := pp.coefficients(V)
 
-	c := w.InnerProd(pp.b)
+	c := w.InnerProd(b)
 
 	x := randomOracleCVW(c, V, W)
 
@@ -154,7 +308,7 @@ func NewArgument(pp *PP, V *math.G1, v common.Vec, r *math.Zr) (*Argument, *Proo
 	P := pp.F.Mul(ρ)
 	P.Add(V)
 	P.Add(W.Mul(x))
-	P.Add(pp.B)
+	P.Add(B)
 
 	ipaPP := &bp.PP{
 		G: pp.Gs,
@@ -165,7 +319,6 @@ func NewArgument(pp *PP, V *math.G1, v common.Vec, r *math.Zr) (*Argument, *Proo
 	ipaPP.RecomputeDigest()
 
 	a := v.Add(w.Mul(x))
-	b := pp.b
 
 	π := bp.NewInnerProdArgument(ipaPP, a, b).Prove()
 
@@ -183,12 +336,21 @@ func randomOracleCVW(c *math.Zr, V *math.G1, W *math.G1) *math.Zr {
 }
 
 func (proof *Proof) Verify(pp *PP, a *Argument) error {
+	if proof == nil || proof.W == nil || proof.c == nil || proof.ρ == nil || proof.π == nil {
+		return fmt.Errorf("%w: sum argument proof is incomplete", common.ErrMalformedProof)
+	}
+	if a == nil || a.V == nil {
+		return fmt.Errorf("%w: commitment is missing", common.ErrMalformedProof)
+	}
+
 	x := randomOracleCVW(proof.c, a.V, proof.W)
 
+	_, B := pp.coefficients(a.V)
+
 	P := pp.F.Mul(proof.ρ)
 	P.Add(a.V)
 	P.Add(proof.W.Mul(x))
-	P.Add(pp.B)
+	P.Add(B)
 
 	ipaPP := &bp.PP{
 		G: pp.Gs,
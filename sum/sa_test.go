@@ -2,6 +2,7 @@ package sum
 
 import (
 	"crypto/rand"
+	"encoding/json"
 	"pol/common"
 	"testing"
 
@@ -57,3 +58,88 @@ func TestAggregatedSumArgument(t *testing.T) {
 	err := π.VerifyAggregated(pp, Vs)
 	assert.NoError(t, err)
 }
+
+func TestSumArgumentEncoding(t *testing.T) {
+	n := 8
+	pp := NewPublicParams(n)
+
+	v, r, V := randomCommitment(n, pp)
+	_, π := NewArgument(pp, V, v, r)
+
+	raw, err := π.MarshalBinary()
+	assert.NoError(t, err)
+
+	decoded := &Proof{}
+	assert.NoError(t, decoded.UnmarshalBinary(raw))
+	assert.NoError(t, decoded.Verify(pp, &Argument{V: V}))
+
+	raw2, err := decoded.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, raw, raw2)
+
+	assert.Error(t, decoded.UnmarshalBinary(append(raw, 0)))
+
+	rawJSON, err := json.Marshal(π)
+	assert.NoError(t, err)
+
+	decoded = &Proof{}
+	assert.NoError(t, json.Unmarshal(rawJSON, decoded))
+	assert.NoError(t, decoded.Verify(pp, &Argument{V: V}))
+
+	rawJSON2, err := json.Marshal(decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, rawJSON, rawJSON2)
+}
+
+func TestPublicParamsEncoding(t *testing.T) {
+	n := 8
+	pp := NewPublicParams(n)
+
+	raw, err := pp.MarshalBinary()
+	assert.NoError(t, err)
+
+	var decoded PP
+	assert.NoError(t, decoded.UnmarshalBinary(raw))
+	assert.Equal(t, pp.Digest, decoded.Digest)
+
+	v, r, V := randomCommitment(n, pp)
+	sa, π := NewArgument(pp, V, v, r)
+	assert.NoError(t, π.Verify(&decoded, sa))
+
+	assert.Error(t, decoded.UnmarshalBinary(raw[:len(raw)-1]))
+}
+
+func TestMultiAssetSumArgument(t *testing.T) {
+	n, assets := 16, 4
+	pp := NewMultiAssetPublicParams(n, assets)
+
+	var Vs common.G1v
+	var vs []common.Vec
+	var rs common.Vec
+
+	for i := 0; i < 3; i++ {
+		var v common.Vec
+		for j := 0; j < assets; j++ {
+			block, _, _ := randomCommitment(n/assets, NewPublicParams(n/assets))
+			v = append(v, block...)
+		}
+		r := curve.NewRandomZr(rand.Reader)
+		Vs = append(Vs, NewCommitment(pp, v, r).V)
+		vs = append(vs, v)
+		rs = append(rs, r)
+	}
+
+	π := NewAggregatedArgument(pp, Vs, vs, rs)
+	assert.NoError(t, π.VerifyAggregated(pp, Vs))
+
+	// Sums that are only right across all blocks are rejected
+	v := vs[0]
+	v[n/assets-1] = v[n/assets-1].Plus(curve.NewZrFromInt(1))
+	v[n-1] = curve.ModSub(v[n-1], curve.NewZrFromInt(1), curve.GroupOrder)
+	r := curve.NewRandomZr(rand.Reader)
+	V := pp.F.Mul(r)
+	V.Add(pp.Gs.MulV(v).Sum())
+
+	_, π = NewArgument(pp, V, v, r)
+	assert.Error(t, π.Verify(pp, &Argument{V: V}))
+}
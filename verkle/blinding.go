@@ -0,0 +1,82 @@
+package verkle
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"pol/common"
+
+	math "github.com/IBM/mathlib"
+)
+
+// BlindingSource derives the blinding factors of vertices, so that a vertex can be regenerated from the source
+// and the liabilities of its descendants, instead of its blinding factor only being kept in the DB.
+// It may be invoked concurrently.
+type BlindingSource interface {
+	// BlindingFactor returns the blinding factor of the vertex of the given key that is created by the given version of the tree
+	// in the given epoch. No two vertices are created by the same version with the same key.
+	BlindingFactor(epoch uint64, key string, version uint64) *math.Zr
+}
+
+// MasterKeys is a BlindingSource that derives blinding factors with a PRF keyed by a master key.
+// Every master key is used from its epoch onwards, until the epoch of the next master key.
+type MasterKeys struct {
+	epochs []uint64
+	keys   [][]byte
+}
+
+// NewMasterKeys returns a source that derives blinding factors from the given master key, starting from epoch 0.
+func NewMasterKeys(masterKey []byte) *MasterKeys {
+	return &MasterKeys{
+		epochs: []uint64{0},
+		keys:   [][]byte{masterKey},
+	}
+}
+
+// Rotate replaces the master key from the given epoch onwards, which must be after the epoch of the previous rotation.
+// Blinding factors of earlier epochs are still derived from the master keys they were derived from.
+// It must not be invoked concurrently with updates of a tree that uses the source.
+func (mk *MasterKeys) Rotate(epoch uint64, masterKey []byte) error {
+	if latest := mk.epochs[len(mk.epochs)-1]; epoch <= latest {
+		return fmt.Errorf("epoch %d is not after the epoch %d of the previous master key", epoch, latest)
+	}
+	mk.epochs = append(mk.epochs, epoch)
+	mk.keys = append(mk.keys, masterKey)
+	return nil
+}
+
+// masterKey returns the master key of the given epoch
+func (mk *MasterKeys) masterKey(epoch uint64) []byte {
+	i := len(mk.epochs) - 1
+	for mk.epochs[i] > epoch {
+		i--
+	}
+	return mk.keys[i]
+}
+
+// BlindingFactor returns PRF(masterKey, epoch, key, version) for the master key of the epoch.
+func (mk *MasterKeys) BlindingFactor(epoch uint64, key string, version uint64) *math.Zr {
+	masterKey := mk.masterKey(epoch)
+
+	// Twice as many bits as the group order, so that the reduction is statistically close to uniform
+	var wide []byte
+	for _, block := range []byte{1, 2} {
+		mac := hmac.New(sha256.New, masterKey)
+		mac.Write([]byte{block})
+		mac.Write(uint64Bytes(epoch))
+		mac.Write(uint64Bytes(version))
+		mac.Write([]byte(key))
+		wide = mac.Sum(wide)
+	}
+
+	n := new(big.Int).SetBytes(wide)
+	return common.BigToZr(n.Mod(n, groupOrder))
+}
+
+func uint64Bytes(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
@@ -0,0 +1,110 @@
+package verkle
+
+import (
+	"fmt"
+	"math/big"
+	"pol/kv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMasterKeys(t *testing.T) {
+	source := NewMasterKeys([]byte("master key"))
+
+	// The blinding factors are deterministic
+	assert.True(t, source.BlindingFactor(1, ".1.2", 2).Equals(NewMasterKeys([]byte("master key")).BlindingFactor(1, ".1.2", 2)))
+
+	// and differ in the master key, the epoch, the key and the version
+	distinct := []*struct {
+		source         *MasterKeys
+		epoch, version uint64
+		key            string
+	}{
+		{source, 1, 2, ".1.2"},
+		{NewMasterKeys([]byte("another master key")), 1, 2, ".1.2"},
+		{source, 2, 2, ".1.2"},
+		{source, 1, 3, ".1.2"},
+		{source, 1, 2, ".1.3"},
+		{source, 1, 2, ""},
+	}
+	seen := make(map[string]struct{})
+	for _, d := range distinct {
+		seen[string(d.source.BlindingFactor(d.epoch, d.key, d.version).Bytes())] = struct{}{}
+	}
+	assert.Len(t, seen, len(distinct))
+
+	// A rotated master key is used from its epoch onwards
+	before, after := source.BlindingFactor(4, "", 1), source.BlindingFactor(5, "", 1)
+	assert.NoError(t, source.Rotate(5, []byte("rotated master key")))
+	assert.EqualError(t, source.Rotate(5, []byte("another master key")), "epoch 5 is not after the epoch 5 of the previous master key")
+
+	rotated := NewMasterKeys([]byte("rotated master key"))
+	assert.True(t, before.Equals(source.BlindingFactor(4, "", 1)))
+	assert.False(t, after.Equals(source.BlindingFactor(5, "", 1)))
+	assert.True(t, rotated.BlindingFactor(5, "", 1).Equals(source.BlindingFactor(5, "", 1)))
+	assert.True(t, rotated.BlindingFactor(9, "", 1).Equals(source.BlindingFactor(9, "", 1)))
+}
+
+func TestRegenerateVertices(t *testing.T) {
+	db := make(kv.MemDB)
+	source := NewMasterKeys([]byte("master key"))
+	tree := NewVerkleTree(7, digitPath(t, 7), db)
+	tree.Blinding = source
+
+	assert.NoError(t, tree.PutBatch(bigs(map[string]int64{"282475250": 1, "987654321": 2})))
+	assert.Equal(t, uint64(1), tree.Version)
+
+	path := pathOf(t, 7, "564950499")
+	leafLayer := ""
+	for _, p := range path[:len(path)-1] {
+		leafLayer = fmt.Sprintf("%s.%d", leafLayer, p)
+	}
+
+	// A vertex created again in the same epoch is of another version, and so has another blinding factor
+	assert.NoError(t, tree.Put("564950499", big.NewInt(3)))
+	created := &Vertex{}
+	assert.NoError(t, created.FromBytes(db[leafLayer]))
+	assert.NoError(t, tree.Delete("282475250"))
+	assert.NoError(t, tree.Delete("564950499"))
+	assert.Empty(t, db[leafLayer])
+	assert.NoError(t, tree.Put("564950499", big.NewInt(4)))
+
+	recreated := &Vertex{}
+	assert.NoError(t, recreated.FromBytes(db[leafLayer]))
+	assert.Equal(t, uint64(0), recreated.Epoch)
+	assert.Equal(t, uint64(5), recreated.Version)
+	assert.Equal(t, uint64(1), created.Version)
+	assert.False(t, created.BlindingFactor.Equals(recreated.BlindingFactor))
+
+	// Vertices created after the master key is rotated are of the new epoch
+	assert.NoError(t, source.Rotate(1, []byte("rotated master key")))
+	tree.Epoch = 1
+	assert.NoError(t, tree.Put("123456789", big.NewInt(5)))
+
+	opened, err := OpenVerkleTree(7, digitPath(t, 7), db)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), opened.Epoch)
+	assert.Equal(t, uint64(6), opened.Version)
+
+	// Every vertex can be regenerated from the source and the values it commits to
+	epochs := make(map[uint64]struct{})
+	for key, raw := range db {
+		if key == stateKey {
+			continue
+		}
+		v := &Vertex{}
+		assert.NoError(t, v.FromBytes(raw))
+		epochs[v.Epoch] = struct{}{}
+
+		regenerated := &Vertex{}
+		assert.NoError(t, regenerated.FromBytes(raw))
+		regenerated.BlindingFactor = source.BlindingFactor(v.Epoch, key, v.Version)
+		tree.commit(regenerated, v.W != nil)
+		assert.True(t, regenerated.V.Equals(v.V), "V of %s cannot be regenerated", key)
+		if v.W != nil {
+			assert.True(t, regenerated.W.Equals(v.W), "W of %s cannot be regenerated", key)
+		}
+	}
+	assert.Len(t, epochs, 2)
+}
@@ -7,10 +7,15 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math/big"
 	"pol/common"
+	"pol/kv"
 	"pol/pp"
 	"pol/sparse"
 	"pol/sum"
+	"sort"
+	"strings"
+	"sync"
 
 	math "github.com/IBM/mathlib"
 )
@@ -18,27 +23,60 @@ import (
 var (
 	c          = math.Curves[1]
 	GroupOrder = c.GroupOrder
+	groupOrder = new(big.Int).SetBytes(GroupOrder.Bytes())
 )
 
-type DB interface {
-	Get([]byte) []byte
-	Put([]byte, []byte)
-}
+// DB stores the vertices of a tree by their keys. All the vertices an update of the tree writes are committed in a single batch.
+type DB = kv.DB
+
+// Batch is a sequence of writes that a DB applies atomically.
+type Batch = kv.Batch
+
+// stateKey is the key of the epoch and version of the tree in the DB, which is not the key of any vertex
+const stateKey = "state"
 
 type Tree struct {
-	DB    DB
-	PP    *pp.PP
-	depth int
-	Tree  *sparse.Tree
+	DB     DB
+	PP     *pp.PP
+	depth  int
+	Tree   *sparse.Tree
+	dbLock sync.Mutex
+	// writes are the vertices written by the update in progress, which are committed once it is done.
+	// Deleted vertices are nil.
+	writes map[string][]byte
+	// writeErr is the first error of loading a vertex in the update in progress
+	writeErr error
+	// subtrees are the vertices written by a parallel build in progress, by the key of the subtree of the root they are in.
+	// The vertices of a subtree are committed once it is built.
+	subtrees map[string]map[string][]byte
+	// built are the keys of the vertices of the subtrees that a parallel build in progress committed
+	built []string
+	// Blinding derives the blinding factors of the vertices the tree creates. If it is nil, blinding factors are drawn at random.
+	Blinding BlindingSource
+	// Epoch is the epoch of the vertices the tree creates. It is persisted with the next update, and never goes back.
+	Epoch uint64
+	// Version is the number of updates committed to the tree, so vertices created by the next update are of Version+1.
+	Version uint64
+	// ParallelBuild makes PutBatch and PutBatchBalances build an empty tree by building the subtree in every index of the root
+	// concurrently, and committing each in its own batch once it is built. The root is committed last, and the vertices
+	// are the same as those of a build in a single batch. Batches put in a tree that is not empty are not affected.
+	ParallelBuild bool
+	// Assets is the number of balances every leaf holds.
+	// The vector every vertex commits to is made of a block per asset,
+	// which holds the values of the asset in the descendants followed by their sum.
+	Assets int
 }
 
 type Vertex struct {
 	BlindingFactor *math.Zr
+	// Epoch and Version are the epoch and version of the tree when the vertex was created,
+	// from which its blinding factor was derived if the tree has a BlindingSource.
+	Epoch, Version uint64
 	values         map[uint16]*math.Zr
 	Digests        map[uint16]*math.Zr
-	sum            *math.Zr
-	V              *math.G1 // Commitment to values of descendants
-	W              *math.G1 // Commitment to Digests of descendants
+	sums           []*math.Zr // Sum of the values of every asset
+	V              *math.G1   // Commitment to values of descendants
+	W              *math.G1   // Commitment to Digests of descendants
 }
 
 type KV struct {
@@ -52,6 +90,9 @@ type RawVertex struct {
 	Digests        []KV
 	Sum            []byte
 	V, W           []byte
+	Sums           [][]byte `asn1:"optional,omitempty"` // Sums of the rest of the assets
+	Epoch          int64    `asn1:"optional,explicit,tag:0"`
+	Version        int64    `asn1:"optional,explicit,tag:1"`
 }
 
 type Vertices []*Vertex
@@ -63,23 +104,15 @@ func (vs Vertices) SumArgument(pp *sum.PP) *sum.Proof {
 	for i, v := range vs {
 		commitments[i] = v.V
 		randomness[i] = v.BlindingFactor
-		vectors[i] = make(common.Vec, len(pp.H))
-		for j := uint16(0); j < uint16(len(pp.H)); j++ {
-			if n, exists := v.values[j]; exists {
-				vectors[i][j] = n
-			} else {
-				vectors[i][j] = common.IntToZr(0)
-			}
-			// Put the sum in the last index
-			if j == uint16(len(pp.H)-1) {
-				vectors[i][j] = v.sum
-			}
-		}
+		vectors[i] = v.Values(len(pp.H))
 	}
 	return sum.NewAggregatedArgument(pp, commitments, vectors, randomness)
 }
 
+// Values returns the first n entries of the vector the vertex commits to, without the blinding factor.
+// The entries are split evenly between the assets, and the last entry of every asset is its sum.
 func (v *Vertex) Values(n int) common.Vec {
+	block := n / len(v.sums)
 	res := make(common.Vec, n)
 	for j := uint16(0); j < uint16(n); j++ {
 		if n, exists := v.values[j]; exists {
@@ -87,34 +120,50 @@ func (v *Vertex) Values(n int) common.Vec {
 		} else {
 			res[j] = common.IntToZr(0)
 		}
-		// Put the sum in the last index
-		if j == uint16(n-1) {
-			res[j] = v.sum
+		// Put the sum in the last index of the asset
+		if int(j)%block == block-1 {
+			res[j] = v.sums[int(j)/block]
 		}
 	}
 
 	return res
 }
 
-func (v *Vertex) FromBytes(bytes []byte) {
+// Sums returns the sum of every asset in the descendants of the vertex.
+func (v *Vertex) Sums() []*math.Zr {
+	return v.sums
+}
+
+// FromBytes decodes a vertex encoded by Bytes, and returns an error wrapping common.ErrMalformedVertex if it cannot.
+func (v *Vertex) FromBytes(bytes []byte) error {
+	if err := v.fromBytes(bytes); err != nil {
+		return fmt.Errorf("%w: %v", common.ErrMalformedVertex, err)
+	}
+	return nil
+}
+
+func (v *Vertex) fromBytes(bytes []byte) error {
 	rv := &RawVertex{}
 	if _, err := asn1.Unmarshal(bytes, rv); err != nil {
-		panic(err)
+		return err
 	}
 
 	var err error
 	v.BlindingFactor = c.NewZrFromBytes(rv.BlindingFactor)
-	v.sum = c.NewZrFromBytes(rv.Sum)
-
+	v.Epoch, v.Version = uint64(rv.Epoch), uint64(rv.Version)
+	v.sums = []*math.Zr{c.NewZrFromBytes(rv.Sum)}
+	for _, sum := range rv.Sums {
+		v.sums = append(v.sums, c.NewZrFromBytes(sum))
+	}
 	v.V, err = c.NewG1FromBytes(rv.V)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	if len(rv.W) != 0 {
 		v.W, err = c.NewG1FromBytes(rv.W)
 		if err != nil {
-			panic(err)
+			return err
 		}
 	}
 
@@ -128,6 +177,8 @@ func (v *Vertex) FromBytes(bytes []byte) {
 	for _, kv := range rv.Values {
 		v.values[binary.BigEndian.Uint16(kv.K)] = c.NewZrFromBytes(kv.V)
 	}
+
+	return nil
 }
 
 func (v *Vertex) Bytes() []byte {
@@ -139,20 +190,17 @@ func (v *Vertex) Bytes() []byte {
 		V:              v.V.Bytes(),
 		W:              wBytes,
 		BlindingFactor: v.BlindingFactor.Bytes(),
-		Sum:            v.sum.Bytes(),
+		Sum:            v.sums[0].Bytes(),
+		Epoch:          int64(v.Epoch),
+		Version:        int64(v.Version),
 	}
 
-	for k, v := range v.Digests {
-		kBuff := make([]byte, 2)
-		binary.BigEndian.PutUint16(kBuff, k)
-		rv.Digests = append(rv.Digests, KV{K: kBuff, V: v.Bytes()})
+	for _, sum := range v.sums[1:] {
+		rv.Sums = append(rv.Sums, sum.Bytes())
 	}
 
-	for k, v := range v.values {
-		kBuff := make([]byte, 2)
-		binary.BigEndian.PutUint16(kBuff, k)
-		rv.Values = append(rv.Values, KV{K: kBuff, V: v.Bytes()})
-	}
+	rv.Digests = sortedKVs(v.Digests)
+	rv.Values = sortedKVs(v.values)
 
 	bytes, err := asn1.Marshal(rv)
 	if err != nil {
@@ -162,6 +210,23 @@ func (v *Vertex) Bytes() []byte {
 	return bytes
 }
 
+// sortedKVs encodes the entries of the map in ascending order of their keys, so that equal vertices are encoded to equal bytes.
+func sortedKVs(m map[uint16]*math.Zr) []KV {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, int(k))
+	}
+	sort.Ints(keys)
+
+	kvs := make([]KV, len(keys))
+	for i, k := range keys {
+		kBuff := make([]byte, 2)
+		binary.BigEndian.PutUint16(kBuff, uint16(k))
+		kvs[i] = KV{K: kBuff, V: m[uint16(k)].Bytes()}
+	}
+	return kvs
+}
+
 func (v *Vertex) Digest() *math.Zr {
 	h := sha256.New()
 	h.Write(v.V.Bytes())
@@ -172,10 +237,21 @@ func (v *Vertex) Digest() *math.Zr {
 	return common.FieldElementFromBytes(hash)
 }
 
-func NewVerkleTree(fanOut uint16, id2Path func(string) []uint16, db DB) *Tree {
+func NewVerkleTree(fanOut uint16, id2Path func(string) ([]uint16, error), db DB) *Tree {
+	return NewMultiAssetVerkleTree(fanOut, 1, id2Path, db)
+}
+
+// NewMultiAssetVerkleTree creates a tree in which every leaf holds a balance of each of the given number of assets,
+// and every vertex commits to the sum of each asset in its descendants.
+func NewMultiAssetVerkleTree(fanOut uint16, assets int, id2Path func(string) ([]uint16, error), db DB) *Tree {
+	if assets < 1 {
+		panic(fmt.Sprintf("number of assets must be positive but is %d", assets))
+	}
+
 	t := &Tree{
-		DB: db,
-		PP: pp.NewPublicParams(int(fanOut + 2)),
+		DB:     db,
+		PP:     pp.NewPublicParams(VectorSize(fanOut, assets)),
+		Assets: assets,
 		Tree: &sparse.Tree{
 			FanOut:  int(fanOut),
 			ID2Path: id2Path,
@@ -183,10 +259,123 @@ func NewVerkleTree(fanOut uint16, id2Path func(string) []uint16, db DB) *Tree {
 	}
 
 	t.Tree.UpdateInnerVertex = t.updateInnerVertex
+	t.Tree.RebuildInnerVertex = t.rebuildInnerVertex
+	t.Tree.RemoveVertex = t.removeVertex
 	return t
 
 }
 
+// VectorSize returns the size of the vectors the vertices of a tree with the given fanout and number of assets commit to:
+// The values and the sum of every asset, followed by the blinding factor.
+func VectorSize(fanOut uint16, assets int) int {
+	return assets*int(fanOut+1) + 1
+}
+
+// OpenVerkleTree opens a tree whose vertices are already stored in the given DB,
+// by rebuilding its topology from the vertices without recomputing any commitment.
+// If the DB does not contain a root vertex, the returned tree is empty.
+func OpenVerkleTree(fanOut uint16, id2Path func(string) ([]uint16, error), db DB) (*Tree, error) {
+	return OpenMultiAssetVerkleTree(fanOut, 1, id2Path, db)
+}
+
+// OpenMultiAssetVerkleTree is like OpenVerkleTree, but for trees created by NewMultiAssetVerkleTree.
+func OpenMultiAssetVerkleTree(fanOut uint16, assets int, id2Path func(string) ([]uint16, error), db DB) (*Tree, error) {
+	t := NewMultiAssetVerkleTree(fanOut, assets, id2Path, db)
+
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// reload rebuilds the topology of the tree from the vertices in the DB, and empties the tree if the DB has no root.
+func (t *Tree) reload() error {
+	t.Tree.Root = nil
+
+	if err := t.loadState(); err != nil {
+		return err
+	}
+
+	root, err := t.DB.Get([]byte(""))
+	if err != nil {
+		return err
+	}
+	if len(root) == 0 {
+		return nil
+	}
+
+	return t.Tree.Rebuild("", t.loadDescendants)
+}
+
+// loadState loads the version of the tree from the DB, and its epoch if it is later than the epoch of the tree.
+func (t *Tree) loadState() error {
+	state, err := t.DB.Get([]byte(stateKey))
+	if err != nil {
+		return err
+	}
+	if len(state) == 0 {
+		t.Version = 0
+		return nil
+	}
+
+	d := common.NewDecoder(state)
+	epoch, version := d.Uint64(), d.Uint64()
+	if err := d.Finish(); err != nil {
+		return fmt.Errorf("malformed state of tree: %v", err)
+	}
+
+	if epoch > t.Epoch {
+		t.Epoch = epoch
+	}
+	t.Version = version
+	return nil
+}
+
+// loadDescendants returns the keys of the descendants of an inner vertex,
+// or the balances of the descendants of a vertex in the layer above the leaves.
+func (t *Tree) loadDescendants(key string, data interface{}) (map[uint16]interface{}, error) {
+	if _, isLeaf := data.([]*big.Int); isLeaf {
+		return nil, nil
+	}
+
+	v, err := t.loadVertex(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(v.sums) != t.Assets {
+		return nil, fmt.Errorf("vertex %s has %d assets but the tree has %d", key, len(v.sums), t.Assets)
+	}
+
+	block := t.Tree.FanOut + 1
+	descendants := make(map[uint16]interface{}, len(v.values))
+	for i := range v.values {
+		// The descendants are determined by the values of the first asset
+		if int(i) >= t.Tree.FanOut {
+			continue
+		}
+
+		// Only vertices in the layer above the leaves do not commit to digests
+		if v.W != nil {
+			descendants[i] = fmt.Sprintf("%s.%d", key, i)
+			continue
+		}
+
+		balances := make([]*big.Int, t.Assets)
+		for asset := range balances {
+			val, exists := v.values[uint16(asset*block+int(i))]
+			if !exists {
+				return nil, fmt.Errorf("balance of asset %d at %d of vertex %s is missing", asset, i, key)
+			}
+			balances[asset] = common.ZrToBig(val)
+		}
+		descendants[i] = balances
+	}
+
+	return descendants, nil
+}
+
 func (t *Tree) Serialize(out io.Writer) {
 	t.Tree.Root.Serialize(out, "", func(data interface{}) []byte {
 		_, isVertex := data.(*Vertex)
@@ -197,235 +386,522 @@ func (t *Tree) Serialize(out io.Writer) {
 	})
 }
 
-func (t *Tree) Get(id string) (int64, []*Vertex, bool) {
-	n, path, ok := t.Tree.Get(id)
-	if !ok {
-		return 0, nil, false
+// Get returns the balance of the first asset of the given id, and the vertices along its path,
+// or an error wrapping common.ErrNotFound if the id is not in the tree.
+func (t *Tree) Get(id string) (*big.Int, []*Vertex, error) {
+	balances, path, err := t.GetBalances(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return balances[0], path, nil
+}
+
+// GetBalances returns the balance of every asset of the given id, and the vertices along its path.
+func (t *Tree) GetBalances(id string) ([]*big.Int, []*Vertex, error) {
+	n, path, err := t.Tree.Get(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vertices, err := t.loadVertices(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return n.([]*big.Int), vertices, nil
+}
+
+// GetPrefix returns the vertices along the path of the given id, from the root down to the deepest vertex in the tree,
+// and whether the id itself is in the tree.
+func (t *Tree) GetPrefix(id string) ([]*Vertex, bool, error) {
+	path, ok, err := t.Tree.GetPrefix(id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	vertices, err := t.loadVertices(path)
+	if err != nil {
+		return nil, false, err
 	}
 
+	return vertices, ok, nil
+}
+
+func (t *Tree) loadVertices(path []interface{}) ([]*Vertex, error) {
 	verticesAlongThePath := make([]*Vertex, len(path))
 	for i := 0; i < len(verticesAlongThePath); i++ {
-		key := path[i].(*sparse.Vertex).Data.(string)
-		bytes := t.DB.Get([]byte(key))
-		v := &Vertex{}
-		v.FromBytes(bytes)
+		v, err := t.loadVertex(path[i].(*sparse.Vertex).Data.(string))
+		if err != nil {
+			return nil, err
+		}
 		verticesAlongThePath[i] = v
 	}
-
-	return n.(int64), verticesAlongThePath, true
+	return verticesAlongThePath, nil
 }
 
-func (t *Tree) Put(id string, data int64) {
-	t.Tree.Put(id, data)
+// Put puts the given liability as the balance of the first asset, and zero balances of the rest of the assets.
+func (t *Tree) Put(id string, data *big.Int) error {
+	return t.PutBalances(id, []*big.Int{data})
 }
 
-func (t *Tree) updateInnerVertex(key string, node interface{}, descendants []interface{}, descendantsLeaves bool, index int) interface{} {
-	if descendantsLeaves {
-		return t.updateLayerAboveLeaves(key, node, descendants, index)
+// PutBalances puts the balance of every asset of the given id. Missing balances at the end are zero.
+func (t *Tree) PutBalances(id string, balances []*big.Int) error {
+	padded, err := t.pad(id, balances)
+	if err != nil {
+		return err
 	}
-	return t.updateInnerLayer(key, node, descendants, index)
+	return t.atomically(func() error {
+		return t.Tree.Put(id, padded)
+	})
 }
 
-func (t *Tree) updateInnerLayer(key string, node interface{}, descendants []interface{}, index int) interface{} {
-	var v *Vertex
-
-	defer func() {
-		t.DB.Put([]byte(key), v.Bytes())
-	}()
+// PutBatch puts all the given liabilities, and computes the commitments of every vertex along their paths exactly once.
+// Vertices in the same layer are computed in parallel. If any of the liabilities cannot be put, none of them is.
+func (t *Tree) PutBatch(liabilities map[string]*big.Int) error {
+	balances := make(map[string][]*big.Int, len(liabilities))
+	for id, liability := range liabilities {
+		balances[id] = []*big.Int{liability}
+	}
+	return t.PutBatchBalances(balances)
+}
 
-	if node == nil {
-		v = &Vertex{
-			BlindingFactor: c.NewRandomZr(rand.Reader),
-			sum:            c.NewZrFromInt(0),
-			values:         make(map[uint16]*math.Zr),
-			Digests:        make(map[uint16]*math.Zr),
+// PutBatchBalances is like PutBatch, but puts the balance of every asset of every id.
+func (t *Tree) PutBatchBalances(balances map[string][]*big.Int) error {
+	data := make(map[string]interface{}, len(balances))
+	for id, b := range balances {
+		padded, err := t.pad(id, b)
+		if err != nil {
+			return err
 		}
+		data[id] = padded
+	}
+	if t.ParallelBuild && t.Tree.Root == nil {
+		return t.buildBySubtree(data)
+	}
+	return t.atomically(func() error {
+		return t.Tree.PutBatch(data)
+	})
+}
 
-		// value vector
-		m := make(common.Vec, 0, len(descendants)+2)
-
-		// digest vector
-		d := make(common.Vec, 0, len(descendants)+2)
+// buildBySubtree puts the given data in an empty tree by building its subtrees concurrently.
+// If the build fails, the vertices of the subtrees that were already committed are deleted, and the tree is left empty.
+func (t *Tree) buildBySubtree(data map[string]interface{}) error {
+	t.subtrees, t.built = make(map[string]map[string][]byte), nil
+	err := t.atomically(func() error {
+		return t.Tree.PutBatchBySubtree(data, t.commitSubtree)
+	})
+	built := t.built
+	t.subtrees, t.built = nil, nil
 
-		for i, desc := range descendants {
-			if desc == nil {
-				m = append(m, c.NewZrFromInt(0))
-				d = append(d, c.NewZrFromInt(0))
-				continue
-			}
+	if err == nil || len(built) == 0 {
+		return err
+	}
 
-			descVertex := t.fetchVertex(desc)
+	// The root was not committed, so the vertices of the subtrees are not reachable from it
+	batch := t.DB.NewBatch()
+	for _, key := range built {
+		batch.Delete([]byte(key))
+	}
+	if deleteErr := batch.Commit(); deleteErr != nil {
+		return fmt.Errorf("%w (and deleting the built subtrees failed: %v)", err, deleteErr)
+	}
+	return err
+}
 
-			val := descVertex.sum
-			v.sum = v.sum.Plus(val)
-			v.values[uint16(i)] = val
-			digest := descVertex.Digest()
-			v.Digests[uint16(i)] = digest
-			m = append(m, val)
-			d = append(d, digest)
-		}
+// commitSubtree commits the vertices of the subtree in the given index of the root in a batch of its own,
+// unless the build has already failed.
+func (t *Tree) commitSubtree(index uint16) {
+	key := fmt.Sprintf(".%d", index)
 
-		// Artificially append the sum
-		m = append(m, v.sum)
+	// The DB is only accessed with the dbLock held, so the batch is committed with it held as well
+	t.dbLock.Lock()
+	defer t.dbLock.Unlock()
 
-		// Artificially append the blinding factor
-		m = append(m, v.BlindingFactor)
+	writes := t.subtrees[key]
+	delete(t.subtrees, key)
+	if t.writeErr != nil || len(writes) == 0 {
+		return
+	}
 
-		// Commit to values
-		v.V = pp.Commit(t.PP, m)
+	if err := commitBatch(t.DB, writes); err != nil {
+		t.writeErr = err
+		return
+	}
+	for key := range writes {
+		t.built = append(t.built, key)
+	}
+}
 
-		// Artificially append two empty values
-		d = append(d, c.NewZrFromInt(0))
-		d = append(d, c.NewZrFromInt(0))
+// pad returns copies of the balances followed by zero balances of the missing assets,
+// so that the balances in the tree cannot be changed by the caller.
+func (t *Tree) pad(id string, balances []*big.Int) ([]*big.Int, error) {
+	if len(balances) > t.Assets {
+		return nil, fmt.Errorf("%w: %s has %d balances but the tree has %d assets", common.ErrInvalidLiability, id, len(balances), t.Assets)
+	}
+	padded := make([]*big.Int, t.Assets)
+	for asset := range padded {
+		padded[asset] = new(big.Int)
+		if asset >= len(balances) {
+			continue
+		}
+		if balances[asset] == nil || balances[asset].Sign() < 0 || balances[asset].Cmp(groupOrder) >= 0 {
+			return nil, fmt.Errorf("%w: balance of asset %d of %s is not in [0, %s)", common.ErrInvalidLiability, asset, id, groupOrder)
+		}
+		padded[asset].Set(balances[asset])
+	}
+	return padded, nil
+}
 
-		// Commit to Digests
-		v.W = pp.Commit(t.PP, d)
+// Delete removes the liability of the given id, deletes the vertices left without descendants from the DB,
+// and updates the remaining vertices along the path. Returns an error wrapping common.ErrNotFound if the id is not in the tree.
+func (t *Tree) Delete(id string) error {
+	return t.atomically(func() error {
+		return t.Tree.Delete(id)
+	})
+}
 
-		return key
+// atomically applies an update of the tree, and then commits all the vertices it wrote and deleted to the DB in a single batch,
+// along with the next version of the tree.
+// If a vertex cannot be loaded during the update or the batch cannot be committed, the DB is left as it was before the update,
+// and the tree is reloaded from it.
+func (t *Tree) atomically(update func() error) error {
+	t.writes, t.writeErr = make(map[string][]byte), nil
+
+	// The sparse tree fails an update only before it changes anything, so there is nothing to undo
+	if err := update(); err != nil {
+		t.writes = nil
+		return err
 	}
 
-	v = t.fetchVertex(key)
-
-	oldVal := v.values[uint16(index)]
-	if oldVal == nil {
-		oldVal = c.NewZrFromInt(0)
+	err := t.writeErr
+	if err == nil {
+		err = t.commitWrites()
 	}
 
-	descVertex := t.fetchVertex(descendants[index])
+	t.writes, t.writeErr = nil, nil
 
-	newVal := descVertex.sum
-	newDigest := descVertex.Digest()
-	v.Digests[uint16(index)] = newDigest
+	if err == nil {
+		t.Version++
+		return nil
+	}
 
-	//oldSumDec, _ := v.sum.Int()
-	v.sum = updateSum(v.sum, oldVal, newVal)
+	if reloadErr := t.reload(); reloadErr != nil {
+		return fmt.Errorf("%w (and reloading the tree failed: %v)", err, reloadErr)
+	}
+	return err
+}
 
-	//newSumDec, _ := v.sum.Int()
+// commitWrites writes the vertices of the update in progress to the DB in a single batch, along with the state of the tree.
+func (t *Tree) commitWrites() error {
+	e := common.NewEncoder()
+	e.Uint64(t.Epoch)
+	e.Uint64(t.Version + 1)
+	state, err := e.Result()
+	if err != nil {
+		return err
+	}
+	t.writes[stateKey] = state
 
-	v.values[uint16(index)] = newVal
+	return commitBatch(t.DB, t.writes)
+}
 
-	// Update index with new value and digest
-	m := make(common.Vec, t.PP.N)
-	d := make(common.Vec, t.PP.N)
+// commitBatch commits the given writes to the DB in a single batch, in ascending order of their keys.
+func commitBatch(db DB, writes map[string][]byte) error {
+	keys := make([]string, 0, len(writes))
+	for key := range writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
-	for i := 0; i < len(m); i++ {
-		if val, exists := v.values[uint16(i)]; exists {
-			m[uint16(i)] = val
-			d[uint16(i)] = v.Digests[uint16(i)]
+	batch := db.NewBatch()
+	for _, key := range keys {
+		if val := writes[key]; val != nil {
+			batch.Put([]byte(key), val)
 		} else {
-			m[uint16(i)] = c.NewZrFromInt(0)
-			d[uint16(i)] = c.NewZrFromInt(0)
+			batch.Delete([]byte(key))
 		}
 	}
+	return batch.Commit()
+}
 
-	// Artificially append the sum
-	m[len(m)-2] = v.sum
+func (t *Tree) updateInnerVertex(key string, node interface{}, descendants []interface{}, descendantsLeaves bool, index int) interface{} {
+	if node == nil {
+		return t.rebuildInnerVertex(key, node, descendants, descendantsLeaves)
+	}
+	if descendantsLeaves {
+		return t.updateLayerAboveLeaves(key, descendants, index)
+	}
+	return t.updateInnerLayer(key, descendants, index)
+}
 
-	// Artificially append the blinding factor
-	m[len(m)-1] = v.BlindingFactor
+// rebuildInnerVertex computes the vertex from all of its descendants at once.
+// A vertex that already exists keeps its blinding factor, and a new vertex is of the current epoch and the next version.
+func (t *Tree) rebuildInnerVertex(key string, node interface{}, descendants []interface{}, descendantsLeaves bool) interface{} {
+	v := &Vertex{
+		sums:    t.zeros(),
+		values:  make(map[uint16]*math.Zr),
+		Digests: make(map[uint16]*math.Zr),
+	}
 
-	//pp.Update(t.PP, v.V, m, newVal, index)
+	block := t.Tree.FanOut + 1
 
-	v.V = pp.Commit(t.PP, m)
+	if node == nil {
+		v.Epoch, v.Version = t.Epoch, t.Version+1
+		v.BlindingFactor = t.newBlindingFactor(key, v.Epoch, v.Version)
+	} else {
+		existing := t.fetchVertex(key)
+		v.BlindingFactor, v.Epoch, v.Version = existing.BlindingFactor, existing.Epoch, existing.Version
+	}
 
-	// Update last entry with sum
-	//pp.Update(t.PP, v.V, m, v.sum, len(v.values))
+	for i, desc := range descendants {
+		if desc == nil {
+			continue
+		}
 
-	// Update the new digest
-	v.W = pp.Commit(t.PP, d)
-	//pp.Update(t.PP, v.W, d, newDigest, index)
+		if descendantsLeaves {
+			for asset, balance := range desc.([]*big.Int) {
+				num := common.BigToZr(balance)
+				v.sums[asset] = v.sums[asset].Plus(num)
+				v.values[uint16(asset*block+i)] = num
+			}
+			continue
+		}
+
+		descVertex := t.fetchVertex(desc)
+
+		for asset, val := range descVertex.sums {
+			v.sums[asset] = v.sums[asset].Plus(val)
+			v.values[uint16(asset*block+i)] = val
+		}
+		v.Digests[uint16(i)] = descVertex.Digest()
+	}
+
+	// Vertices in the layer above the leaves do not commit to digests
+	t.commit(v, !descendantsLeaves)
+	t.putVertex(key, v)
 
 	return key
 }
 
-func (t *Tree) fetchVertex(desc interface{}) *Vertex {
-	k := desc.(string)
-	bytes := t.DB.Get([]byte(k))
-	if len(bytes) == 0 {
-		panic(fmt.Sprintf("could not find %s in DB", k))
+func (t *Tree) newBlindingFactor(key string, epoch, version uint64) *math.Zr {
+	if t.Blinding == nil {
+		return c.NewRandomZr(rand.Reader)
 	}
-	v := &Vertex{}
-	v.FromBytes(bytes)
-	return v
+	return t.Blinding.BlindingFactor(epoch, key, version)
 }
 
-func (t *Tree) updateLayerAboveLeaves(key string, node interface{}, descendants []interface{}, index int) interface{} {
-	var v *Vertex
+func (t *Tree) updateInnerLayer(key string, descendants []interface{}, index int) interface{} {
+	v := t.fetchVertex(key)
 
-	defer func() {
-		defer func() {
-			t.DB.Put([]byte(key), v.Bytes())
-		}()
-	}()
+	newVals, newDigest := t.zeros(), c.NewZrFromInt(0)
+	if descendants[index] != nil {
+		descVertex := t.fetchVertex(descendants[index])
+		newVals, newDigest = descVertex.sums, descVertex.Digest()
+	}
 
-	if node == nil {
-		v = &Vertex{
-			BlindingFactor: c.NewRandomZr(rand.Reader),
-			sum:            c.NewZrFromInt(0),
-			values:         make(map[uint16]*math.Zr),
-			Digests:        make(map[uint16]*math.Zr),
-		}
+	t.update(v, index, newVals, newDigest, descendants[index] == nil)
+	t.putVertex(key, v)
 
-		m := make(common.Vec, 0, len(descendants)+2)
+	return key
+}
 
-		for i, desc := range descendants {
-			if desc == nil {
-				m = append(m, c.NewZrFromInt(0))
-				continue
-			}
+// update changes the values of every asset (and digest, if the vertex commits to digests) of the descendant in the given index,
+// and homomorphically updates the value, sum and digest slots of the commitments with O(1) group operations per asset.
+// If removed is true, the descendant is removed from the vertex.
+func (t *Tree) update(v *Vertex, index int, newVals []*math.Zr, newDigest *math.Zr, removed bool) {
+	m, d := t.vectors(v)
+	block := t.Tree.FanOut + 1
 
-			val := desc.(int64)
-			num := c.NewZrFromInt(val)
-			v.sum = v.sum.Plus(num)
-			v.values[uint16(i)] = num
-			m = append(m, num)
-		}
+	for asset, newVal := range newVals {
+		valIndex, sumIndex := asset*block+index, asset*block+block-1
+
+		newSum := updateSum(v.sums[asset], m[valIndex], newVal)
 
-		// Artificially append the sum
-		m = append(m, v.sum)
+		pp.Update(t.PP, v.V, m, newVal, valIndex)
+		pp.Update(t.PP, v.V, m, newSum, sumIndex)
 
-		// Artificially append the blinding factor
-		m = append(m, v.BlindingFactor)
+		v.sums[asset] = newSum
+		if removed {
+			delete(v.values, uint16(valIndex))
+		} else {
+			v.values[uint16(valIndex)] = newVal
+		}
+	}
+
+	if v.W != nil {
+		pp.Update(t.PP, v.W, d, newDigest, index)
+	}
 
-		v.V = pp.Commit(t.PP, m)
+	if removed {
+		delete(v.Digests, uint16(index))
+		return
+	}
 
-		return key
+	if v.W != nil {
+		v.Digests[uint16(index)] = newDigest
 	}
+}
 
-	v = t.fetchVertex(key)
+// commit recomputes the commitments of the vertex from its values, sum, blinding factor and (if digests is true) its digests.
+func (t *Tree) commit(v *Vertex, digests bool) {
+	m, d := t.vectors(v)
 
-	oldVal := v.values[uint16(index)]
-	if oldVal == nil {
-		oldVal = c.NewZrFromInt(0)
+	v.V = pp.Commit(t.PP, m)
+
+	if digests {
+		v.W = pp.Commit(t.PP, d)
 	}
-	new := descendants[index].(int64)
-	newVal := c.NewZrFromInt(new)
+}
 
-	v.sum = updateSum(v.sum, oldVal, newVal)
+// vectors returns the vectors the vertex commits to: the values of every asset followed by its sum, then the blinding factor,
+// and its digests followed by zeros.
+func (t *Tree) vectors(v *Vertex) (m, d common.Vec) {
+	m = make(common.Vec, t.PP.N)
+	d = make(common.Vec, t.PP.N)
 
-	// Update index with new value
-	m := make(common.Vec, t.PP.N)
 	for i := 0; i < len(m); i++ {
 		if val, exists := v.values[uint16(i)]; exists {
 			m[uint16(i)] = val
 		} else {
 			m[uint16(i)] = c.NewZrFromInt(0)
 		}
+		if digest, exists := v.Digests[uint16(i)]; exists {
+			d[uint16(i)] = digest
+		} else {
+			d[uint16(i)] = c.NewZrFromInt(0)
+		}
 	}
 
-	// Artificially append the sum
-	m = append(m, v.sum)
+	// Artificially append the sum of every asset
+	block := t.Tree.FanOut + 1
+	for asset, sum := range v.sums {
+		m[asset*block+block-1] = sum
+	}
+
+	// Artificially append the blinding factor
+	m[len(m)-1] = v.BlindingFactor
+
+	return m, d
+}
 
-	// Update index with new value
-	pp.Update(t.PP, v.V, m, newVal, index)
-	v.values[uint16(index)] = newVal
+// fetchVertex loads the vertex of a key that the tree is known to hold in the middle of an update.
+// If the vertex cannot be loaded, the error fails the update, and an empty vertex is returned in its place
+// so that the update can run its course. Whatever is computed from the empty vertex is then discarded with the rest of the update.
+func (t *Tree) fetchVertex(desc interface{}) *Vertex {
+	v, err := t.loadVertex(desc.(string))
+	if err == nil {
+		return v
+	}
 
-	// Update last entry with sum
-	pp.Update(t.PP, v.V, m, v.sum, len(v.values))
+	t.dbLock.Lock()
+	if t.writeErr == nil {
+		t.writeErr = err
+	}
+	t.dbLock.Unlock()
+
+	return &Vertex{
+		BlindingFactor: c.NewZrFromInt(0),
+		values:         make(map[uint16]*math.Zr),
+		Digests:        make(map[uint16]*math.Zr),
+		sums:           t.zeros(),
+		V:              c.NewG1(),
+	}
+}
 
+// loadVertex loads the vertex of the given key, as written by the update in progress if there is one.
+func (t *Tree) loadVertex(key string) (*Vertex, error) {
+	t.dbLock.Lock()
+	bytes, written := t.writesOf(key)[key]
+	var err error
+	if !written {
+		bytes, err = t.DB.Get([]byte(key))
+	}
+	t.dbLock.Unlock()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed loading %s from DB: %w", key, err)
+	}
+	if len(bytes) == 0 {
+		return nil, fmt.Errorf("could not find %s in DB", key)
+	}
+	v := &Vertex{}
+	if err := v.FromBytes(bytes); err != nil {
+		return nil, fmt.Errorf("vertex %s: %w", key, err)
+	}
+	return v, nil
+}
+
+// putVertex writes the vertex as part of the update in progress.
+func (t *Tree) putVertex(key string, v *Vertex) {
+	bytes := v.Bytes()
+
+	t.dbLock.Lock()
+	defer t.dbLock.Unlock()
+
+	t.write(key, bytes)
+}
+
+// write writes the vertex of the given key as part of the update in progress, or deletes it if bytes is nil.
+// It is invoked with the dbLock held.
+func (t *Tree) write(key string, bytes []byte) {
+	writes := t.writesOf(key)
+	if writes == nil {
+		writes = make(map[string][]byte)
+		t.subtrees[subtreeOf(key)] = writes
+	}
+	writes[key] = bytes
+}
+
+// writesOf returns the writes of the update in progress that the vertex of the given key is written to,
+// which are nil if the vertex is in a subtree of a parallel build that has none yet. It is invoked with the dbLock held.
+func (t *Tree) writesOf(key string) map[string][]byte {
+	if t.subtrees == nil || key == "" {
+		return t.writes
+	}
+	return t.subtrees[subtreeOf(key)]
+}
+
+// subtreeOf returns the key of the descendant of the root whose subtree the vertex of the given key is in
+func subtreeOf(key string) string {
+	if i := strings.IndexByte(key[1:], '.'); i >= 0 {
+		return key[:i+1]
+	}
 	return key
 }
 
+func (t *Tree) updateLayerAboveLeaves(key string, descendants []interface{}, index int) interface{} {
+	v := t.fetchVertex(key)
+
+	newVals := t.zeros()
+	if descendants[index] != nil {
+		for asset, balance := range descendants[index].([]*big.Int) {
+			newVals[asset] = common.BigToZr(balance)
+		}
+	}
+
+	t.update(v, index, newVals, nil, descendants[index] == nil)
+	t.putVertex(key, v)
+
+	return key
+}
+
+func (t *Tree) removeVertex(key string, _ interface{}) {
+	t.dbLock.Lock()
+	defer t.dbLock.Unlock()
+
+	t.write(key, nil)
+}
+
+// zeros returns a zero value for every asset
+func (t *Tree) zeros() []*math.Zr {
+	res := make([]*math.Zr, t.Assets)
+	for asset := range res {
+		res[asset] = c.NewZrFromInt(0)
+	}
+	return res
+}
+
 func updateSum(sum, removed, added *math.Zr) *math.Zr {
 	return c.ModAdd(sum, c.ModSub(added, removed, c.GroupOrder), c.GroupOrder)
 }
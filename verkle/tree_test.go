@@ -4,39 +4,65 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	mathrand "math/rand"
 	"pol/common"
+	"pol/kv"
+	"pol/pp"
 	"pol/sparse"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
-type MemDB map[string][]byte
+func digitPath(t *testing.T, fanout uint16) func(string) ([]uint16, error) {
+	id2Path, err := sparse.DigitPath(fanout)
+	assert.NoError(t, err)
+	return id2Path
+}
+
+func hexPath(t *testing.T, fanout uint16) func(string) ([]uint16, error) {
+	id2Path, err := sparse.HexId2PathForFanOut(fanout)
+	assert.NoError(t, err)
+	return id2Path
+}
 
-func (m MemDB) Get(key []byte) []byte {
-	return m[string(key)]
+// path returns the path of the id in a tree of ids of nine decimal digits
+func pathOf(t *testing.T, fanout uint16, id string) []uint16 {
+	path, err := digitPath(t, fanout)(id)
+	assert.NoError(t, err)
+	return path
 }
 
-func (m MemDB) Put(key []byte, val []byte) {
-	m[string(key)] = val
+func ignoreNotFound(err error) error {
+	if errors.Is(err, common.ErrNotFound) {
+		return nil
+	}
+	return err
 }
 
 func TestVerkleTree(t *testing.T) {
-	tree := NewVerkleTree(1023, sparse.HexId2PathForFanOut(1023), make(MemDB))
+	tree := NewVerkleTree(1023, hexPath(t, 1023), make(kv.MemDB))
 
-	tree.Put(hash("a"), 5)
-	tree.Put(hash("b"), 6)
+	assert.NoError(t, tree.Put(hash("a"), big.NewInt(5)))
+	assert.NoError(t, tree.Put(hash("b"), big.NewInt(6)))
 
-	five, path, ok := tree.Get(hash("a"))
-	assert.Equal(t, int64(5), five)
-	assert.True(t, ok)
+	five, path, err := tree.Get(hash("a"))
+	assert.Equal(t, int64(5), five.Int64())
+	assert.NoError(t, err)
 	assert.Len(t, path, 26)
 
-	six, path, ok := tree.Get(hash("b"))
-	assert.Equal(t, int64(6), six)
-	assert.True(t, ok)
+	six, path, err := tree.Get(hash("b"))
+	assert.Equal(t, int64(6), six.Int64())
+	assert.NoError(t, err)
 	assert.Len(t, path, 26)
 
+	_, _, err = tree.Get("not hex")
+	assert.True(t, errors.Is(err, common.ErrInvalidID))
+	assert.True(t, errors.Is(tree.Put("not hex", big.NewInt(1)), common.ErrInvalidID))
+
 }
 
 func hash(s string) string {
@@ -78,12 +104,480 @@ func TestUpdateSum(t *testing.T) {
 }
 
 func TestSerializeVerkleTree(t *testing.T) {
-	tree := NewVerkleTree(1023, sparse.HexId2PathForFanOut(1023), make(MemDB))
+	tree := NewVerkleTree(1023, hexPath(t, 1023), make(kv.MemDB))
 
-	tree.Put(hash("a"), 5)
-	tree.Put(hash("b"), 6)
+	assert.NoError(t, tree.Put(hash("a"), big.NewInt(5)))
+	assert.NoError(t, tree.Put(hash("b"), big.NewInt(6)))
 
 	buff := &bytes.Buffer{}
 
 	tree.Serialize(buff)
 }
+
+func TestOpenVerkleTree(t *testing.T) {
+	db := make(kv.MemDB)
+	tree := NewVerkleTree(7, digitPath(t, 7), db)
+	assert.NoError(t, tree.Put("123456789", big.NewInt(5)))
+	assert.NoError(t, tree.Put("123456788", big.NewInt(6)))
+	assert.NoError(t, tree.Put("923456789", big.NewInt(7)))
+
+	opened, err := OpenVerkleTree(7, digitPath(t, 7), db)
+	assert.NoError(t, err)
+	opened.PP = tree.PP
+
+	for id, expected := range map[string]int64{"123456789": 5, "123456788": 6, "923456789": 7} {
+		n, path, err := opened.Get(id)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, n.Int64())
+		_, expectedPath, _ := tree.Get(id)
+		assert.Equal(t, expectedPath, path)
+	}
+
+	_, _, err = opened.Get("123456787")
+	assert.True(t, errors.Is(err, common.ErrNotFound))
+
+	empty, err := OpenVerkleTree(7, digitPath(t, 7), make(kv.MemDB))
+	assert.NoError(t, err)
+	assert.Nil(t, empty.Tree.Root)
+
+	// A vertex that cannot be decoded is reported as such
+	corrupted := make(kv.MemDB)
+	for key, val := range db {
+		corrupted[key] = val
+	}
+	corrupted[fmt.Sprintf(".%d", pathOf(t, 7, "123456789")[0])] = []byte{1, 2, 3}
+	_, err = OpenVerkleTree(7, digitPath(t, 7), corrupted)
+	assert.True(t, errors.Is(err, common.ErrMalformedVertex))
+
+	delete(db, fmt.Sprintf(".%d", pathOf(t, 7, "123456789")[0]))
+	_, err = OpenVerkleTree(7, digitPath(t, 7), db)
+	assert.Error(t, err)
+}
+
+func TestDelete(t *testing.T) {
+	db := make(kv.MemDB)
+	tree := NewVerkleTree(7, digitPath(t, 7), db)
+	assert.NoError(t, tree.Put("123456789", big.NewInt(5)))
+	assert.NoError(t, tree.Put("123456788", big.NewInt(6)))
+
+	// The keys of the vertices only on the path of the first id
+	path := pathOf(t, 7, "123456789")
+	otherPath := pathOf(t, 7, "123456788")
+	assert.NotEqual(t, path[0], otherPath[0])
+
+	var key string
+	var ownKeys []string
+	for _, p := range path[:len(path)-1] {
+		key = fmt.Sprintf("%s.%d", key, p)
+		ownKeys = append(ownKeys, key)
+		assert.NotEmpty(t, db[key])
+	}
+
+	assert.NoError(t, tree.Delete("123456789"))
+	assert.True(t, errors.Is(tree.Delete("123456789"), common.ErrNotFound))
+
+	for _, key := range ownKeys {
+		assert.Empty(t, db[key])
+	}
+
+	_, _, err := tree.Get("123456789")
+	assert.True(t, errors.Is(err, common.ErrNotFound))
+
+	// The root is the same as in a tree that only ever had the remaining id, up to the blinding factor
+	root := &Vertex{}
+	assert.NoError(t, root.FromBytes(db[""]))
+	assert.Equal(t, int64(6), sumOf(t, root))
+	assert.Len(t, root.values, 1)
+	assert.Len(t, root.Digests, 1)
+
+	// Only the state of the tree is left, so that the versions of vertices created later are not reused
+	assert.NoError(t, tree.Delete("123456788"))
+	assert.Len(t, db, 1)
+	assert.NotEmpty(t, db[stateKey])
+	assert.Nil(t, tree.Tree.Root)
+}
+
+func TestUpdateAboveLeaves(t *testing.T) {
+	db := make(kv.MemDB)
+	tree := NewVerkleTree(7, digitPath(t, 7), db)
+
+	// Both ids share all vertices but the leaves, as they only differ in the most significant digit in base 7
+	assert.NoError(t, tree.Put("564950499", big.NewInt(5)))
+	assert.NoError(t, tree.Put("282475250", big.NewInt(6)))
+	assert.NoError(t, tree.Put("564950499", big.NewInt(7)))
+
+	for _, id := range []string{"564950499", "282475250"} {
+		_, vertices, err := tree.Get(id)
+		assert.NoError(t, err)
+		for _, v := range vertices {
+			assert.Equal(t, int64(13), sumOf(t, v))
+			m := v.Values(int(tree.PP.N - 1))
+			assert.True(t, v.V.Equals(pp.Commit(tree.PP, append(m, v.BlindingFactor))))
+		}
+	}
+
+	assert.NoError(t, tree.Delete("282475250"))
+	_, vertices, _ := tree.Get("564950499")
+	for _, v := range vertices {
+		assert.Equal(t, int64(7), sumOf(t, v))
+		m := v.Values(int(tree.PP.N - 1))
+		assert.True(t, v.V.Equals(pp.Commit(tree.PP, append(m, v.BlindingFactor))))
+	}
+}
+
+func sumOf(t *testing.T, v *Vertex) int64 {
+	sum, err := v.sums[0].Int()
+	assert.NoError(t, err)
+	return sum
+}
+
+func TestPutBatch(t *testing.T) {
+	liabilities := map[string]int64{
+		"282475250": 1,
+		"564950499": 2,
+		"987654321": 3,
+		"987654322": 4,
+		"123456789": 5,
+	}
+
+	sequentialDB := make(kv.MemDB)
+	sequential := NewVerkleTree(7, digitPath(t, 7), sequentialDB)
+	for id, liability := range liabilities {
+		assert.NoError(t, sequential.Put(id, big.NewInt(liability)))
+	}
+
+	batchedDB := make(kv.MemDB)
+	batched := NewVerkleTree(7, digitPath(t, 7), batchedDB)
+	batched.PP = sequential.PP
+	assert.NoError(t, batched.PutBatch(map[string]*big.Int{"282475250": big.NewInt(7), "123456789": big.NewInt(8)}))
+	assert.NoError(t, batched.PutBatch(bigs(liabilities)))
+
+	assert.Equal(t, len(sequentialDB), len(batchedDB))
+
+	for key := range sequentialDB {
+		if key == stateKey {
+			continue
+		}
+		expected, actual := &Vertex{}, &Vertex{}
+		assert.NoError(t, expected.FromBytes(sequentialDB[key]))
+		assert.NoError(t, actual.FromBytes(batchedDB[key]))
+
+		assert.Equal(t, sumOf(t, expected), sumOf(t, actual))
+		assert.Equal(t, expected.Values(int(batched.PP.N-1)), actual.Values(int(batched.PP.N-1)))
+		assert.Equal(t, expected.W == nil, actual.W == nil)
+
+		m := actual.Values(int(batched.PP.N - 1))
+		assert.True(t, actual.V.Equals(pp.Commit(batched.PP, append(m, actual.BlindingFactor))))
+	}
+
+	for id, liability := range liabilities {
+		n, _, err := batched.Get(id)
+		assert.NoError(t, err)
+		assert.Equal(t, liability, n.Int64())
+	}
+}
+
+func TestParallelBuild(t *testing.T) {
+	liabilities := map[string]int64{
+		"282475250": 1,
+		"564950499": 2,
+		"987654321": 3,
+		"987654322": 4,
+		"123456789": 5,
+		"623456789": 6,
+	}
+	source := NewMasterKeys([]byte("master key"))
+
+	sequentialDB := make(kv.MemDB)
+	sequential := NewVerkleTree(7, digitPath(t, 7), sequentialDB)
+	sequential.Blinding = source
+	assert.NoError(t, sequential.PutBatch(bigs(liabilities)))
+
+	parallelDB := make(kv.MemDB)
+	parallel := NewVerkleTree(7, digitPath(t, 7), parallelDB)
+	parallel.PP = sequential.PP
+	parallel.Blinding = source
+	parallel.ParallelBuild = true
+	assert.NoError(t, parallel.PutBatch(bigs(liabilities)))
+
+	// The DB is the same, byte for byte
+	assert.Equal(t, sequentialDB, parallelDB)
+	assert.Equal(t, sequential.Version, parallel.Version)
+	for id, liability := range liabilities {
+		n, _, err := parallel.Get(id)
+		assert.NoError(t, err)
+		assert.Equal(t, liability, n.Int64())
+	}
+
+	// Once the tree is built, batches are put as usual
+	update := bigs(map[string]int64{"282475250": 7, "111111111": 8})
+	assert.NoError(t, sequential.PutBatch(update))
+	assert.NoError(t, parallel.PutBatch(update))
+	assert.Equal(t, sequentialDB, parallelDB)
+
+	// A build that fails leaves neither the subtrees it committed nor the root in the DB
+	db := &faultyDB{MemDB: make(kv.MemDB)}
+	failed := NewVerkleTree(7, digitPath(t, 7), db)
+	failed.PP = sequential.PP
+	failed.ParallelBuild = true
+	db.failGet = fmt.Sprintf(".%d", pathOf(t, 7, "123456789")[0])
+	assert.True(t, errors.Is(failed.PutBatch(bigs(liabilities)), errFault))
+	assert.Empty(t, db.MemDB)
+	assert.Nil(t, failed.Tree.Root)
+	assert.Equal(t, uint64(0), failed.Version)
+
+	assert.NoError(t, failed.PutBatch(bigs(liabilities)))
+	for id, liability := range liabilities {
+		n, _, err := failed.Get(id)
+		assert.NoError(t, err)
+		assert.Equal(t, liability, n.Int64())
+	}
+}
+
+// bigs converts liabilities to the type the tree holds
+func bigs(liabilities map[string]int64) map[string]*big.Int {
+	res := make(map[string]*big.Int, len(liabilities))
+	for id, liability := range liabilities {
+		res[id] = big.NewInt(liability)
+	}
+	return res
+}
+
+func balancesOf(balances ...int64) []*big.Int {
+	res := make([]*big.Int, len(balances))
+	for i, balance := range balances {
+		res[i] = big.NewInt(balance)
+	}
+	return res
+}
+
+func int64s(balances []*big.Int) []int64 {
+	res := make([]int64, len(balances))
+	for i, balance := range balances {
+		res[i] = balance.Int64()
+	}
+	return res
+}
+
+func TestIncrementalUpdateMatchesRecommit(t *testing.T) {
+	db := make(kv.MemDB)
+	tree := NewVerkleTree(7, digitPath(t, 7), db)
+
+	// Ids that share vertices in various depths
+	ids := []string{"282475250", "564950499", "282475251", "282475257", "987654321", "987654322"}
+
+	random := mathrand.New(mathrand.NewSource(42))
+
+	for i := 0; i < 60; i++ {
+		id := ids[random.Intn(len(ids))]
+		if random.Intn(4) == 0 {
+			assert.NoError(t, ignoreNotFound(tree.Delete(id)))
+		} else {
+			assert.NoError(t, tree.Put(id, big.NewInt(random.Int63n(1000))))
+		}
+
+		for key, raw := range db {
+			if key == stateKey {
+				continue
+			}
+			v := &Vertex{}
+			assert.NoError(t, v.FromBytes(raw))
+
+			recommitted := &Vertex{}
+			assert.NoError(t, recommitted.FromBytes(raw))
+			tree.commit(recommitted, v.W != nil)
+
+			assert.True(t, recommitted.V.Equals(v.V), "V of %s differs from a full recommit after %d operations", key, i+1)
+			if v.W != nil {
+				assert.True(t, recommitted.W.Equals(v.W), "W of %s differs from a full recommit after %d operations", key, i+1)
+			}
+
+			// The digests are the digests of the descendants
+			for j, digest := range v.Digests {
+				descendant := &Vertex{}
+				assert.NoError(t, descendant.FromBytes(db[fmt.Sprintf("%s.%d", key, j)]))
+				assert.True(t, descendant.Digest().Equals(digest))
+			}
+
+			// The sum is the sum of the values
+			sum := c.NewZrFromInt(0)
+			for _, val := range v.values {
+				sum = updateSum(sum, c.NewZrFromInt(0), val)
+			}
+			assert.Equal(t, common.ZrToBytes(sum), common.ZrToBytes(v.sums[0]))
+		}
+	}
+}
+
+func TestMultiAsset(t *testing.T) {
+	db := make(kv.MemDB)
+	tree := NewMultiAssetVerkleTree(7, 3, digitPath(t, 7), db)
+	assert.Equal(t, 3*8+1, tree.PP.N)
+
+	assert.NoError(t, tree.PutBalances("282475250", balancesOf(1, 2, 3)))
+	assert.NoError(t, tree.PutBalances("564950499", balancesOf(10, 20)))
+	assert.NoError(t, tree.PutBatchBalances(map[string][]*big.Int{
+		"987654321": balancesOf(100, 200, 300),
+		"282475251": balancesOf(1000, 0, 3000),
+	}))
+
+	balances, _, err := tree.GetBalances("564950499")
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{10, 20, 0}, int64s(balances))
+
+	assertSums := func(expected ...int64) {
+		root := &Vertex{}
+		assert.NoError(t, root.FromBytes(db[""]))
+		for asset, sum := range expected {
+			assert.Equal(t, common.ZrToBytes(c.NewZrFromInt(sum)), common.ZrToBytes(root.Sums()[asset]))
+		}
+	}
+	assertSums(1111, 222, 3303)
+
+	assert.NoError(t, tree.PutBalances("282475250", balancesOf(4, 5, 6)))
+	assertSums(1114, 225, 3306)
+
+	assert.NoError(t, tree.Delete("987654321"))
+	assertSums(1014, 25, 3006)
+
+	// Every vertex matches a full recommit, and the sum of every asset is the sum of its values
+	for key, raw := range db {
+		if key == stateKey {
+			continue
+		}
+		v := &Vertex{}
+		assert.NoError(t, v.FromBytes(raw))
+
+		recommitted := &Vertex{}
+		assert.NoError(t, recommitted.FromBytes(raw))
+		tree.commit(recommitted, v.W != nil)
+		assert.True(t, recommitted.V.Equals(v.V), "V of %s differs from a full recommit", key)
+
+		m := v.Values(3 * 8)
+		for asset := 0; asset < 3; asset++ {
+			sum := c.NewZrFromInt(0)
+			for _, val := range m[asset*8 : asset*8+7] {
+				sum = updateSum(sum, c.NewZrFromInt(0), val)
+			}
+			assert.Equal(t, common.ZrToBytes(sum), common.ZrToBytes(m[asset*8+7]))
+		}
+	}
+
+	opened, err := OpenMultiAssetVerkleTree(7, 3, digitPath(t, 7), db)
+	assert.NoError(t, err)
+	balances, _, err = opened.GetBalances("282475250")
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{4, 5, 6}, int64s(balances))
+
+	_, err = OpenMultiAssetVerkleTree(7, 2, digitPath(t, 7), db)
+	assert.Error(t, err)
+
+	err = tree.PutBalances("282475250", balancesOf(1, 2, 3, 4))
+	assert.True(t, errors.Is(err, common.ErrInvalidLiability))
+	err = tree.PutBatchBalances(map[string][]*big.Int{"282475250": balancesOf(1), "564950499": balancesOf(-1)})
+	assert.True(t, errors.Is(err, common.ErrInvalidLiability))
+	balances, _, _ = tree.GetBalances("282475250")
+	assert.Equal(t, []int64{4, 5, 6}, int64s(balances))
+}
+
+func TestBalancesBeyond64Bits(t *testing.T) {
+	db := make(kv.MemDB)
+	tree := NewVerkleTree(7, digitPath(t, 7), db)
+
+	large := new(big.Int).Lsh(big.NewInt(1), 100)
+	assert.NoError(t, tree.Put("564950499", large))
+	assert.NoError(t, tree.Put("282475250", new(big.Int).Add(large, big.NewInt(1))))
+
+	root := &Vertex{}
+	assert.NoError(t, root.FromBytes(db[""]))
+	expected := new(big.Int).Add(new(big.Int).Lsh(large, 1), big.NewInt(1))
+	assert.Equal(t, expected.String(), common.ZrToBig(root.Sums()[0]).String())
+
+	opened, err := OpenVerkleTree(7, digitPath(t, 7), db)
+	assert.NoError(t, err)
+	balance, _, err := opened.Get("564950499")
+	assert.NoError(t, err)
+	assert.Equal(t, large.String(), balance.String())
+}
+
+// faultyDB fails the next read of a key and the commits of batches on demand
+type faultyDB struct {
+	kv.MemDB
+	failGet    string
+	failCommit bool
+}
+
+var errFault = errors.New("fault")
+
+func (db *faultyDB) Get(key []byte) ([]byte, error) {
+	if db.failGet != "" && string(key) == db.failGet {
+		db.failGet = ""
+		return nil, errFault
+	}
+	return db.MemDB.Get(key)
+}
+
+func (db *faultyDB) NewBatch() Batch {
+	return &faultyBatch{Batch: db.MemDB.NewBatch(), db: db}
+}
+
+type faultyBatch struct {
+	Batch
+	db *faultyDB
+}
+
+func (b *faultyBatch) Commit() error {
+	if b.db.failCommit {
+		return errFault
+	}
+	return b.Batch.Commit()
+}
+
+func TestAtomicUpdates(t *testing.T) {
+	db := &faultyDB{MemDB: make(kv.MemDB)}
+	tree := NewVerkleTree(7, digitPath(t, 7), db)
+
+	assert.NoError(t, tree.Put("282475250", big.NewInt(1)))
+	assert.NoError(t, tree.Put("987654321", big.NewInt(2)))
+
+	before := make(kv.MemDB)
+	for key, val := range db.MemDB {
+		before[key] = val
+	}
+
+	assertUnchanged := func() {
+		assert.Equal(t, before, db.MemDB)
+		for id, expected := range map[string]int64{"282475250": 1, "987654321": 2} {
+			n, _, err := tree.Get(id)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, n.Int64())
+		}
+		_, _, err := tree.Get("564950499")
+		assert.True(t, errors.Is(err, common.ErrNotFound))
+	}
+
+	// None of the vertices along the path is written if the batch cannot be committed
+	db.failCommit = true
+	assert.True(t, errors.Is(tree.Put("564950499", big.NewInt(3)), errFault))
+	assertUnchanged()
+	assert.True(t, errors.Is(tree.PutBatch(map[string]*big.Int{"564950499": big.NewInt(3), "282475250": big.NewInt(4)}), errFault))
+	assertUnchanged()
+	assert.True(t, errors.Is(tree.Delete("987654321"), errFault))
+	assertUnchanged()
+	db.failCommit = false
+
+	// Nor if a vertex along the path cannot be loaded
+	db.failGet = fmt.Sprintf(".%d", pathOf(t, 7, "564950499")[0])
+	assert.True(t, errors.Is(tree.Put("564950499", big.NewInt(3)), errFault))
+	assertUnchanged()
+
+	// Once the DB recovers, the tree is the same as a tree that never failed
+	assert.NoError(t, tree.Put("564950499", big.NewInt(3)))
+	_, vertices, err := tree.Get("564950499")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), sumOf(t, vertices[0]))
+	for _, v := range vertices {
+		m := v.Values(int(tree.PP.N - 1))
+		assert.True(t, v.V.Equals(pp.Commit(tree.PP, append(m, v.BlindingFactor))))
+	}
+}
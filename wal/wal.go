@@ -0,0 +1,314 @@
+// Package wal implements an append-only log of the updates of a liability set.
+// Every record of the log holds one update, and is prefixed with its length and a CRC-32C checksum of the length and the update.
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/big"
+	"os"
+	"pol/common"
+)
+
+// ErrCorrupted is returned for a log with a record that does not match its checksum, or cannot be decoded.
+// A record that was only partially written at the end of the log is not considered corrupted, and is discarded when the log is opened.
+var ErrCorrupted = errors.New("log is corrupted")
+
+const (
+	// headerSize is the size of the length and checksum that precede every record
+	headerSize = 8
+	// maxPayloadSize bounds the size of an update, so that a corrupted length is told apart from a partially written record
+	maxPayloadSize = 1 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Kind is the kind of an update
+type Kind uint32
+
+const (
+	// Set sets the balances of an id
+	Set Kind = iota + 1
+	// Delete removes an id
+	Delete
+	// Snapshot takes a snapshot of an epoch
+	Snapshot
+)
+
+// Op is an update of a liability set
+type Op struct {
+	// Seq is the sequence number of the update, which is assigned when it is appended to the log, starting from 1.
+	Seq  uint64
+	Kind Kind
+	// ID is the id that is set or deleted
+	ID string
+	// Balances are the balances of every asset that the id is set to
+	Balances []*big.Int
+	// Epoch is the epoch of the snapshot
+	Epoch uint64
+}
+
+func (op Op) encode() ([]byte, error) {
+	e := common.NewEncoder()
+	e.Uint64(op.Seq)
+	e.Uint32(uint32(op.Kind))
+	e.Bytes([]byte(op.ID))
+	e.Uint32(uint32(len(op.Balances)))
+	for _, balance := range op.Balances {
+		if balance == nil || balance.Sign() < 0 {
+			return nil, fmt.Errorf("balances of %s must not be negative", op.ID)
+		}
+		e.Bytes(balance.Bytes())
+	}
+	e.Uint64(op.Epoch)
+	return e.Result()
+}
+
+func decode(b []byte) (Op, error) {
+	d := common.NewDecoder(b)
+	op := Op{
+		Seq:  d.Uint64(),
+		Kind: Kind(d.Uint32()),
+		ID:   string(d.Bytes()),
+	}
+	// Every balance takes up at least its length
+	op.Balances = make([]*big.Int, d.Len(4))
+	for i := range op.Balances {
+		op.Balances[i] = new(big.Int).SetBytes(d.Bytes())
+	}
+	op.Epoch = d.Uint64()
+
+	if err := d.Finish(); err != nil {
+		return Op{}, err
+	}
+	if op.Kind < Set || op.Kind > Snapshot {
+		return Op{}, fmt.Errorf("unknown kind %d", op.Kind)
+	}
+	return op, nil
+}
+
+// Log is an append-only log of updates in a file. It is not safe for concurrent use.
+type Log struct {
+	file *os.File
+	// size is the size of the records in the file
+	size int64
+	// seq is the sequence number of the last update
+	seq uint64
+	// epoch is the epoch of the last snapshot
+	epoch uint64
+}
+
+// Open opens the log in the given file, and creates it if it does not exist.
+// A record that was only partially written at the end of the log, such as by a crash in the middle of an append, is discarded.
+// A record is only considered partially written if it runs past the end of the file and no record follows it.
+// Fails with ErrCorrupted if any other record does not match its checksum.
+func Open(path string) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{file: file}
+	torn, err := l.scan()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if torn {
+		if err := l.truncate(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// scan reads all the records of the log, and returns whether the log ends with a record that was only partially written.
+func (l *Log) scan() (bool, error) {
+	info, err := l.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	end := info.Size()
+
+	r := bufio.NewReader(io.NewSectionReader(l.file, 0, end))
+	for l.size < end {
+		op, n, err := readRecord(r, end-l.size)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// A record whose length was corrupted may run past the end of the file as well,
+			// but then the records after it are still in the file
+			followed, err := l.followedByRecord(l.size+1, end)
+			if err != nil {
+				return false, err
+			}
+			if followed {
+				return false, fmt.Errorf("%w: record at offset %d runs past the end of the log, but is followed by other records", ErrCorrupted, l.size)
+			}
+			return true, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("record at offset %d: %w", l.size, err)
+		}
+		if op.Seq != l.seq+1 {
+			return false, fmt.Errorf("%w: record at offset %d has sequence number %d after %d", ErrCorrupted, l.size, op.Seq, l.seq)
+		}
+
+		l.size += n
+		l.seq = op.Seq
+		if op.Kind == Snapshot {
+			l.epoch = op.Epoch
+		}
+	}
+
+	return false, nil
+}
+
+// followedByRecord returns whether a record that matches its checksum starts anywhere in the file between from and end.
+// It is only called for the bytes after a record that runs past the end of the file, which are fewer than maxPayloadSize.
+func (l *Log) followedByRecord(from, end int64) (bool, error) {
+	if end-from < headerSize {
+		return false, nil
+	}
+	rest := make([]byte, end-from)
+	if _, err := l.file.ReadAt(rest, from); err != nil {
+		return false, err
+	}
+
+	for i := 0; i+headerSize <= len(rest); i++ {
+		length := binary.BigEndian.Uint32(rest[i:])
+		if length > maxPayloadSize || headerSize+int(length) > len(rest)-i {
+			continue
+		}
+		payload := rest[i+headerSize : i+headerSize+int(length)]
+		if checksum(rest[i:i+4], payload) == binary.BigEndian.Uint32(rest[i+4:]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checksum returns the checksum of a record, which covers its length as well as its payload
+func checksum(length, payload []byte) uint32 {
+	return crc32.Update(crc32.Checksum(length, crcTable), crcTable, payload)
+}
+
+// readRecord reads a record from the given number of remaining bytes, and returns its update and its size.
+// Returns io.ErrUnexpectedEOF if the record does not fit in the remaining bytes.
+func readRecord(r io.Reader, remaining int64) (Op, int64, error) {
+	if remaining < headerSize {
+		return Op{}, 0, io.ErrUnexpectedEOF
+	}
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return Op{}, 0, err
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	if length > maxPayloadSize {
+		return Op{}, 0, fmt.Errorf("%w: record of %d bytes exceeds the maximum of %d", ErrCorrupted, length, maxPayloadSize)
+	}
+	size := headerSize + int64(length)
+	if size > remaining {
+		return Op{}, 0, io.ErrUnexpectedEOF
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return Op{}, size, err
+	}
+
+	if checksum(header[:4], payload) != binary.BigEndian.Uint32(header[4:]) {
+		return Op{}, size, fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+	}
+
+	op, err := decode(payload)
+	if err != nil {
+		return Op{}, size, fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+
+	return op, size, nil
+}
+
+// truncate discards everything in the file after the records of the log
+func (l *Log) truncate() error {
+	if err := l.file.Truncate(l.size); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// Append assigns the next sequence number to the update and appends it to the log.
+// The update is synced to disk before Append returns, and the sequence number is returned.
+func (l *Log) Append(op Op) (uint64, error) {
+	op.Seq = l.seq + 1
+
+	payload, err := op.encode()
+	if err != nil {
+		return 0, err
+	}
+	if len(payload) > maxPayloadSize {
+		return 0, fmt.Errorf("update of %d bytes exceeds the maximum of %d", len(payload), maxPayloadSize)
+	}
+
+	record := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], checksum(record[:4], payload))
+	copy(record[headerSize:], payload)
+
+	_, err = l.file.WriteAt(record, l.size)
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		// Do not leave a partial record for the next record to be appended after
+		if truncateErr := l.truncate(); truncateErr != nil {
+			return 0, fmt.Errorf("%w (and discarding the partial record failed: %v)", err, truncateErr)
+		}
+		return 0, err
+	}
+
+	l.size += int64(len(record))
+	l.seq = op.Seq
+	if op.Kind == Snapshot {
+		l.epoch = op.Epoch
+	}
+
+	return op.Seq, nil
+}
+
+// Ops passes every update in the log to f in the order they were appended, and stops at the first error returned by f.
+func (l *Log) Ops(f func(Op) error) error {
+	r := bufio.NewReader(io.NewSectionReader(l.file, 0, l.size))
+	for offset := int64(0); offset < l.size; {
+		op, n, err := readRecord(r, l.size-offset)
+		if err != nil {
+			return fmt.Errorf("record at offset %d: %w", offset, err)
+		}
+		if err := f(op); err != nil {
+			return err
+		}
+		offset += n
+	}
+	return nil
+}
+
+// Seq returns the sequence number of the last update in the log, or 0 if the log is empty.
+func (l *Log) Seq() uint64 {
+	return l.seq
+}
+
+// Epoch returns the epoch of the last snapshot in the log, or 0 if there is none.
+func (l *Log) Epoch() uint64 {
+	return l.epoch
+}
+
+// Close closes the file of the log.
+func (l *Log) Close() error {
+	return l.file.Close()
+}
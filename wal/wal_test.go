@@ -0,0 +1,157 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var ops = []Op{
+	{Kind: Set, ID: "282475250", Balances: []*big.Int{big.NewInt(100)}},
+	{Kind: Set, ID: "564950499", Balances: []*big.Int{big.NewInt(200), big.NewInt(0), new(big.Int).Lsh(big.NewInt(1), 100)}},
+	{Kind: Snapshot, Epoch: 7},
+	{Kind: Delete, ID: "282475250"},
+}
+
+func appendOps(t *testing.T, path string) {
+	l, err := Open(path)
+	assert.NoError(t, err)
+	defer l.Close()
+
+	for i, op := range ops {
+		seq, err := l.Append(op)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(i+1), seq)
+	}
+}
+
+func readOps(t *testing.T, l *Log) []Op {
+	var read []Op
+	assert.NoError(t, l.Ops(func(op Op) error {
+		read = append(read, op)
+		return nil
+	}))
+	return read
+}
+
+func assertOps(t *testing.T, expected, actual []Op) {
+	assert.Len(t, actual, len(expected))
+	for i := range actual {
+		assert.Equal(t, uint64(i+1), actual[i].Seq)
+		assert.Equal(t, expected[i].Kind, actual[i].Kind)
+		assert.Equal(t, expected[i].ID, actual[i].ID)
+		assert.Equal(t, expected[i].Epoch, actual[i].Epoch)
+		assert.Len(t, actual[i].Balances, len(expected[i].Balances))
+		for j := range actual[i].Balances {
+			assert.Equal(t, 0, expected[i].Balances[j].Cmp(actual[i].Balances[j]))
+		}
+	}
+}
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	appendOps(t, path)
+
+	l, err := Open(path)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), l.Seq())
+	assert.Equal(t, uint64(7), l.Epoch())
+	assertOps(t, ops, readOps(t, l))
+
+	// Appending continues from the last sequence number
+	seq, err := l.Append(Op{Kind: Snapshot, Epoch: 9})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), seq)
+	assert.Equal(t, uint64(9), l.Epoch())
+
+	_, err = l.Append(Op{Kind: Set, ID: "1", Balances: []*big.Int{big.NewInt(-1)}})
+	assert.Error(t, err)
+	assert.Equal(t, uint64(5), l.Seq())
+
+	stop := errors.New("stop")
+	var count int
+	assert.Equal(t, stop, l.Ops(func(Op) error {
+		count++
+		return stop
+	}))
+	assert.Equal(t, 1, count)
+	assert.NoError(t, l.Close())
+}
+
+func TestTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	appendOps(t, path)
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	full := info.Size()
+
+	// A crash in the middle of appending the last record leaves any prefix of it in the file
+	for _, cut := range []int64{1, 5, 8, 12, 20} {
+		assert.NoError(t, os.Truncate(path, full-cut))
+
+		l, err := Open(path)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(3), l.Seq())
+		assertOps(t, ops[:3], readOps(t, l))
+
+		// The partial record is discarded, so the next record is appended in its place
+		_, err = l.Append(ops[3])
+		assert.NoError(t, err)
+		assert.NoError(t, l.Close())
+
+		info, err = os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, full, info.Size())
+	}
+
+	// A last record that fits in the file but does not match its checksum was not torn, so it is not discarded
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	b[len(b)-3] ^= 1
+	assert.NoError(t, os.WriteFile(path, b, 0600))
+
+	_, err = Open(path)
+	assert.True(t, errors.Is(err, ErrCorrupted))
+}
+
+func TestCorruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	appendOps(t, path)
+
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	// A record in the middle of the log that does not match its checksum
+	corrupted := append([]byte(nil), b...)
+	corrupted[headerSize+2] ^= 1
+	assert.NoError(t, os.WriteFile(path, corrupted, 0600))
+	_, err = Open(path)
+	assert.True(t, errors.Is(err, ErrCorrupted))
+
+	// A record in the middle of the log whose length was corrupted must not be mistaken for a torn tail,
+	// whether its length runs past the end of the log or ends exactly where the log ends
+	first := headerSize + int(b[3])
+	for _, length := range []uint32{uint32(len(b)), uint32(len(b) - first - headerSize)} {
+		corrupted = append([]byte(nil), b...)
+		binary.BigEndian.PutUint32(corrupted[first:], length)
+		assert.NoError(t, os.WriteFile(path, corrupted, 0600))
+		_, err = Open(path)
+		assert.True(t, errors.Is(err, ErrCorrupted), length)
+
+		// The records after it are left in place
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(b)), info.Size())
+	}
+
+	// A record that matches its checksum but is not in sequence, such as when records were lost
+	assert.NoError(t, os.WriteFile(path, b[first:], 0600))
+	_, err = Open(path)
+	assert.True(t, errors.Is(err, ErrCorrupted))
+}